@@ -0,0 +1,247 @@
+// Command oidc-stub is a minimal OpenID Connect provider for trying the
+// gateway's OIDC login locally. It signs in whoever asks: the authorize
+// endpoint shows a form, or approves at once when given ?username=.
+// Never expose it.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "stub-1"
+
+// authorization is an issued code waiting to be redeemed.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	username      string
+	email         string
+	expiresAt     time.Time
+}
+
+type stub struct {
+	issuer       string
+	clientID     string
+	redirectURIs []string
+	key          *rsa.PrivateKey
+
+	mutex sync.Mutex
+	codes map[string]*authorization
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><body>
+<h3>Stub identity provider</h3>
+<form method="GET" action="/authorize">
+{{range $name, $values := .}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">{{end}}{{end}}
+<p><label>Username <input name="username" required></label></p>
+<p><label>Email <input name="email" type="email"></label></p>
+<button type="submit">Sign in</button>
+</form>
+</body></html>`))
+
+func randomString() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (s *stub) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *stub) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	public := s.key.PublicKey
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *stub) validRedirect(uri string) bool {
+	for _, allowed := range s.redirectURIs {
+		if uri == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stub) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") != s.clientID || !s.validRedirect(redirectURI) {
+		http.Error(w, "unknown client_id or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "response_type=code with an S256 code_challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	username := query.Get("username")
+	if username == "" {
+		loginForm.Execute(w, query)
+		return
+	}
+	email := query.Get("email")
+	if email == "" {
+		email = username + "@example.com"
+	}
+
+	code := randomString()
+	s.mutex.Lock()
+	s.codes[code] = &authorization{
+		clientID:      s.clientID,
+		redirectURI:   redirectURI,
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+		username:      username,
+		email:         email,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	s.mutex.Unlock()
+
+	callback := url.Values{}
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	http.Redirect(w, r, redirectURI+"?"+callback.Encode(), http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+func (s *stub) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mutex.Lock()
+	auth, exists := s.codes[code]
+	delete(s.codes, code) // Codes work once
+	s.mutex.Unlock()
+
+	if !exists || time.Now().After(auth.expiresAt) {
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.PostForm.Get("client_id") != auth.clientID || r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		tokenError(w, "invalid_grant", "client_id or redirect_uri does not match")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.codeChallenge {
+		tokenError(w, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                s.issuer,
+		"sub":                "stub|" + auth.username,
+		"aud":                auth.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              auth.nonce,
+		"email":              auth.email,
+		"email_verified":     true,
+		"preferred_username": auth.username,
+	})
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func main() {
+	addr := os.Getenv("STUB_ADDR")
+	if addr == "" {
+		addr = ":9000"
+	}
+	issuer := os.Getenv("STUB_ISSUER")
+	if issuer == "" {
+		issuer = "http://localhost:9000"
+	}
+	clientID := os.Getenv("STUB_CLIENT_ID")
+	if clientID == "" {
+		clientID = "kubechat"
+	}
+	redirectURIs := os.Getenv("STUB_REDIRECT_URIS")
+	if redirectURIs == "" {
+		redirectURIs = "http://localhost:8080/auth/oidc/callback"
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+
+	s := &stub{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		redirectURIs: strings.Split(redirectURIs, ","),
+		key:          key,
+		codes:        make(map[string]*authorization),
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", s.handleDiscovery)
+	http.HandleFunc("/jwks", s.handleJWKS)
+	http.HandleFunc("/authorize", s.handleAuthorize)
+	http.HandleFunc("/token", s.handleToken)
+
+	log.Printf("Stub OIDC provider %s listening on %s", s.issuer, addr)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
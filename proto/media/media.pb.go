@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.6
+// 	protoc-gen-go v1.36.8
 // 	protoc        v6.33.4
 // source: proto/media/media.proto
 
@@ -150,6 +150,182 @@ func (x *MediaMetadata) GetUploadedAt() *timestamppb.Timestamp {
 	return nil
 }
 
+type ListUserMediaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUserMediaRequest) Reset() {
+	*x = ListUserMediaRequest{}
+	mi := &file_proto_media_media_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUserMediaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUserMediaRequest) ProtoMessage() {}
+
+func (x *ListUserMediaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_media_media_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUserMediaRequest.ProtoReflect.Descriptor instead.
+func (*ListUserMediaRequest) Descriptor() ([]byte, []int) {
+	return file_proto_media_media_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListUserMediaRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type ListUserMediaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Media         []*MediaMetadata       `protobuf:"bytes,1,rep,name=media,proto3" json:"media,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUserMediaResponse) Reset() {
+	*x = ListUserMediaResponse{}
+	mi := &file_proto_media_media_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUserMediaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUserMediaResponse) ProtoMessage() {}
+
+func (x *ListUserMediaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_media_media_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUserMediaResponse.ProtoReflect.Descriptor instead.
+func (*ListUserMediaResponse) Descriptor() ([]byte, []int) {
+	return file_proto_media_media_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListUserMediaResponse) GetMedia() []*MediaMetadata {
+	if x != nil {
+		return x.Media
+	}
+	return nil
+}
+
+type DeleteUserMediaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserMediaRequest) Reset() {
+	*x = DeleteUserMediaRequest{}
+	mi := &file_proto_media_media_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserMediaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserMediaRequest) ProtoMessage() {}
+
+func (x *DeleteUserMediaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_media_media_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserMediaRequest.ProtoReflect.Descriptor instead.
+func (*DeleteUserMediaRequest) Descriptor() ([]byte, []int) {
+	return file_proto_media_media_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DeleteUserMediaRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type DeleteUserMediaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deleted       int32                  `protobuf:"varint,1,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserMediaResponse) Reset() {
+	*x = DeleteUserMediaResponse{}
+	mi := &file_proto_media_media_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserMediaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserMediaResponse) ProtoMessage() {}
+
+func (x *DeleteUserMediaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_media_media_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserMediaResponse.ProtoReflect.Descriptor instead.
+func (*DeleteUserMediaResponse) Descriptor() ([]byte, []int) {
+	return file_proto_media_media_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeleteUserMediaResponse) GetDeleted() int32 {
+	if x != nil {
+		return x.Deleted
+	}
+	return 0
+}
+
 var File_proto_media_media_proto protoreflect.FileDescriptor
 
 const file_proto_media_media_proto_rawDesc = "" +
@@ -164,9 +340,19 @@ const file_proto_media_media_proto_rawDesc = "" +
 	"\x04size\x18\x04 \x01(\x03R\x04size\x12\x10\n" +
 	"\x03url\x18\x05 \x01(\tR\x03url\x12;\n" +
 	"\vuploaded_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
-	"uploadedAt2N\n" +
+	"uploadedAt\"/\n" +
+	"\x14ListUserMediaRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\"C\n" +
+	"\x15ListUserMediaResponse\x12*\n" +
+	"\x05media\x18\x01 \x03(\v2\x14.media.MediaMetadataR\x05media\"1\n" +
+	"\x16DeleteUserMediaRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\"3\n" +
+	"\x17DeleteUserMediaResponse\x12\x18\n" +
+	"\adeleted\x18\x01 \x01(\x05R\adeleted2\xec\x01\n" +
 	"\fMediaService\x12>\n" +
-	"\vGetMetadata\x12\x19.media.GetMetadataRequest\x1a\x14.media.MediaMetadataB\x0fZ\r./proto/mediab\x06proto3"
+	"\vGetMetadata\x12\x19.media.GetMetadataRequest\x1a\x14.media.MediaMetadata\x12J\n" +
+	"\rListUserMedia\x12\x1b.media.ListUserMediaRequest\x1a\x1c.media.ListUserMediaResponse\x12P\n" +
+	"\x0fDeleteUserMedia\x12\x1d.media.DeleteUserMediaRequest\x1a\x1e.media.DeleteUserMediaResponseB\x0fZ\r./proto/mediab\x06proto3"
 
 var (
 	file_proto_media_media_proto_rawDescOnce sync.Once
@@ -180,21 +366,30 @@ func file_proto_media_media_proto_rawDescGZIP() []byte {
 	return file_proto_media_media_proto_rawDescData
 }
 
-var file_proto_media_media_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_media_media_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_proto_media_media_proto_goTypes = []any{
-	(*GetMetadataRequest)(nil),    // 0: media.GetMetadataRequest
-	(*MediaMetadata)(nil),         // 1: media.MediaMetadata
-	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
+	(*GetMetadataRequest)(nil),      // 0: media.GetMetadataRequest
+	(*MediaMetadata)(nil),           // 1: media.MediaMetadata
+	(*ListUserMediaRequest)(nil),    // 2: media.ListUserMediaRequest
+	(*ListUserMediaResponse)(nil),   // 3: media.ListUserMediaResponse
+	(*DeleteUserMediaRequest)(nil),  // 4: media.DeleteUserMediaRequest
+	(*DeleteUserMediaResponse)(nil), // 5: media.DeleteUserMediaResponse
+	(*timestamppb.Timestamp)(nil),   // 6: google.protobuf.Timestamp
 }
 var file_proto_media_media_proto_depIdxs = []int32{
-	2, // 0: media.MediaMetadata.uploaded_at:type_name -> google.protobuf.Timestamp
-	0, // 1: media.MediaService.GetMetadata:input_type -> media.GetMetadataRequest
-	1, // 2: media.MediaService.GetMetadata:output_type -> media.MediaMetadata
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	6, // 0: media.MediaMetadata.uploaded_at:type_name -> google.protobuf.Timestamp
+	1, // 1: media.ListUserMediaResponse.media:type_name -> media.MediaMetadata
+	0, // 2: media.MediaService.GetMetadata:input_type -> media.GetMetadataRequest
+	2, // 3: media.MediaService.ListUserMedia:input_type -> media.ListUserMediaRequest
+	4, // 4: media.MediaService.DeleteUserMedia:input_type -> media.DeleteUserMediaRequest
+	1, // 5: media.MediaService.GetMetadata:output_type -> media.MediaMetadata
+	3, // 6: media.MediaService.ListUserMedia:output_type -> media.ListUserMediaResponse
+	5, // 7: media.MediaService.DeleteUserMedia:output_type -> media.DeleteUserMediaResponse
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_media_media_proto_init() }
@@ -208,7 +403,7 @@ func file_proto_media_media_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_media_media_proto_rawDesc), len(file_proto_media_media_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
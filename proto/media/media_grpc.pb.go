@@ -19,7 +19,9 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	MediaService_GetMetadata_FullMethodName = "/media.MediaService/GetMetadata"
+	MediaService_GetMetadata_FullMethodName     = "/media.MediaService/GetMetadata"
+	MediaService_ListUserMedia_FullMethodName   = "/media.MediaService/ListUserMedia"
+	MediaService_DeleteUserMedia_FullMethodName = "/media.MediaService/DeleteUserMedia"
 )
 
 // MediaServiceClient is the client API for MediaService service.
@@ -27,6 +29,8 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MediaServiceClient interface {
 	GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*MediaMetadata, error)
+	ListUserMedia(ctx context.Context, in *ListUserMediaRequest, opts ...grpc.CallOption) (*ListUserMediaResponse, error)
+	DeleteUserMedia(ctx context.Context, in *DeleteUserMediaRequest, opts ...grpc.CallOption) (*DeleteUserMediaResponse, error)
 }
 
 type mediaServiceClient struct {
@@ -47,11 +51,33 @@ func (c *mediaServiceClient) GetMetadata(ctx context.Context, in *GetMetadataReq
 	return out, nil
 }
 
+func (c *mediaServiceClient) ListUserMedia(ctx context.Context, in *ListUserMediaRequest, opts ...grpc.CallOption) (*ListUserMediaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUserMediaResponse)
+	err := c.cc.Invoke(ctx, MediaService_ListUserMedia_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mediaServiceClient) DeleteUserMedia(ctx context.Context, in *DeleteUserMediaRequest, opts ...grpc.CallOption) (*DeleteUserMediaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteUserMediaResponse)
+	err := c.cc.Invoke(ctx, MediaService_DeleteUserMedia_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MediaServiceServer is the server API for MediaService service.
 // All implementations must embed UnimplementedMediaServiceServer
 // for forward compatibility.
 type MediaServiceServer interface {
 	GetMetadata(context.Context, *GetMetadataRequest) (*MediaMetadata, error)
+	ListUserMedia(context.Context, *ListUserMediaRequest) (*ListUserMediaResponse, error)
+	DeleteUserMedia(context.Context, *DeleteUserMediaRequest) (*DeleteUserMediaResponse, error)
 	mustEmbedUnimplementedMediaServiceServer()
 }
 
@@ -65,6 +91,12 @@ type UnimplementedMediaServiceServer struct{}
 func (UnimplementedMediaServiceServer) GetMetadata(context.Context, *GetMetadataRequest) (*MediaMetadata, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetMetadata not implemented")
 }
+func (UnimplementedMediaServiceServer) ListUserMedia(context.Context, *ListUserMediaRequest) (*ListUserMediaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserMedia not implemented")
+}
+func (UnimplementedMediaServiceServer) DeleteUserMedia(context.Context, *DeleteUserMediaRequest) (*DeleteUserMediaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUserMedia not implemented")
+}
 func (UnimplementedMediaServiceServer) mustEmbedUnimplementedMediaServiceServer() {}
 func (UnimplementedMediaServiceServer) testEmbeddedByValue()                      {}
 
@@ -104,6 +136,42 @@ func _MediaService_GetMetadata_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MediaService_ListUserMedia_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUserMediaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MediaServiceServer).ListUserMedia(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MediaService_ListUserMedia_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MediaServiceServer).ListUserMedia(ctx, req.(*ListUserMediaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MediaService_DeleteUserMedia_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserMediaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MediaServiceServer).DeleteUserMedia(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MediaService_DeleteUserMedia_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MediaServiceServer).DeleteUserMedia(ctx, req.(*DeleteUserMediaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MediaService_ServiceDesc is the grpc.ServiceDesc for MediaService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +183,14 @@ var MediaService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetMetadata",
 			Handler:    _MediaService_GetMetadata_Handler,
 		},
+		{
+			MethodName: "ListUserMedia",
+			Handler:    _MediaService_ListUserMedia_Handler,
+		},
+		{
+			MethodName: "DeleteUserMedia",
+			Handler:    _MediaService_DeleteUserMedia_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/media/media.proto",
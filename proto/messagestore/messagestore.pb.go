@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.6
+// 	protoc-gen-go v1.36.8
 // 	protoc        v6.33.4
 // source: proto/messagestore/messagestore.proto
 
@@ -29,6 +29,7 @@ type StoreMessageRequest struct {
 	RecipientId   string                 `protobuf:"bytes,3,opt,name=recipient_id,json=recipientId,proto3" json:"recipient_id,omitempty"`
 	Content       string                 `protobuf:"bytes,4,opt,name=content,proto3" json:"content,omitempty"`
 	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	RoomId        string                 `protobuf:"bytes,6,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -98,6 +99,13 @@ func (x *StoreMessageRequest) GetTimestamp() *timestamppb.Timestamp {
 	return nil
 }
 
+func (x *StoreMessageRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
 type StoreMessageResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -158,6 +166,8 @@ type GetMessageHistoryRequest struct {
 	Offset        int32                  `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"` // Deprecated
 	LastMessageId string                 `protobuf:"bytes,5,opt,name=last_message_id,json=lastMessageId,proto3" json:"last_message_id,omitempty"`
 	LastTimestamp *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=last_timestamp,json=lastTimestamp,proto3" json:"last_timestamp,omitempty"`
+	RoomId        string                 `protobuf:"bytes,7,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`       // Room-scoped history; user_id1/user_id2 are ignored
+	ViewerId      string                 `protobuf:"bytes,8,opt,name=viewer_id,json=viewerId,proto3" json:"viewer_id,omitempty"` // Excludes messages this user deleted for themselves
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -234,6 +244,20 @@ func (x *GetMessageHistoryRequest) GetLastTimestamp() *timestamppb.Timestamp {
 	return nil
 }
 
+func (x *GetMessageHistoryRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *GetMessageHistoryRequest) GetViewerId() string {
+	if x != nil {
+		return x.ViewerId
+	}
+	return ""
+}
+
 type GetMessageHistoryResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Messages      []*StoredMessage       `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
@@ -282,6 +306,7 @@ type DeleteMessageRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
 	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	ForEveryone   bool                   `protobuf:"varint,3,opt,name=for_everyone,json=forEveryone,proto3" json:"for_everyone,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -330,10 +355,18 @@ func (x *DeleteMessageRequest) GetUserId() string {
 	return ""
 }
 
+func (x *DeleteMessageRequest) GetForEveryone() bool {
+	if x != nil {
+		return x.ForEveryone
+	}
+	return false
+}
+
 type DeleteMessageResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
 	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Message       *StoredMessage         `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"` // Tombstone or hidden message, for notifying participants
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -382,6 +415,13 @@ func (x *DeleteMessageResponse) GetError() string {
 	return ""
 }
 
+func (x *DeleteMessageResponse) GetMessage() *StoredMessage {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
 type StoredMessage struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
@@ -390,6 +430,9 @@ type StoredMessage struct {
 	Content       string                 `protobuf:"bytes,4,opt,name=content,proto3" json:"content,omitempty"`
 	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	RoomId        string                 `protobuf:"bytes,7,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	EditedAt      *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=edited_at,json=editedAt,proto3" json:"edited_at,omitempty"`
+	Deleted       bool                   `protobuf:"varint,9,opt,name=deleted,proto3" json:"deleted,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -466,50 +509,1810 @@ func (x *StoredMessage) GetCreatedAt() *timestamppb.Timestamp {
 	return nil
 }
 
-var File_proto_messagestore_messagestore_proto protoreflect.FileDescriptor
+func (x *StoredMessage) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
 
-const file_proto_messagestore_messagestore_proto_rawDesc = "" +
-	"\n" +
-	"%proto/messagestore/messagestore.proto\x12\fmessagestore\x1a\x1fgoogle/protobuf/timestamp.proto\"\xc8\x01\n" +
-	"\x13StoreMessageRequest\x12\x1d\n" +
-	"\n" +
-	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x1b\n" +
-	"\tsender_id\x18\x02 \x01(\tR\bsenderId\x12!\n" +
-	"\frecipient_id\x18\x03 \x01(\tR\vrecipientId\x12\x18\n" +
-	"\acontent\x18\x04 \x01(\tR\acontent\x128\n" +
-	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\"F\n" +
-	"\x14StoreMessageResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
-	"\x05error\x18\x02 \x01(\tR\x05error\"\xe9\x01\n" +
-	"\x18GetMessageHistoryRequest\x12\x19\n" +
-	"\buser_id1\x18\x01 \x01(\tR\auserId1\x12\x19\n" +
-	"\buser_id2\x18\x02 \x01(\tR\auserId2\x12\x14\n" +
-	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x16\n" +
-	"\x06offset\x18\x04 \x01(\x05R\x06offset\x12&\n" +
-	"\x0flast_message_id\x18\x05 \x01(\tR\rlastMessageId\x12A\n" +
-	"\x0elast_timestamp\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\rlastTimestamp\"T\n" +
-	"\x19GetMessageHistoryResponse\x127\n" +
-	"\bmessages\x18\x01 \x03(\v2\x1b.messagestore.StoredMessageR\bmessages\"N\n" +
-	"\x14DeleteMessageRequest\x12\x1d\n" +
-	"\n" +
-	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x17\n" +
-	"\auser_id\x18\x02 \x01(\tR\x06userId\"G\n" +
-	"\x15DeleteMessageResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
-	"\x05error\x18\x02 \x01(\tR\x05error\"\xfd\x01\n" +
-	"\rStoredMessage\x12\x1d\n" +
-	"\n" +
-	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x1b\n" +
-	"\tsender_id\x18\x02 \x01(\tR\bsenderId\x12!\n" +
-	"\frecipient_id\x18\x03 \x01(\tR\vrecipientId\x12\x18\n" +
-	"\acontent\x18\x04 \x01(\tR\acontent\x128\n" +
-	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x129\n" +
-	"\n" +
-	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt2\xac\x02\n" +
-	"\x13MessageStoreService\x12U\n" +
-	"\fStoreMessage\x12!.messagestore.StoreMessageRequest\x1a\".messagestore.StoreMessageResponse\x12d\n" +
-	"\x11GetMessageHistory\x12&.messagestore.GetMessageHistoryRequest\x1a'.messagestore.GetMessageHistoryResponse\x12X\n" +
-	"\rDeleteMessage\x12\".messagestore.DeleteMessageRequest\x1a#.messagestore.DeleteMessageResponseB\x16Z\x14./proto/messagestoreb\x06proto3"
+func (x *StoredMessage) GetEditedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.EditedAt
+	}
+	return nil
+}
+
+func (x *StoredMessage) GetDeleted() bool {
+	if x != nil {
+		return x.Deleted
+	}
+	return false
+}
+
+type EditMessageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Content       string                 `protobuf:"bytes,3,opt,name=content,proto3" json:"content,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EditMessageRequest) Reset() {
+	*x = EditMessageRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EditMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EditMessageRequest) ProtoMessage() {}
+
+func (x *EditMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EditMessageRequest.ProtoReflect.Descriptor instead.
+func (*EditMessageRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *EditMessageRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *EditMessageRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *EditMessageRequest) GetContent() string {
+	if x != nil {
+		return x.Content
+	}
+	return ""
+}
+
+type EditMessageResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       *StoredMessage         `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EditMessageResponse) Reset() {
+	*x = EditMessageResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EditMessageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EditMessageResponse) ProtoMessage() {}
+
+func (x *EditMessageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EditMessageResponse.ProtoReflect.Descriptor instead.
+func (*EditMessageResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *EditMessageResponse) GetMessage() *StoredMessage {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *EditMessageResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *EditMessageResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Cursor over every conversation the user takes part in, identified by the
+// last message they received. last_timestamp is used when the message is
+// unknown to the store.
+type GetMessagesSinceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	LastMessageId string                 `protobuf:"bytes,2,opt,name=last_message_id,json=lastMessageId,proto3" json:"last_message_id,omitempty"`
+	LastTimestamp *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=last_timestamp,json=lastTimestamp,proto3" json:"last_timestamp,omitempty"`
+	Limit         int32                  `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMessagesSinceRequest) Reset() {
+	*x = GetMessagesSinceRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMessagesSinceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMessagesSinceRequest) ProtoMessage() {}
+
+func (x *GetMessagesSinceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMessagesSinceRequest.ProtoReflect.Descriptor instead.
+func (*GetMessagesSinceRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetMessagesSinceRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *GetMessagesSinceRequest) GetLastMessageId() string {
+	if x != nil {
+		return x.LastMessageId
+	}
+	return ""
+}
+
+func (x *GetMessagesSinceRequest) GetLastTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastTimestamp
+	}
+	return nil
+}
+
+func (x *GetMessagesSinceRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type GetChangesSinceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	LastMessageId string                 `protobuf:"bytes,2,opt,name=last_message_id,json=lastMessageId,proto3" json:"last_message_id,omitempty"`
+	LastTimestamp *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=last_timestamp,json=lastTimestamp,proto3" json:"last_timestamp,omitempty"`
+	Limit         int32                  `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetChangesSinceRequest) Reset() {
+	*x = GetChangesSinceRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetChangesSinceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetChangesSinceRequest) ProtoMessage() {}
+
+func (x *GetChangesSinceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetChangesSinceRequest.ProtoReflect.Descriptor instead.
+func (*GetChangesSinceRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetChangesSinceRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *GetChangesSinceRequest) GetLastMessageId() string {
+	if x != nil {
+		return x.LastMessageId
+	}
+	return ""
+}
+
+func (x *GetChangesSinceRequest) GetLastTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastTimestamp
+	}
+	return nil
+}
+
+func (x *GetChangesSinceRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type GetChangesSinceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*StoredMessage       `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`                             // Edited, deleted or hidden after the cursor
+	ReadReceipts  []*ReadReceipt         `protobuf:"bytes,2,rep,name=read_receipts,json=readReceipts,proto3" json:"read_receipts,omitempty"` // Reads of the user's messages after the cursor
+	Truncated     bool                   `protobuf:"varint,3,opt,name=truncated,proto3" json:"truncated,omitempty"`                          // More changes exist than limit allowed
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetChangesSinceResponse) Reset() {
+	*x = GetChangesSinceResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetChangesSinceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetChangesSinceResponse) ProtoMessage() {}
+
+func (x *GetChangesSinceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetChangesSinceResponse.ProtoReflect.Descriptor instead.
+func (*GetChangesSinceResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetChangesSinceResponse) GetMessages() []*StoredMessage {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+func (x *GetChangesSinceResponse) GetReadReceipts() []*ReadReceipt {
+	if x != nil {
+		return x.ReadReceipts
+	}
+	return nil
+}
+
+func (x *GetChangesSinceResponse) GetTruncated() bool {
+	if x != nil {
+		return x.Truncated
+	}
+	return false
+}
+
+type ReadReceipt struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	ReaderId      string                 `protobuf:"bytes,2,opt,name=reader_id,json=readerId,proto3" json:"reader_id,omitempty"`
+	SenderId      string                 `protobuf:"bytes,3,opt,name=sender_id,json=senderId,proto3" json:"sender_id,omitempty"`
+	ReadAt        *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=read_at,json=readAt,proto3" json:"read_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadReceipt) Reset() {
+	*x = ReadReceipt{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadReceipt) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadReceipt) ProtoMessage() {}
+
+func (x *ReadReceipt) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadReceipt.ProtoReflect.Descriptor instead.
+func (*ReadReceipt) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ReadReceipt) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *ReadReceipt) GetReaderId() string {
+	if x != nil {
+		return x.ReaderId
+	}
+	return ""
+}
+
+func (x *ReadReceipt) GetSenderId() string {
+	if x != nil {
+		return x.SenderId
+	}
+	return ""
+}
+
+func (x *ReadReceipt) GetReadAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReadAt
+	}
+	return nil
+}
+
+type MarkMessageReadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MarkMessageReadRequest) Reset() {
+	*x = MarkMessageReadRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MarkMessageReadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MarkMessageReadRequest) ProtoMessage() {}
+
+func (x *MarkMessageReadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MarkMessageReadRequest.ProtoReflect.Descriptor instead.
+func (*MarkMessageReadRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MarkMessageReadRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *MarkMessageReadRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type MarkMessageReadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Message       *StoredMessage         `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MarkMessageReadResponse) Reset() {
+	*x = MarkMessageReadResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MarkMessageReadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MarkMessageReadResponse) ProtoMessage() {}
+
+func (x *MarkMessageReadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MarkMessageReadResponse.ProtoReflect.Descriptor instead.
+func (*MarkMessageReadResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *MarkMessageReadResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *MarkMessageReadResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *MarkMessageReadResponse) GetMessage() *StoredMessage {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+type Room struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RoomId        string                 `protobuf:"bytes,1,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	OwnerId       string                 `protobuf:"bytes,3,opt,name=owner_id,json=ownerId,proto3" json:"owner_id,omitempty"`
+	MemberIds     []string               `protobuf:"bytes,4,rep,name=member_ids,json=memberIds,proto3" json:"member_ids,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Room) Reset() {
+	*x = Room{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Room) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Room) ProtoMessage() {}
+
+func (x *Room) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Room.ProtoReflect.Descriptor instead.
+func (*Room) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *Room) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *Room) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Room) GetOwnerId() string {
+	if x != nil {
+		return x.OwnerId
+	}
+	return ""
+}
+
+func (x *Room) GetMemberIds() []string {
+	if x != nil {
+		return x.MemberIds
+	}
+	return nil
+}
+
+func (x *Room) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+type CreateRoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RoomId        string                 `protobuf:"bytes,1,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	OwnerId       string                 `protobuf:"bytes,2,opt,name=owner_id,json=ownerId,proto3" json:"owner_id,omitempty"`
+	Name          string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	MemberIds     []string               `protobuf:"bytes,4,rep,name=member_ids,json=memberIds,proto3" json:"member_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateRoomRequest) Reset() {
+	*x = CreateRoomRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateRoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateRoomRequest) ProtoMessage() {}
+
+func (x *CreateRoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateRoomRequest.ProtoReflect.Descriptor instead.
+func (*CreateRoomRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *CreateRoomRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *CreateRoomRequest) GetOwnerId() string {
+	if x != nil {
+		return x.OwnerId
+	}
+	return ""
+}
+
+func (x *CreateRoomRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CreateRoomRequest) GetMemberIds() []string {
+	if x != nil {
+		return x.MemberIds
+	}
+	return nil
+}
+
+type GetRoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RoomId        string                 `protobuf:"bytes,1,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRoomRequest) Reset() {
+	*x = GetRoomRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRoomRequest) ProtoMessage() {}
+
+func (x *GetRoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRoomRequest.ProtoReflect.Descriptor instead.
+func (*GetRoomRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GetRoomRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+type RenameRoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RoomId        string                 `protobuf:"bytes,1,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Name          string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenameRoomRequest) Reset() {
+	*x = RenameRoomRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenameRoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenameRoomRequest) ProtoMessage() {}
+
+func (x *RenameRoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenameRoomRequest.ProtoReflect.Descriptor instead.
+func (*RenameRoomRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *RenameRoomRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *RenameRoomRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *RenameRoomRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type DeleteRoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RoomId        string                 `protobuf:"bytes,1,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRoomRequest) Reset() {
+	*x = DeleteRoomRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRoomRequest) ProtoMessage() {}
+
+func (x *DeleteRoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRoomRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRoomRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *DeleteRoomRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *DeleteRoomRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type RoomMemberRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RoomId        string                 `protobuf:"bytes,1,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"` // User performing the change
+	MemberId      string                 `protobuf:"bytes,3,opt,name=member_id,json=memberId,proto3" json:"member_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RoomMemberRequest) Reset() {
+	*x = RoomMemberRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RoomMemberRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoomMemberRequest) ProtoMessage() {}
+
+func (x *RoomMemberRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoomMemberRequest.ProtoReflect.Descriptor instead.
+func (*RoomMemberRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *RoomMemberRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *RoomMemberRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *RoomMemberRequest) GetMemberId() string {
+	if x != nil {
+		return x.MemberId
+	}
+	return ""
+}
+
+type RoomResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Room          *Room                  `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RoomResponse) Reset() {
+	*x = RoomResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RoomResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoomResponse) ProtoMessage() {}
+
+func (x *RoomResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoomResponse.ProtoReflect.Descriptor instead.
+func (*RoomResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *RoomResponse) GetRoom() *Room {
+	if x != nil {
+		return x.Room
+	}
+	return nil
+}
+
+func (x *RoomResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *RoomResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type DeleteRoomResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRoomResponse) Reset() {
+	*x = DeleteRoomResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRoomResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRoomResponse) ProtoMessage() {}
+
+func (x *DeleteRoomResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRoomResponse.ProtoReflect.Descriptor instead.
+func (*DeleteRoomResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *DeleteRoomResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *DeleteRoomResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type ListRoomsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRoomsRequest) Reset() {
+	*x = ListRoomsRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRoomsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRoomsRequest) ProtoMessage() {}
+
+func (x *ListRoomsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRoomsRequest.ProtoReflect.Descriptor instead.
+func (*ListRoomsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *ListRoomsRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type ListRoomsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rooms         []*Room                `protobuf:"bytes,1,rep,name=rooms,proto3" json:"rooms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRoomsResponse) Reset() {
+	*x = ListRoomsResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRoomsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRoomsResponse) ProtoMessage() {}
+
+func (x *ListRoomsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRoomsResponse.ProtoReflect.Descriptor instead.
+func (*ListRoomsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *ListRoomsResponse) GetRooms() []*Room {
+	if x != nil {
+		return x.Rooms
+	}
+	return nil
+}
+
+// SearchMessagesRequest finds messages in conversations user_id takes part
+// in, newest first. query uses web search syntax ("quoted phrases", or,
+// -excluded); it may be empty if a filter is set.
+type SearchMessagesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Query         string                 `protobuf:"bytes,2,opt,name=query,proto3" json:"query,omitempty"`
+	SenderId      string                 `protobuf:"bytes,3,opt,name=sender_id,json=senderId,proto3" json:"sender_id,omitempty"`
+	RoomId        string                 `protobuf:"bytes,4,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	WithUserId    string                 `protobuf:"bytes,5,opt,name=with_user_id,json=withUserId,proto3" json:"with_user_id,omitempty"` // Direct messages between user_id and this user
+	After         *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=after,proto3" json:"after,omitempty"`
+	Before        *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=before,proto3" json:"before,omitempty"`
+	HasAttachment bool                   `protobuf:"varint,8,opt,name=has_attachment,json=hasAttachment,proto3" json:"has_attachment,omitempty"`
+	Cursor        string                 `protobuf:"bytes,9,opt,name=cursor,proto3" json:"cursor,omitempty"` // next_cursor of the previous page
+	Limit         int32                  `protobuf:"varint,10,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchMessagesRequest) Reset() {
+	*x = SearchMessagesRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchMessagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchMessagesRequest) ProtoMessage() {}
+
+func (x *SearchMessagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchMessagesRequest.ProtoReflect.Descriptor instead.
+func (*SearchMessagesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *SearchMessagesRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *SearchMessagesRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchMessagesRequest) GetSenderId() string {
+	if x != nil {
+		return x.SenderId
+	}
+	return ""
+}
+
+func (x *SearchMessagesRequest) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *SearchMessagesRequest) GetWithUserId() string {
+	if x != nil {
+		return x.WithUserId
+	}
+	return ""
+}
+
+func (x *SearchMessagesRequest) GetAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.After
+	}
+	return nil
+}
+
+func (x *SearchMessagesRequest) GetBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Before
+	}
+	return nil
+}
+
+func (x *SearchMessagesRequest) GetHasAttachment() bool {
+	if x != nil {
+		return x.HasAttachment
+	}
+	return false
+}
+
+func (x *SearchMessagesRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *SearchMessagesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type SearchResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       *StoredMessage         `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Snippet       string                 `protobuf:"bytes,2,opt,name=snippet,proto3" json:"snippet,omitempty"` // Matching words wrapped in <mark></mark>; the rest is not escaped
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchResult) Reset() {
+	*x = SearchResult{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResult) ProtoMessage() {}
+
+func (x *SearchResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResult.ProtoReflect.Descriptor instead.
+func (*SearchResult) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *SearchResult) GetMessage() *StoredMessage {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *SearchResult) GetSnippet() string {
+	if x != nil {
+		return x.Snippet
+	}
+	return ""
+}
+
+type SearchMessagesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*SearchResult        `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	NextCursor    string                 `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"` // Empty on the last page
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchMessagesResponse) Reset() {
+	*x = SearchMessagesResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchMessagesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchMessagesResponse) ProtoMessage() {}
+
+func (x *SearchMessagesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchMessagesResponse.ProtoReflect.Descriptor instead.
+func (*SearchMessagesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *SearchMessagesResponse) GetResults() []*SearchResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *SearchMessagesResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+// ListConversationsRequest pages through the user's direct conversations and
+// rooms, most recently active first.
+type ListConversationsRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	UserId         string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Cursor         string                 `protobuf:"bytes,2,opt,name=cursor,proto3" json:"cursor,omitempty"` // next_cursor of the previous page
+	Limit          int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	ConversationId string                 `protobuf:"bytes,4,opt,name=conversation_id,json=conversationId,proto3" json:"conversation_id,omitempty"` // Only this conversation
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ListConversationsRequest) Reset() {
+	*x = ListConversationsRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConversationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConversationsRequest) ProtoMessage() {}
+
+func (x *ListConversationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConversationsRequest.ProtoReflect.Descriptor instead.
+func (*ListConversationsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *ListConversationsRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ListConversationsRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *ListConversationsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListConversationsRequest) GetConversationId() string {
+	if x != nil {
+		return x.ConversationId
+	}
+	return ""
+}
+
+// Conversation is one entry of a user's inbox. Messages up to the last one
+// the user sent or read in it count as read.
+type Conversation struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ConversationId string                 `protobuf:"bytes,1,opt,name=conversation_id,json=conversationId,proto3" json:"conversation_id,omitempty"` // room_id for rooms, user_id for direct messages
+	RoomId         string                 `protobuf:"bytes,2,opt,name=room_id,json=roomId,proto3" json:"room_id,omitempty"`
+	UserId         string                 `protobuf:"bytes,3,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`                // Other participant of a direct conversation
+	LastMessage    *StoredMessage         `protobuf:"bytes,4,opt,name=last_message,json=lastMessage,proto3" json:"last_message,omitempty"` // Unset for rooms without messages
+	UnreadCount    int32                  `protobuf:"varint,5,opt,name=unread_count,json=unreadCount,proto3" json:"unread_count,omitempty"`
+	LastActivity   *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=last_activity,json=lastActivity,proto3" json:"last_activity,omitempty"` // Last message, or when the user joined the room
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Conversation) Reset() {
+	*x = Conversation{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Conversation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Conversation) ProtoMessage() {}
+
+func (x *Conversation) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Conversation.ProtoReflect.Descriptor instead.
+func (*Conversation) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *Conversation) GetConversationId() string {
+	if x != nil {
+		return x.ConversationId
+	}
+	return ""
+}
+
+func (x *Conversation) GetRoomId() string {
+	if x != nil {
+		return x.RoomId
+	}
+	return ""
+}
+
+func (x *Conversation) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Conversation) GetLastMessage() *StoredMessage {
+	if x != nil {
+		return x.LastMessage
+	}
+	return nil
+}
+
+func (x *Conversation) GetUnreadCount() int32 {
+	if x != nil {
+		return x.UnreadCount
+	}
+	return 0
+}
+
+func (x *Conversation) GetLastActivity() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastActivity
+	}
+	return nil
+}
+
+type ListConversationsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Conversations []*Conversation        `protobuf:"bytes,1,rep,name=conversations,proto3" json:"conversations,omitempty"`
+	NextCursor    string                 `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"` // Empty on the last page
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListConversationsResponse) Reset() {
+	*x = ListConversationsResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConversationsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConversationsResponse) ProtoMessage() {}
+
+func (x *ListConversationsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConversationsResponse.ProtoReflect.Descriptor instead.
+func (*ListConversationsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *ListConversationsResponse) GetConversations() []*Conversation {
+	if x != nil {
+		return x.Conversations
+	}
+	return nil
+}
+
+func (x *ListConversationsResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+// DeleteUserDataRequest erases a deleted account from the store: its messages
+// become anonymous tombstones and its rooms pass to the longest-standing
+// member, or are deleted if none is left.
+type DeleteUserDataRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserDataRequest) Reset() {
+	*x = DeleteUserDataRequest{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserDataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserDataRequest) ProtoMessage() {}
+
+func (x *DeleteUserDataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserDataRequest.ProtoReflect.Descriptor instead.
+func (*DeleteUserDataRequest) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *DeleteUserDataRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type DeleteUserDataResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Success         bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error           string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	MessagesDeleted int64                  `protobuf:"varint,3,opt,name=messages_deleted,json=messagesDeleted,proto3" json:"messages_deleted,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *DeleteUserDataResponse) Reset() {
+	*x = DeleteUserDataResponse{}
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserDataResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserDataResponse) ProtoMessage() {}
+
+func (x *DeleteUserDataResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_messagestore_messagestore_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserDataResponse.ProtoReflect.Descriptor instead.
+func (*DeleteUserDataResponse) Descriptor() ([]byte, []int) {
+	return file_proto_messagestore_messagestore_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *DeleteUserDataResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *DeleteUserDataResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *DeleteUserDataResponse) GetMessagesDeleted() int64 {
+	if x != nil {
+		return x.MessagesDeleted
+	}
+	return 0
+}
+
+var File_proto_messagestore_messagestore_proto protoreflect.FileDescriptor
+
+const file_proto_messagestore_messagestore_proto_rawDesc = "" +
+	"\n" +
+	"%proto/messagestore/messagestore.proto\x12\fmessagestore\x1a\x1fgoogle/protobuf/timestamp.proto\"\xe1\x01\n" +
+	"\x13StoreMessageRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x1b\n" +
+	"\tsender_id\x18\x02 \x01(\tR\bsenderId\x12!\n" +
+	"\frecipient_id\x18\x03 \x01(\tR\vrecipientId\x12\x18\n" +
+	"\acontent\x18\x04 \x01(\tR\acontent\x128\n" +
+	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x17\n" +
+	"\aroom_id\x18\x06 \x01(\tR\x06roomId\"F\n" +
+	"\x14StoreMessageResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"\x9f\x02\n" +
+	"\x18GetMessageHistoryRequest\x12\x19\n" +
+	"\buser_id1\x18\x01 \x01(\tR\auserId1\x12\x19\n" +
+	"\buser_id2\x18\x02 \x01(\tR\auserId2\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x04 \x01(\x05R\x06offset\x12&\n" +
+	"\x0flast_message_id\x18\x05 \x01(\tR\rlastMessageId\x12A\n" +
+	"\x0elast_timestamp\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\rlastTimestamp\x12\x17\n" +
+	"\aroom_id\x18\a \x01(\tR\x06roomId\x12\x1b\n" +
+	"\tviewer_id\x18\b \x01(\tR\bviewerId\"T\n" +
+	"\x19GetMessageHistoryResponse\x127\n" +
+	"\bmessages\x18\x01 \x03(\v2\x1b.messagestore.StoredMessageR\bmessages\"q\n" +
+	"\x14DeleteMessageRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12!\n" +
+	"\ffor_everyone\x18\x03 \x01(\bR\vforEveryone\"~\n" +
+	"\x15DeleteMessageResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x125\n" +
+	"\amessage\x18\x03 \x01(\v2\x1b.messagestore.StoredMessageR\amessage\"\xe9\x02\n" +
+	"\rStoredMessage\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x1b\n" +
+	"\tsender_id\x18\x02 \x01(\tR\bsenderId\x12!\n" +
+	"\frecipient_id\x18\x03 \x01(\tR\vrecipientId\x12\x18\n" +
+	"\acontent\x18\x04 \x01(\tR\acontent\x128\n" +
+	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x129\n" +
+	"\n" +
+	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12\x17\n" +
+	"\aroom_id\x18\a \x01(\tR\x06roomId\x127\n" +
+	"\tedited_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\beditedAt\x12\x18\n" +
+	"\adeleted\x18\t \x01(\bR\adeleted\"f\n" +
+	"\x12EditMessageRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x18\n" +
+	"\acontent\x18\x03 \x01(\tR\acontent\"|\n" +
+	"\x13EditMessageResponse\x125\n" +
+	"\amessage\x18\x01 \x01(\v2\x1b.messagestore.StoredMessageR\amessage\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\xb3\x01\n" +
+	"\x17GetMessagesSinceRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12&\n" +
+	"\x0flast_message_id\x18\x02 \x01(\tR\rlastMessageId\x12A\n" +
+	"\x0elast_timestamp\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\rlastTimestamp\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\"\xb2\x01\n" +
+	"\x16GetChangesSinceRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12&\n" +
+	"\x0flast_message_id\x18\x02 \x01(\tR\rlastMessageId\x12A\n" +
+	"\x0elast_timestamp\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\rlastTimestamp\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\"\xb0\x01\n" +
+	"\x17GetChangesSinceResponse\x127\n" +
+	"\bmessages\x18\x01 \x03(\v2\x1b.messagestore.StoredMessageR\bmessages\x12>\n" +
+	"\rread_receipts\x18\x02 \x03(\v2\x19.messagestore.ReadReceiptR\freadReceipts\x12\x1c\n" +
+	"\ttruncated\x18\x03 \x01(\bR\ttruncated\"\x9b\x01\n" +
+	"\vReadReceipt\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x1b\n" +
+	"\treader_id\x18\x02 \x01(\tR\breaderId\x12\x1b\n" +
+	"\tsender_id\x18\x03 \x01(\tR\bsenderId\x123\n" +
+	"\aread_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x06readAt\"P\n" +
+	"\x16MarkMessageReadRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\"\x80\x01\n" +
+	"\x17MarkMessageReadResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x125\n" +
+	"\amessage\x18\x03 \x01(\v2\x1b.messagestore.StoredMessageR\amessage\"\xa8\x01\n" +
+	"\x04Room\x12\x17\n" +
+	"\aroom_id\x18\x01 \x01(\tR\x06roomId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x19\n" +
+	"\bowner_id\x18\x03 \x01(\tR\aownerId\x12\x1d\n" +
+	"\n" +
+	"member_ids\x18\x04 \x03(\tR\tmemberIds\x129\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\"z\n" +
+	"\x11CreateRoomRequest\x12\x17\n" +
+	"\aroom_id\x18\x01 \x01(\tR\x06roomId\x12\x19\n" +
+	"\bowner_id\x18\x02 \x01(\tR\aownerId\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"member_ids\x18\x04 \x03(\tR\tmemberIds\")\n" +
+	"\x0eGetRoomRequest\x12\x17\n" +
+	"\aroom_id\x18\x01 \x01(\tR\x06roomId\"Y\n" +
+	"\x11RenameRoomRequest\x12\x17\n" +
+	"\aroom_id\x18\x01 \x01(\tR\x06roomId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\"E\n" +
+	"\x11DeleteRoomRequest\x12\x17\n" +
+	"\aroom_id\x18\x01 \x01(\tR\x06roomId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\"b\n" +
+	"\x11RoomMemberRequest\x12\x17\n" +
+	"\aroom_id\x18\x01 \x01(\tR\x06roomId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x1b\n" +
+	"\tmember_id\x18\x03 \x01(\tR\bmemberId\"f\n" +
+	"\fRoomResponse\x12&\n" +
+	"\x04room\x18\x01 \x01(\v2\x12.messagestore.RoomR\x04room\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"D\n" +
+	"\x12DeleteRoomResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"+\n" +
+	"\x10ListRoomsRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\"=\n" +
+	"\x11ListRoomsResponse\x12(\n" +
+	"\x05rooms\x18\x01 \x03(\v2\x12.messagestore.RoomR\x05rooms\"\xd9\x02\n" +
+	"\x15SearchMessagesRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x14\n" +
+	"\x05query\x18\x02 \x01(\tR\x05query\x12\x1b\n" +
+	"\tsender_id\x18\x03 \x01(\tR\bsenderId\x12\x17\n" +
+	"\aroom_id\x18\x04 \x01(\tR\x06roomId\x12 \n" +
+	"\fwith_user_id\x18\x05 \x01(\tR\n" +
+	"withUserId\x120\n" +
+	"\x05after\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x05after\x122\n" +
+	"\x06before\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\x06before\x12%\n" +
+	"\x0ehas_attachment\x18\b \x01(\bR\rhasAttachment\x12\x16\n" +
+	"\x06cursor\x18\t \x01(\tR\x06cursor\x12\x14\n" +
+	"\x05limit\x18\n" +
+	" \x01(\x05R\x05limit\"_\n" +
+	"\fSearchResult\x125\n" +
+	"\amessage\x18\x01 \x01(\v2\x1b.messagestore.StoredMessageR\amessage\x12\x18\n" +
+	"\asnippet\x18\x02 \x01(\tR\asnippet\"o\n" +
+	"\x16SearchMessagesResponse\x124\n" +
+	"\aresults\x18\x01 \x03(\v2\x1a.messagestore.SearchResultR\aresults\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\"\x8a\x01\n" +
+	"\x18ListConversationsRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x16\n" +
+	"\x06cursor\x18\x02 \x01(\tR\x06cursor\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12'\n" +
+	"\x0fconversation_id\x18\x04 \x01(\tR\x0econversationId\"\x8d\x02\n" +
+	"\fConversation\x12'\n" +
+	"\x0fconversation_id\x18\x01 \x01(\tR\x0econversationId\x12\x17\n" +
+	"\aroom_id\x18\x02 \x01(\tR\x06roomId\x12\x17\n" +
+	"\auser_id\x18\x03 \x01(\tR\x06userId\x12>\n" +
+	"\flast_message\x18\x04 \x01(\v2\x1b.messagestore.StoredMessageR\vlastMessage\x12!\n" +
+	"\funread_count\x18\x05 \x01(\x05R\vunreadCount\x12?\n" +
+	"\rlast_activity\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\flastActivity\"~\n" +
+	"\x19ListConversationsResponse\x12@\n" +
+	"\rconversations\x18\x01 \x03(\v2\x1a.messagestore.ConversationR\rconversations\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\"0\n" +
+	"\x15DeleteUserDataRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\"s\n" +
+	"\x16DeleteUserDataResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12)\n" +
+	"\x10messages_deleted\x18\x03 \x01(\x03R\x0fmessagesDeleted2\xdd\v\n" +
+	"\x13MessageStoreService\x12U\n" +
+	"\fStoreMessage\x12!.messagestore.StoreMessageRequest\x1a\".messagestore.StoreMessageResponse\x12d\n" +
+	"\x11GetMessageHistory\x12&.messagestore.GetMessageHistoryRequest\x1a'.messagestore.GetMessageHistoryResponse\x12X\n" +
+	"\rDeleteMessage\x12\".messagestore.DeleteMessageRequest\x1a#.messagestore.DeleteMessageResponse\x12I\n" +
+	"\n" +
+	"CreateRoom\x12\x1f.messagestore.CreateRoomRequest\x1a\x1a.messagestore.RoomResponse\x12C\n" +
+	"\aGetRoom\x12\x1c.messagestore.GetRoomRequest\x1a\x1a.messagestore.RoomResponse\x12I\n" +
+	"\n" +
+	"RenameRoom\x12\x1f.messagestore.RenameRoomRequest\x1a\x1a.messagestore.RoomResponse\x12O\n" +
+	"\n" +
+	"DeleteRoom\x12\x1f.messagestore.DeleteRoomRequest\x1a .messagestore.DeleteRoomResponse\x12L\n" +
+	"\rAddRoomMember\x12\x1f.messagestore.RoomMemberRequest\x1a\x1a.messagestore.RoomResponse\x12O\n" +
+	"\x10RemoveRoomMember\x12\x1f.messagestore.RoomMemberRequest\x1a\x1a.messagestore.RoomResponse\x12L\n" +
+	"\tListRooms\x12\x1e.messagestore.ListRoomsRequest\x1a\x1f.messagestore.ListRoomsResponse\x12R\n" +
+	"\vEditMessage\x12 .messagestore.EditMessageRequest\x1a!.messagestore.EditMessageResponse\x12b\n" +
+	"\x10GetMessagesSince\x12%.messagestore.GetMessagesSinceRequest\x1a'.messagestore.GetMessageHistoryResponse\x12^\n" +
+	"\x0fGetChangesSince\x12$.messagestore.GetChangesSinceRequest\x1a%.messagestore.GetChangesSinceResponse\x12^\n" +
+	"\x0fMarkMessageRead\x12$.messagestore.MarkMessageReadRequest\x1a%.messagestore.MarkMessageReadResponse\x12[\n" +
+	"\x0eDeleteUserData\x12#.messagestore.DeleteUserDataRequest\x1a$.messagestore.DeleteUserDataResponse\x12[\n" +
+	"\x0eSearchMessages\x12#.messagestore.SearchMessagesRequest\x1a$.messagestore.SearchMessagesResponse\x12d\n" +
+	"\x11ListConversations\x12&.messagestore.ListConversationsRequest\x1a'.messagestore.ListConversationsResponseB\x16Z\x14./proto/messagestoreb\x06proto3"
 
 var (
 	file_proto_messagestore_messagestore_proto_rawDescOnce sync.Once
@@ -523,7 +2326,7 @@ func file_proto_messagestore_messagestore_proto_rawDescGZIP() []byte {
 	return file_proto_messagestore_messagestore_proto_rawDescData
 }
 
-var file_proto_messagestore_messagestore_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_proto_messagestore_messagestore_proto_msgTypes = make([]protoimpl.MessageInfo, 33)
 var file_proto_messagestore_messagestore_proto_goTypes = []any{
 	(*StoreMessageRequest)(nil),       // 0: messagestore.StoreMessageRequest
 	(*StoreMessageResponse)(nil),      // 1: messagestore.StoreMessageResponse
@@ -532,25 +2335,98 @@ var file_proto_messagestore_messagestore_proto_goTypes = []any{
 	(*DeleteMessageRequest)(nil),      // 4: messagestore.DeleteMessageRequest
 	(*DeleteMessageResponse)(nil),     // 5: messagestore.DeleteMessageResponse
 	(*StoredMessage)(nil),             // 6: messagestore.StoredMessage
-	(*timestamppb.Timestamp)(nil),     // 7: google.protobuf.Timestamp
+	(*EditMessageRequest)(nil),        // 7: messagestore.EditMessageRequest
+	(*EditMessageResponse)(nil),       // 8: messagestore.EditMessageResponse
+	(*GetMessagesSinceRequest)(nil),   // 9: messagestore.GetMessagesSinceRequest
+	(*GetChangesSinceRequest)(nil),    // 10: messagestore.GetChangesSinceRequest
+	(*GetChangesSinceResponse)(nil),   // 11: messagestore.GetChangesSinceResponse
+	(*ReadReceipt)(nil),               // 12: messagestore.ReadReceipt
+	(*MarkMessageReadRequest)(nil),    // 13: messagestore.MarkMessageReadRequest
+	(*MarkMessageReadResponse)(nil),   // 14: messagestore.MarkMessageReadResponse
+	(*Room)(nil),                      // 15: messagestore.Room
+	(*CreateRoomRequest)(nil),         // 16: messagestore.CreateRoomRequest
+	(*GetRoomRequest)(nil),            // 17: messagestore.GetRoomRequest
+	(*RenameRoomRequest)(nil),         // 18: messagestore.RenameRoomRequest
+	(*DeleteRoomRequest)(nil),         // 19: messagestore.DeleteRoomRequest
+	(*RoomMemberRequest)(nil),         // 20: messagestore.RoomMemberRequest
+	(*RoomResponse)(nil),              // 21: messagestore.RoomResponse
+	(*DeleteRoomResponse)(nil),        // 22: messagestore.DeleteRoomResponse
+	(*ListRoomsRequest)(nil),          // 23: messagestore.ListRoomsRequest
+	(*ListRoomsResponse)(nil),         // 24: messagestore.ListRoomsResponse
+	(*SearchMessagesRequest)(nil),     // 25: messagestore.SearchMessagesRequest
+	(*SearchResult)(nil),              // 26: messagestore.SearchResult
+	(*SearchMessagesResponse)(nil),    // 27: messagestore.SearchMessagesResponse
+	(*ListConversationsRequest)(nil),  // 28: messagestore.ListConversationsRequest
+	(*Conversation)(nil),              // 29: messagestore.Conversation
+	(*ListConversationsResponse)(nil), // 30: messagestore.ListConversationsResponse
+	(*DeleteUserDataRequest)(nil),     // 31: messagestore.DeleteUserDataRequest
+	(*DeleteUserDataResponse)(nil),    // 32: messagestore.DeleteUserDataResponse
+	(*timestamppb.Timestamp)(nil),     // 33: google.protobuf.Timestamp
 }
 var file_proto_messagestore_messagestore_proto_depIdxs = []int32{
-	7, // 0: messagestore.StoreMessageRequest.timestamp:type_name -> google.protobuf.Timestamp
-	7, // 1: messagestore.GetMessageHistoryRequest.last_timestamp:type_name -> google.protobuf.Timestamp
-	6, // 2: messagestore.GetMessageHistoryResponse.messages:type_name -> messagestore.StoredMessage
-	7, // 3: messagestore.StoredMessage.timestamp:type_name -> google.protobuf.Timestamp
-	7, // 4: messagestore.StoredMessage.created_at:type_name -> google.protobuf.Timestamp
-	0, // 5: messagestore.MessageStoreService.StoreMessage:input_type -> messagestore.StoreMessageRequest
-	2, // 6: messagestore.MessageStoreService.GetMessageHistory:input_type -> messagestore.GetMessageHistoryRequest
-	4, // 7: messagestore.MessageStoreService.DeleteMessage:input_type -> messagestore.DeleteMessageRequest
-	1, // 8: messagestore.MessageStoreService.StoreMessage:output_type -> messagestore.StoreMessageResponse
-	3, // 9: messagestore.MessageStoreService.GetMessageHistory:output_type -> messagestore.GetMessageHistoryResponse
-	5, // 10: messagestore.MessageStoreService.DeleteMessage:output_type -> messagestore.DeleteMessageResponse
-	8, // [8:11] is the sub-list for method output_type
-	5, // [5:8] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	33, // 0: messagestore.StoreMessageRequest.timestamp:type_name -> google.protobuf.Timestamp
+	33, // 1: messagestore.GetMessageHistoryRequest.last_timestamp:type_name -> google.protobuf.Timestamp
+	6,  // 2: messagestore.GetMessageHistoryResponse.messages:type_name -> messagestore.StoredMessage
+	6,  // 3: messagestore.DeleteMessageResponse.message:type_name -> messagestore.StoredMessage
+	33, // 4: messagestore.StoredMessage.timestamp:type_name -> google.protobuf.Timestamp
+	33, // 5: messagestore.StoredMessage.created_at:type_name -> google.protobuf.Timestamp
+	33, // 6: messagestore.StoredMessage.edited_at:type_name -> google.protobuf.Timestamp
+	6,  // 7: messagestore.EditMessageResponse.message:type_name -> messagestore.StoredMessage
+	33, // 8: messagestore.GetMessagesSinceRequest.last_timestamp:type_name -> google.protobuf.Timestamp
+	33, // 9: messagestore.GetChangesSinceRequest.last_timestamp:type_name -> google.protobuf.Timestamp
+	6,  // 10: messagestore.GetChangesSinceResponse.messages:type_name -> messagestore.StoredMessage
+	12, // 11: messagestore.GetChangesSinceResponse.read_receipts:type_name -> messagestore.ReadReceipt
+	33, // 12: messagestore.ReadReceipt.read_at:type_name -> google.protobuf.Timestamp
+	6,  // 13: messagestore.MarkMessageReadResponse.message:type_name -> messagestore.StoredMessage
+	33, // 14: messagestore.Room.created_at:type_name -> google.protobuf.Timestamp
+	15, // 15: messagestore.RoomResponse.room:type_name -> messagestore.Room
+	15, // 16: messagestore.ListRoomsResponse.rooms:type_name -> messagestore.Room
+	33, // 17: messagestore.SearchMessagesRequest.after:type_name -> google.protobuf.Timestamp
+	33, // 18: messagestore.SearchMessagesRequest.before:type_name -> google.protobuf.Timestamp
+	6,  // 19: messagestore.SearchResult.message:type_name -> messagestore.StoredMessage
+	26, // 20: messagestore.SearchMessagesResponse.results:type_name -> messagestore.SearchResult
+	6,  // 21: messagestore.Conversation.last_message:type_name -> messagestore.StoredMessage
+	33, // 22: messagestore.Conversation.last_activity:type_name -> google.protobuf.Timestamp
+	29, // 23: messagestore.ListConversationsResponse.conversations:type_name -> messagestore.Conversation
+	0,  // 24: messagestore.MessageStoreService.StoreMessage:input_type -> messagestore.StoreMessageRequest
+	2,  // 25: messagestore.MessageStoreService.GetMessageHistory:input_type -> messagestore.GetMessageHistoryRequest
+	4,  // 26: messagestore.MessageStoreService.DeleteMessage:input_type -> messagestore.DeleteMessageRequest
+	16, // 27: messagestore.MessageStoreService.CreateRoom:input_type -> messagestore.CreateRoomRequest
+	17, // 28: messagestore.MessageStoreService.GetRoom:input_type -> messagestore.GetRoomRequest
+	18, // 29: messagestore.MessageStoreService.RenameRoom:input_type -> messagestore.RenameRoomRequest
+	19, // 30: messagestore.MessageStoreService.DeleteRoom:input_type -> messagestore.DeleteRoomRequest
+	20, // 31: messagestore.MessageStoreService.AddRoomMember:input_type -> messagestore.RoomMemberRequest
+	20, // 32: messagestore.MessageStoreService.RemoveRoomMember:input_type -> messagestore.RoomMemberRequest
+	23, // 33: messagestore.MessageStoreService.ListRooms:input_type -> messagestore.ListRoomsRequest
+	7,  // 34: messagestore.MessageStoreService.EditMessage:input_type -> messagestore.EditMessageRequest
+	9,  // 35: messagestore.MessageStoreService.GetMessagesSince:input_type -> messagestore.GetMessagesSinceRequest
+	10, // 36: messagestore.MessageStoreService.GetChangesSince:input_type -> messagestore.GetChangesSinceRequest
+	13, // 37: messagestore.MessageStoreService.MarkMessageRead:input_type -> messagestore.MarkMessageReadRequest
+	31, // 38: messagestore.MessageStoreService.DeleteUserData:input_type -> messagestore.DeleteUserDataRequest
+	25, // 39: messagestore.MessageStoreService.SearchMessages:input_type -> messagestore.SearchMessagesRequest
+	28, // 40: messagestore.MessageStoreService.ListConversations:input_type -> messagestore.ListConversationsRequest
+	1,  // 41: messagestore.MessageStoreService.StoreMessage:output_type -> messagestore.StoreMessageResponse
+	3,  // 42: messagestore.MessageStoreService.GetMessageHistory:output_type -> messagestore.GetMessageHistoryResponse
+	5,  // 43: messagestore.MessageStoreService.DeleteMessage:output_type -> messagestore.DeleteMessageResponse
+	21, // 44: messagestore.MessageStoreService.CreateRoom:output_type -> messagestore.RoomResponse
+	21, // 45: messagestore.MessageStoreService.GetRoom:output_type -> messagestore.RoomResponse
+	21, // 46: messagestore.MessageStoreService.RenameRoom:output_type -> messagestore.RoomResponse
+	22, // 47: messagestore.MessageStoreService.DeleteRoom:output_type -> messagestore.DeleteRoomResponse
+	21, // 48: messagestore.MessageStoreService.AddRoomMember:output_type -> messagestore.RoomResponse
+	21, // 49: messagestore.MessageStoreService.RemoveRoomMember:output_type -> messagestore.RoomResponse
+	24, // 50: messagestore.MessageStoreService.ListRooms:output_type -> messagestore.ListRoomsResponse
+	8,  // 51: messagestore.MessageStoreService.EditMessage:output_type -> messagestore.EditMessageResponse
+	3,  // 52: messagestore.MessageStoreService.GetMessagesSince:output_type -> messagestore.GetMessageHistoryResponse
+	11, // 53: messagestore.MessageStoreService.GetChangesSince:output_type -> messagestore.GetChangesSinceResponse
+	14, // 54: messagestore.MessageStoreService.MarkMessageRead:output_type -> messagestore.MarkMessageReadResponse
+	32, // 55: messagestore.MessageStoreService.DeleteUserData:output_type -> messagestore.DeleteUserDataResponse
+	27, // 56: messagestore.MessageStoreService.SearchMessages:output_type -> messagestore.SearchMessagesResponse
+	30, // 57: messagestore.MessageStoreService.ListConversations:output_type -> messagestore.ListConversationsResponse
+	41, // [41:58] is the sub-list for method output_type
+	24, // [24:41] is the sub-list for method input_type
+	24, // [24:24] is the sub-list for extension type_name
+	24, // [24:24] is the sub-list for extension extendee
+	0,  // [0:24] is the sub-list for field type_name
 }
 
 func init() { file_proto_messagestore_messagestore_proto_init() }
@@ -564,7 +2440,7 @@ func file_proto_messagestore_messagestore_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_messagestore_messagestore_proto_rawDesc), len(file_proto_messagestore_messagestore_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   7,
+			NumMessages:   33,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.6
+// 	protoc-gen-go v1.36.8
 // 	protoc        v6.33.4
 // source: proto/presence/presence.proto
 
@@ -398,6 +398,103 @@ func (x *GetOnlineUsersResponse) GetUserIds() []string {
 	return nil
 }
 
+// ClearUserRequest removes everything presence keeps about a deleted user.
+type ClearUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearUserRequest) Reset() {
+	*x = ClearUserRequest{}
+	mi := &file_proto_presence_presence_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearUserRequest) ProtoMessage() {}
+
+func (x *ClearUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_presence_presence_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearUserRequest.ProtoReflect.Descriptor instead.
+func (*ClearUserRequest) Descriptor() ([]byte, []int) {
+	return file_proto_presence_presence_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ClearUserRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type ClearUserResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearUserResponse) Reset() {
+	*x = ClearUserResponse{}
+	mi := &file_proto_presence_presence_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearUserResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearUserResponse) ProtoMessage() {}
+
+func (x *ClearUserResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_presence_presence_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearUserResponse.ProtoReflect.Descriptor instead.
+func (*ClearUserResponse) Descriptor() ([]byte, []int) {
+	return file_proto_presence_presence_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ClearUserResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *ClearUserResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
 type UserStatusEvent struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
@@ -409,7 +506,7 @@ type UserStatusEvent struct {
 
 func (x *UserStatusEvent) Reset() {
 	*x = UserStatusEvent{}
-	mi := &file_proto_presence_presence_proto_msgTypes[8]
+	mi := &file_proto_presence_presence_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -421,7 +518,7 @@ func (x *UserStatusEvent) String() string {
 func (*UserStatusEvent) ProtoMessage() {}
 
 func (x *UserStatusEvent) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_presence_presence_proto_msgTypes[8]
+	mi := &file_proto_presence_presence_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -434,7 +531,7 @@ func (x *UserStatusEvent) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UserStatusEvent.ProtoReflect.Descriptor instead.
 func (*UserStatusEvent) Descriptor() ([]byte, []int) {
-	return file_proto_presence_presence_proto_rawDescGZIP(), []int{8}
+	return file_proto_presence_presence_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *UserStatusEvent) GetUserId() string {
@@ -481,16 +578,22 @@ const file_proto_presence_presence_proto_rawDesc = "" +
 	"\tlast_seen\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\blastSeen\"\x17\n" +
 	"\x15GetOnlineUsersRequest\"3\n" +
 	"\x16GetOnlineUsersResponse\x12\x19\n" +
-	"\buser_ids\x18\x01 \x03(\tR\auserIds\"|\n" +
+	"\buser_ids\x18\x01 \x03(\tR\auserIds\"+\n" +
+	"\x10ClearUserRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\"G\n" +
+	"\x11ClearUserResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"|\n" +
 	"\x0fUserStatusEvent\x12\x17\n" +
 	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x16\n" +
 	"\x06online\x18\x02 \x01(\bR\x06online\x128\n" +
-	"\ttimestamp\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp2\xdf\x02\n" +
+	"\ttimestamp\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp2\xa5\x03\n" +
 	"\x0fPresenceService\x12P\n" +
 	"\rSetUserOnline\x12\x1e.presence.SetUserOnlineRequest\x1a\x1f.presence.SetUserOnlineResponse\x12S\n" +
 	"\x0eSetUserOffline\x12\x1f.presence.SetUserOfflineRequest\x1a .presence.SetUserOfflineResponse\x12P\n" +
 	"\rGetUserStatus\x12\x1e.presence.GetUserStatusRequest\x1a\x1f.presence.GetUserStatusResponse\x12S\n" +
-	"\x0eGetOnlineUsers\x12\x1f.presence.GetOnlineUsersRequest\x1a .presence.GetOnlineUsersResponseB\x12Z\x10./proto/presenceb\x06proto3"
+	"\x0eGetOnlineUsers\x12\x1f.presence.GetOnlineUsersRequest\x1a .presence.GetOnlineUsersResponse\x12D\n" +
+	"\tClearUser\x12\x1a.presence.ClearUserRequest\x1a\x1b.presence.ClearUserResponseB\x12Z\x10./proto/presenceb\x06proto3"
 
 var (
 	file_proto_presence_presence_proto_rawDescOnce sync.Once
@@ -504,7 +607,7 @@ func file_proto_presence_presence_proto_rawDescGZIP() []byte {
 	return file_proto_presence_presence_proto_rawDescData
 }
 
-var file_proto_presence_presence_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_proto_presence_presence_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_proto_presence_presence_proto_goTypes = []any{
 	(*SetUserOnlineRequest)(nil),   // 0: presence.SetUserOnlineRequest
 	(*SetUserOnlineResponse)(nil),  // 1: presence.SetUserOnlineResponse
@@ -514,25 +617,29 @@ var file_proto_presence_presence_proto_goTypes = []any{
 	(*GetUserStatusResponse)(nil),  // 5: presence.GetUserStatusResponse
 	(*GetOnlineUsersRequest)(nil),  // 6: presence.GetOnlineUsersRequest
 	(*GetOnlineUsersResponse)(nil), // 7: presence.GetOnlineUsersResponse
-	(*UserStatusEvent)(nil),        // 8: presence.UserStatusEvent
-	(*timestamppb.Timestamp)(nil),  // 9: google.protobuf.Timestamp
+	(*ClearUserRequest)(nil),       // 8: presence.ClearUserRequest
+	(*ClearUserResponse)(nil),      // 9: presence.ClearUserResponse
+	(*UserStatusEvent)(nil),        // 10: presence.UserStatusEvent
+	(*timestamppb.Timestamp)(nil),  // 11: google.protobuf.Timestamp
 }
 var file_proto_presence_presence_proto_depIdxs = []int32{
-	9, // 0: presence.GetUserStatusResponse.last_seen:type_name -> google.protobuf.Timestamp
-	9, // 1: presence.UserStatusEvent.timestamp:type_name -> google.protobuf.Timestamp
-	0, // 2: presence.PresenceService.SetUserOnline:input_type -> presence.SetUserOnlineRequest
-	2, // 3: presence.PresenceService.SetUserOffline:input_type -> presence.SetUserOfflineRequest
-	4, // 4: presence.PresenceService.GetUserStatus:input_type -> presence.GetUserStatusRequest
-	6, // 5: presence.PresenceService.GetOnlineUsers:input_type -> presence.GetOnlineUsersRequest
-	1, // 6: presence.PresenceService.SetUserOnline:output_type -> presence.SetUserOnlineResponse
-	3, // 7: presence.PresenceService.SetUserOffline:output_type -> presence.SetUserOfflineResponse
-	5, // 8: presence.PresenceService.GetUserStatus:output_type -> presence.GetUserStatusResponse
-	7, // 9: presence.PresenceService.GetOnlineUsers:output_type -> presence.GetOnlineUsersResponse
-	6, // [6:10] is the sub-list for method output_type
-	2, // [2:6] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	11, // 0: presence.GetUserStatusResponse.last_seen:type_name -> google.protobuf.Timestamp
+	11, // 1: presence.UserStatusEvent.timestamp:type_name -> google.protobuf.Timestamp
+	0,  // 2: presence.PresenceService.SetUserOnline:input_type -> presence.SetUserOnlineRequest
+	2,  // 3: presence.PresenceService.SetUserOffline:input_type -> presence.SetUserOfflineRequest
+	4,  // 4: presence.PresenceService.GetUserStatus:input_type -> presence.GetUserStatusRequest
+	6,  // 5: presence.PresenceService.GetOnlineUsers:input_type -> presence.GetOnlineUsersRequest
+	8,  // 6: presence.PresenceService.ClearUser:input_type -> presence.ClearUserRequest
+	1,  // 7: presence.PresenceService.SetUserOnline:output_type -> presence.SetUserOnlineResponse
+	3,  // 8: presence.PresenceService.SetUserOffline:output_type -> presence.SetUserOfflineResponse
+	5,  // 9: presence.PresenceService.GetUserStatus:output_type -> presence.GetUserStatusResponse
+	7,  // 10: presence.PresenceService.GetOnlineUsers:output_type -> presence.GetOnlineUsersResponse
+	9,  // 11: presence.PresenceService.ClearUser:output_type -> presence.ClearUserResponse
+	7,  // [7:12] is the sub-list for method output_type
+	2,  // [2:7] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_presence_presence_proto_init() }
@@ -546,7 +653,7 @@ func file_proto_presence_presence_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_presence_presence_proto_rawDesc), len(file_proto_presence_presence_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   9,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
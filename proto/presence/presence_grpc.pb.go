@@ -23,6 +23,7 @@ const (
 	PresenceService_SetUserOffline_FullMethodName = "/presence.PresenceService/SetUserOffline"
 	PresenceService_GetUserStatus_FullMethodName  = "/presence.PresenceService/GetUserStatus"
 	PresenceService_GetOnlineUsers_FullMethodName = "/presence.PresenceService/GetOnlineUsers"
+	PresenceService_ClearUser_FullMethodName      = "/presence.PresenceService/ClearUser"
 )
 
 // PresenceServiceClient is the client API for PresenceService service.
@@ -33,6 +34,7 @@ type PresenceServiceClient interface {
 	SetUserOffline(ctx context.Context, in *SetUserOfflineRequest, opts ...grpc.CallOption) (*SetUserOfflineResponse, error)
 	GetUserStatus(ctx context.Context, in *GetUserStatusRequest, opts ...grpc.CallOption) (*GetUserStatusResponse, error)
 	GetOnlineUsers(ctx context.Context, in *GetOnlineUsersRequest, opts ...grpc.CallOption) (*GetOnlineUsersResponse, error)
+	ClearUser(ctx context.Context, in *ClearUserRequest, opts ...grpc.CallOption) (*ClearUserResponse, error)
 }
 
 type presenceServiceClient struct {
@@ -83,6 +85,16 @@ func (c *presenceServiceClient) GetOnlineUsers(ctx context.Context, in *GetOnlin
 	return out, nil
 }
 
+func (c *presenceServiceClient) ClearUser(ctx context.Context, in *ClearUserRequest, opts ...grpc.CallOption) (*ClearUserResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClearUserResponse)
+	err := c.cc.Invoke(ctx, PresenceService_ClearUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PresenceServiceServer is the server API for PresenceService service.
 // All implementations must embed UnimplementedPresenceServiceServer
 // for forward compatibility.
@@ -91,6 +103,7 @@ type PresenceServiceServer interface {
 	SetUserOffline(context.Context, *SetUserOfflineRequest) (*SetUserOfflineResponse, error)
 	GetUserStatus(context.Context, *GetUserStatusRequest) (*GetUserStatusResponse, error)
 	GetOnlineUsers(context.Context, *GetOnlineUsersRequest) (*GetOnlineUsersResponse, error)
+	ClearUser(context.Context, *ClearUserRequest) (*ClearUserResponse, error)
 	mustEmbedUnimplementedPresenceServiceServer()
 }
 
@@ -113,6 +126,9 @@ func (UnimplementedPresenceServiceServer) GetUserStatus(context.Context, *GetUse
 func (UnimplementedPresenceServiceServer) GetOnlineUsers(context.Context, *GetOnlineUsersRequest) (*GetOnlineUsersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetOnlineUsers not implemented")
 }
+func (UnimplementedPresenceServiceServer) ClearUser(context.Context, *ClearUserRequest) (*ClearUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearUser not implemented")
+}
 func (UnimplementedPresenceServiceServer) mustEmbedUnimplementedPresenceServiceServer() {}
 func (UnimplementedPresenceServiceServer) testEmbeddedByValue()                         {}
 
@@ -206,6 +222,24 @@ func _PresenceService_GetOnlineUsers_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PresenceService_ClearUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PresenceServiceServer).ClearUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PresenceService_ClearUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PresenceServiceServer).ClearUser(ctx, req.(*ClearUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PresenceService_ServiceDesc is the grpc.ServiceDesc for PresenceService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -229,6 +263,10 @@ var PresenceService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetOnlineUsers",
 			Handler:    _PresenceService_GetOnlineUsers_Handler,
 		},
+		{
+			MethodName: "ClearUser",
+			Handler:    _PresenceService_ClearUser_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/presence/presence.proto",
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	users "kubechat/proto/users"
+)
+
+// handleAccount deletes the caller's account on DELETE /account with
+// {"password": "...", "code": "..."}; code is only needed with two-factor
+// authentication. Every device, this one included, is disconnected.
+func (g *Gateway) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var deleteReq users.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&deleteReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	deleteReq.UserId = r.Context().Value("user_id").(string)
+	deleteReq.SessionId, _ = r.Context().Value("session_id").(string)
+
+	resp, err := g.usersClient.DeleteAccount(r.Context(), &deleteReq)
+	if err != nil {
+		log.Printf("Failed to delete account: %v", err)
+		http.Error(w, "Account deletion failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Success {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleExport downloads the caller's data from GET /account/export as a zip
+// archive streamed from users-service.
+func (g *Gateway) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stream, err := g.usersClient.ExportMyData(r.Context(), &users.ExportMyDataRequest{
+		UserId: r.Context().Value("user_id").(string),
+	})
+	if err != nil {
+		log.Printf("Failed to export data: %v", err)
+		http.Error(w, "Export failed", http.StatusInternalServerError)
+		return
+	}
+
+	// Headers wait for the first chunk so that an early failure can still
+	// be reported as an error
+	chunk, err := stream.Recv()
+	if err != nil {
+		log.Printf("Failed to export data: %v", err)
+		http.Error(w, "Export failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="kubechat-export.zip"`)
+	for {
+		if _, err := w.Write(chunk.Data); err != nil {
+			return
+		}
+		chunk, err = stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// Too late for a status; the truncated archive will not open
+			log.Printf("Export interrupted: %v", err)
+			return
+		}
+	}
+}
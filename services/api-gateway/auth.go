@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+
+	users "kubechat/proto/users"
+)
+
+// revocationSyncInterval is how often the denylist is reloaded from
+// users-service, covering revocations missed while NATS was unreachable.
+const revocationSyncInterval = time.Minute
+
+// accessClaims are the verified claims of an access token.
+type accessClaims struct {
+	UserID    string
+	TokenID   string
+	SessionID string
+	// EmailUnverified is set while the account may not send messages until
+	// its email address is verified
+	EmailUnverified bool
+}
+
+// denylist holds revoked access token IDs until the tokens expire.
+type denylist struct {
+	mutex  sync.RWMutex
+	tokens map[string]time.Time
+}
+
+func newDenylist() *denylist {
+	return &denylist{tokens: make(map[string]time.Time)}
+}
+
+func (d *denylist) add(tokenID string, expiresAt time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.tokens[tokenID] = expiresAt
+}
+
+func (d *denylist) contains(tokenID string) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	_, revoked := d.tokens[tokenID]
+	return revoked
+}
+
+// prune drops entries whose tokens have expired and would fail verification
+// anyway.
+func (d *denylist) prune(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for tokenID, expiresAt := range d.tokens {
+		if !expiresAt.After(now) {
+			delete(d.tokens, tokenID)
+		}
+	}
+}
+
+// subscribeToRevocations applies revocations published by users-service. An
+// event names a token, a whole session, or, with neither, every session of
+// the user; matching WebSocket connections are closed.
+func (g *Gateway) subscribeToRevocations() {
+	_, err := g.natsConn.Subscribe("auth.revoked", func(msg *nats.Msg) {
+		var revoked users.RevokedToken
+		if err := json.Unmarshal(msg.Data, &revoked); err != nil {
+			log.Printf("Failed to unmarshal revocation: %v", err)
+			return
+		}
+		if revoked.TokenId != "" {
+			g.revoked.add(revoked.TokenId, time.Unix(revoked.ExpiresAt, 0))
+		}
+		g.disconnectRevoked(&revoked)
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to revocations: %v", err)
+	}
+}
+
+func (g *Gateway) disconnectRevoked(revoked *users.RevokedToken) {
+	for _, client := range g.connectedClients() {
+		if client.UserID != revoked.UserId {
+			continue
+		}
+		switch {
+		case revoked.TokenId != "":
+			if client.TokenID != revoked.TokenId {
+				continue
+			}
+		case revoked.SessionId != "":
+			if client.SessionID != revoked.SessionId {
+				continue
+			}
+		}
+
+		log.Printf("Closing connection of user %s device %s: session revoked", client.UserID, client.DeviceID)
+		client.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session revoked"),
+			time.Now().Add(time.Second))
+		go g.closeClient(client)
+	}
+}
+
+// syncRevocations reloads the denylist from users-service until ctx is
+// cancelled.
+func (g *Gateway) syncRevocations(ctx context.Context) {
+	ticker := time.NewTicker(revocationSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		resp, err := g.usersClient.ListRevokedTokens(ctx, &users.ListRevokedTokensRequest{})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to load revoked tokens: %v", err)
+			}
+		} else {
+			for _, revoked := range resp.Tokens {
+				g.revoked.add(revoked.TokenId, time.Unix(revoked.ExpiresAt, 0))
+			}
+		}
+		g.revoked.prune(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *Gateway) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var refreshReq users.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&refreshReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	refreshReq.UserAgent = r.UserAgent()
+	refreshReq.IpAddress = g.clientIP(r)
+
+	resp, err := g.usersClient.RefreshToken(r.Context(), &refreshReq)
+	if err != nil {
+		http.Error(w, "Refresh failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Success {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleLogout ends the caller's session, or every session of the user with
+// ?all_devices=true.
+func (g *Gateway) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+	sessionID, _ := r.Context().Value("session_id").(string)
+
+	var resp *users.LogoutResponse
+	var err error
+	if r.URL.Query().Get("all_devices") == "true" {
+		resp, err = g.usersClient.LogoutAllDevices(r.Context(), &users.LogoutAllDevicesRequest{
+			UserId: userID,
+		})
+	} else {
+		resp, err = g.usersClient.Logout(r.Context(), &users.LogoutRequest{
+			UserId:    userID,
+			SessionId: sessionID,
+		})
+	}
+	if err != nil {
+		http.Error(w, "Logout failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
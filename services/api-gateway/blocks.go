@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	users "kubechat/proto/users"
+)
+
+// relations are the blocks and mutes that filter one connection's frames.
+// They are loaded on connect and kept current from users.relations events.
+type relations struct {
+	mutex     sync.RWMutex
+	blocked   map[string]bool // users this user blocked
+	blockedBy map[string]bool // users who blocked this user
+	muted     map[string]bool
+}
+
+// blocks reports whether either user blocked the other.
+func (r *relations) blocks(userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.blocked[userID] || r.blockedBy[userID]
+}
+
+// hiddenBy reports whether userID blocked this user, who must then not see
+// their presence.
+func (r *relations) hiddenBy(userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.blockedBy[userID]
+}
+
+func (r *relations) mutes(userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.muted[userID]
+}
+
+func (r *relations) set(relation map[string]bool, userID string, active bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if active {
+		relation[userID] = true
+	} else {
+		delete(relation, userID)
+	}
+}
+
+// loadRelations fills the client's relations from users-service. Without
+// them the connection is unfiltered until events arrive.
+func (g *Gateway) loadRelations(client *Client) {
+	client.relations.blocked = make(map[string]bool)
+	client.relations.blockedBy = make(map[string]bool)
+	client.relations.muted = make(map[string]bool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := g.usersClient.GetRelations(ctx, &users.GetRelationsRequest{UserId: client.UserID})
+	if err != nil {
+		log.Printf("Failed to load blocks and mutes of user %s: %v", client.UserID, err)
+		return
+	}
+	for _, userID := range resp.BlockedUserIds {
+		client.relations.blocked[userID] = true
+	}
+	for _, userID := range resp.BlockedByUserIds {
+		client.relations.blockedBy[userID] = true
+	}
+	for _, userID := range resp.MutedUserIds {
+		client.relations.muted[userID] = true
+	}
+}
+
+// subscribeToRelations applies blocks and mutes to the connections of both
+// users as they change.
+func (g *Gateway) subscribeToRelations() {
+	_, err := g.natsConn.Subscribe("users.relations", func(msg *nats.Msg) {
+		var event users.RelationEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Printf("Failed to unmarshal relation event: %v", err)
+			return
+		}
+
+		for _, client := range g.connectedClients() {
+			switch {
+			case client.UserID == event.UserId && event.Relation == "block":
+				client.relations.set(client.relations.blocked, event.TargetUserId, event.Active)
+			case client.UserID == event.UserId && event.Relation == "mute":
+				client.relations.set(client.relations.muted, event.TargetUserId, event.Active)
+			case client.UserID == event.TargetUserId && event.Relation == "block":
+				client.relations.set(client.relations.blockedBy, event.UserId, event.Active)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to relation events: %v", err)
+	}
+}
+
+// handleBlocks lists blocked users on GET /blocks, blocks one on POST /blocks
+// with {"target_user_id": "..."} and unblocks one on DELETE /blocks/{id}.
+func (g *Gateway) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	targetID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/blocks"), "/")
+
+	var resp *users.BlockUserResponse
+	var err error
+	switch {
+	case r.Method == http.MethodGet && targetID == "":
+		list, err := g.usersClient.ListBlocked(r.Context(), &users.ListBlockedRequest{UserId: userID})
+		if err != nil {
+			log.Printf("Failed to list blocked users: %v", err)
+			http.Error(w, "Failed to list blocked users", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+
+	case r.Method == http.MethodPost && targetID == "":
+		var blockReq users.BlockUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&blockReq); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		blockReq.UserId = userID
+		resp, err = g.usersClient.BlockUser(r.Context(), &blockReq)
+
+	case r.Method == http.MethodDelete && targetID != "":
+		resp, err = g.usersClient.UnblockUser(r.Context(), &users.BlockUserRequest{
+			UserId:       userID,
+			TargetUserId: targetID,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Failed to update block: %v", err)
+		http.Error(w, "Block update failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Success {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleMutes is handleBlocks for mutes, under /mutes.
+func (g *Gateway) handleMutes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	targetID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/mutes"), "/")
+
+	var resp *users.MuteUserResponse
+	var err error
+	switch {
+	case r.Method == http.MethodGet && targetID == "":
+		list, err := g.usersClient.ListMuted(r.Context(), &users.ListMutedRequest{UserId: userID})
+		if err != nil {
+			log.Printf("Failed to list muted users: %v", err)
+			http.Error(w, "Failed to list muted users", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+
+	case r.Method == http.MethodPost && targetID == "":
+		var muteReq users.MuteUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&muteReq); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		muteReq.UserId = userID
+		resp, err = g.usersClient.MuteUser(r.Context(), &muteReq)
+
+	case r.Method == http.MethodDelete && targetID != "":
+		resp, err = g.usersClient.UnmuteUser(r.Context(), &users.MuteUserRequest{
+			UserId:       userID,
+			TargetUserId: targetID,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Failed to update mute: %v", err)
+		http.Error(w, "Mute update failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Success {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
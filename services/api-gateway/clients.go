@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultDeviceID is used by clients that do not identify their device, so
+// they keep the old one-connection-per-user behaviour.
+const defaultDeviceID = "default"
+
+// Device IDs become part of JetStream consumer names, so keep them to a safe
+// character set.
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func parseDeviceID(deviceID string) (string, error) {
+	if deviceID == "" {
+		return defaultDeviceID, nil
+	}
+	if !deviceIDPattern.MatchString(deviceID) {
+		return "", fmt.Errorf("invalid device_id")
+	}
+	return deviceID, nil
+}
+
+// registerClient adds a device connection for the user. It returns the
+// connection previously registered for the same device, if any, and whether
+// this is the user's first connected device.
+func (g *Gateway) registerClient(client *Client) (replaced *Client, firstDevice bool) {
+	g.clientsMutex.Lock()
+	defer g.clientsMutex.Unlock()
+
+	devices, exists := g.clients[client.UserID]
+	if !exists {
+		devices = make(map[string]*Client)
+		g.clients[client.UserID] = devices
+	}
+	replaced = devices[client.DeviceID]
+	firstDevice = len(devices) == 0
+	devices[client.DeviceID] = client
+	return replaced, firstDevice
+}
+
+// unregisterClient removes the connection unless it has already been
+// replaced. It reports whether the connection was still the current one for
+// its device and whether the user has no devices left.
+func (g *Gateway) unregisterClient(client *Client) (current, lastDevice bool) {
+	g.clientsMutex.Lock()
+	defer g.clientsMutex.Unlock()
+
+	devices := g.clients[client.UserID]
+	if devices[client.DeviceID] == client {
+		delete(devices, client.DeviceID)
+		current = true
+	}
+	if len(devices) == 0 {
+		delete(g.clients, client.UserID)
+		return current, true
+	}
+	return current, false
+}
+
+// isCurrentClient reports whether client is still the registered connection
+// for its device.
+func (g *Gateway) isCurrentClient(client *Client) bool {
+	g.clientsMutex.RLock()
+	defer g.clientsMutex.RUnlock()
+
+	return g.clients[client.UserID][client.DeviceID] == client
+}
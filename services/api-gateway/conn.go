@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	presence "kubechat/proto/presence"
+)
+
+// Overflow policies for a client whose send queue is full.
+const (
+	// overflowDropOldest discards the oldest queued frame to make room.
+	overflowDropOldest = "drop_oldest"
+	// overflowDisconnect closes the connection; the client reconnects and
+	// replays whatever it has not acked.
+	overflowDisconnect = "disconnect"
+	// overflowSpill leaves durable messages in JetStream to be redelivered
+	// once the client catches up. Other frames are dropped.
+	overflowSpill = "spill"
+)
+
+// spillRedeliveryDelay is how long JetStream holds a spilled message before
+// trying the client again.
+const spillRedeliveryDelay = 5 * time.Second
+
+// connConfig controls WebSocket keepalive and backpressure.
+type connConfig struct {
+	pingInterval   time.Duration
+	pongWait       time.Duration
+	writeWait      time.Duration
+	maxMessageSize int64
+	sendQueueSize  int
+	overflowPolicy string
+	// resumeMaxMessages caps how many missed messages a resume replays
+	resumeMaxMessages int
+}
+
+func loadConnConfig() connConfig {
+	cfg := connConfig{
+		pingInterval:   envDuration("WS_PING_INTERVAL", 30*time.Second),
+		pongWait:       envDuration("WS_PONG_WAIT", 60*time.Second),
+		writeWait:      envDuration("WS_WRITE_WAIT", 10*time.Second),
+		maxMessageSize: 64 * 1024,
+		sendQueueSize:  256,
+		overflowPolicy: overflowDropOldest,
+
+		resumeMaxMessages: 1000,
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("WS_SEND_QUEUE_SIZE")); err == nil && v > 0 {
+		cfg.sendQueueSize = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("WS_RESUME_MAX_MESSAGES")); err == nil && v > 0 {
+		cfg.resumeMaxMessages = v
+	}
+	switch policy := os.Getenv("WS_OVERFLOW_POLICY"); policy {
+	case overflowDropOldest, overflowDisconnect, overflowSpill:
+		cfg.overflowPolicy = policy
+	case "":
+	default:
+		log.Printf("Unknown WS_OVERFLOW_POLICY %q, using %s", policy, cfg.overflowPolicy)
+	}
+
+	// A ping has to go out before the peer's read deadline passes
+	if cfg.pingInterval >= cfg.pongWait {
+		cfg.pingInterval = cfg.pongWait * 9 / 10
+	}
+	return cfg
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using %s", name, v, def)
+	}
+	return def
+}
+
+var (
+	droppedFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_ws_dropped_frames_total",
+		Help: "Frames discarded because a client's send queue was full",
+	}, []string{"policy"})
+	spilledFrames = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_ws_spilled_frames_total",
+		Help: "Durable messages left in JetStream for redelivery to a slow client",
+	})
+	slowConsumers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_ws_slow_consumers_total",
+		Help: "Connections whose send queue overflowed at least once",
+	}, []string{"policy"})
+)
+
+// enqueue hands a frame to the client's writer without blocking, applying the
+// overflow policy when the queue is full. durable frames come from JetStream
+// and are redelivered if not acked. It reports whether the frame was queued.
+func (g *Gateway) enqueue(client *Client, data []byte, durable bool) bool {
+	select {
+	case <-client.done:
+		return false
+	default:
+	}
+
+	if resuming, held := g.holdForResume(client, data, durable); resuming {
+		return held
+	}
+
+	select {
+	case client.Send <- data:
+		wsMessages.Inc()
+		return true
+	default:
+	}
+
+	policy := g.connConfig.overflowPolicy
+	g.markSlow(client, policy)
+	switch policy {
+	case overflowDisconnect:
+		droppedFrames.WithLabelValues(policy).Inc()
+		go g.closeClient(client)
+		return false
+
+	case overflowSpill:
+		if durable {
+			spilledFrames.Inc()
+		} else {
+			droppedFrames.WithLabelValues(policy).Inc()
+		}
+		return false
+
+	default:
+		// Other producers may refill the queue between the two steps, so
+		// give up after a few attempts rather than spin
+		for i := 0; i < 3; i++ {
+			select {
+			case <-client.Send:
+				droppedFrames.WithLabelValues(policy).Inc()
+			default:
+			}
+			select {
+			case client.Send <- data:
+				wsMessages.Inc()
+				return true
+			default:
+			}
+		}
+		droppedFrames.WithLabelValues(policy).Inc()
+		return false
+	}
+}
+
+// markSlow counts the client as a slow consumer the first time it overflows.
+func (g *Gateway) markSlow(client *Client, policy string) {
+	if client.slow.CompareAndSwap(false, true) {
+		log.Printf("Send queue full for user %s device %s, applying %s", client.UserID, client.DeviceID, policy)
+		slowConsumers.WithLabelValues(policy).Inc()
+	}
+}
+
+// closeClient is the only place a connection is torn down. It may be called
+// from any goroutine, any number of times; the work happens once.
+func (g *Gateway) closeClient(client *Client) {
+	client.closeOnce.Do(func() {
+		current, lastDevice := g.unregisterClient(client)
+		close(client.done)
+
+		// Unsubscribe from NATS messages; unacked JetStream messages are
+		// redelivered on the next connection
+		for _, sub := range client.Subscriptions {
+			if sub != nil {
+				sub.Unsubscribe()
+			}
+		}
+		g.clientsMutex.RLock()
+		consumer := client.Consumer
+		g.clientsMutex.RUnlock()
+		if consumer != nil {
+			consumer.Stop()
+		}
+
+		// A replaced connection's successor owns the registry entry. Otherwise
+		// the user goes offline only when their last device disconnects from
+		// every instance; connections closed by a drain stay registered so the
+		// user remains online while the client reconnects elsewhere.
+		if current && !g.draining.Load() && g.disconnectDevice(client, lastDevice) {
+			g.presenceClient.SetUserOffline(context.Background(), &presence.SetUserOfflineRequest{
+				UserId: client.UserID,
+			})
+		}
+
+		client.Conn.Close()
+		wsConnections.Dec()
+	})
+}
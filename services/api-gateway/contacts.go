@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	users "kubechat/proto/users"
+)
+
+// handleContacts lists the caller's contacts on GET /contacts and removes one
+// on DELETE /contacts/{id}. Requests are under /contacts/requests.
+func (g *Gateway) handleContacts(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	contactID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/contacts"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && contactID == "":
+		resp, err := g.usersClient.ListContacts(r.Context(), &users.ListContactsRequest{UserId: userID})
+		if err != nil {
+			log.Printf("Failed to list contacts: %v", err)
+			http.Error(w, "Failed to list contacts", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case r.Method == http.MethodDelete && contactID != "":
+		resp, err := g.usersClient.RemoveContact(r.Context(), &users.RemoveContactRequest{
+			UserId:    userID,
+			ContactId: contactID,
+		})
+		if err != nil {
+			log.Printf("Failed to remove contact: %v", err)
+			http.Error(w, "Failed to remove contact", http.StatusInternalServerError)
+			return
+		}
+		if !resp.Success {
+			http.Error(w, resp.Message, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleContactRequests lists pending requests on GET /contacts/requests,
+// sends one on POST /contacts/requests with {"contact_id": "..."}, and
+// answers one on POST /contacts/requests/{id}/accept, /decline or /cancel.
+func (g *Gateway) handleContactRequests(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	requestID, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/contacts/requests"), "/"), "/")
+
+	var resp *users.ContactRequestResponse
+	var err error
+	switch {
+	case r.Method == http.MethodGet && requestID == "":
+		list, err := g.usersClient.ListContactRequests(r.Context(), &users.ListContactRequestsRequest{UserId: userID})
+		if err != nil {
+			log.Printf("Failed to list contact requests: %v", err)
+			http.Error(w, "Failed to list contact requests", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+
+	case r.Method == http.MethodPost && requestID == "":
+		var sendReq users.SendContactRequestRequest
+		if err := json.NewDecoder(r.Body).Decode(&sendReq); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		sendReq.UserId = userID
+		resp, err = g.usersClient.SendContactRequest(r.Context(), &sendReq)
+
+	case r.Method == http.MethodPost && requestID != "":
+		actionReq := &users.ContactRequestActionRequest{
+			UserId:    userID,
+			RequestId: requestID,
+		}
+		switch action {
+		case "accept":
+			resp, err = g.usersClient.AcceptContactRequest(r.Context(), actionReq)
+		case "decline":
+			resp, err = g.usersClient.DeclineContactRequest(r.Context(), actionReq)
+		case "cancel":
+			resp, err = g.usersClient.CancelContactRequest(r.Context(), actionReq)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Failed to update contact request: %v", err)
+		http.Error(w, "Contact request failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Success {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
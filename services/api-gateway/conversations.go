@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	chat "kubechat/proto/chat"
+)
+
+// handleListConversations returns the caller's inbox from
+// GET /conversations?limit=20: each direct conversation and room with its
+// last message and unread count, most recently active first. Pass
+// next_cursor back as cursor for the next page.
+func (g *Gateway) handleListConversations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	resp, err := g.chatClient.ListConversations(r.Context(), &chat.ListConversationsRequest{
+		UserId: r.Context().Value("user_id").(string),
+		Cursor: query.Get("cursor"),
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		log.Printf("Failed to list conversations: %v", err)
+		http.Error(w, "Failed to list conversations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// messageStreamName is the JetStream stream chat-service publishes
+// chat.messages.* into.
+const messageStreamName = "CHAT_MESSAGES"
+
+// consumerInactiveThreshold is how long a device's consumer outlives its last
+// connection. device_id is chosen by the client, so consumers must not pile
+// up; a device that comes back later catches up through resume instead.
+const consumerInactiveThreshold = 24 * time.Hour
+
+// consumeUserMessages attaches the durable consumer for the client's device.
+// Every device has its own consumer so each receives all of the user's
+// messages, and a reconnecting device gets everything it has not acked yet.
+// A new consumer starts at the end of the stream; history is loaded over REST
+// or through resume.
+func (g *Gateway) consumeUserMessages(userID string, client *Client) (jetstream.ConsumeContext, error) {
+	if g.js == nil {
+		return nil, fmt.Errorf("jetstream not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config := jetstream.ConsumerConfig{
+		Durable:           "messages-" + userID + "-" + client.DeviceID,
+		FilterSubject:     "chat.messages." + userID,
+		DeliverPolicy:     jetstream.DeliverNewPolicy,
+		AckPolicy:         jetstream.AckExplicitPolicy,
+		AckWait:           30 * time.Second,
+		MaxAckPending:     cap(client.Send),
+		InactiveThreshold: consumerInactiveThreshold,
+	}
+	// The deliver policy of an existing consumer cannot be changed
+	existing, err := g.js.Consumer(ctx, messageStreamName, config.Durable)
+	switch {
+	case err == nil:
+		config.DeliverPolicy = existing.CachedInfo().Config.DeliverPolicy
+		client.consumerBacklog = true
+	case !errors.Is(err, jetstream.ErrConsumerNotFound):
+		return nil, err
+	}
+
+	consumer, err := g.js.CreateOrUpdateConsumer(ctx, messageStreamName, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client.pending = make(map[uint64]jetstream.Msg)
+
+	return consumer.Consume(func(msg jetstream.Msg) {
+		// Not acking leaves the message for the next connection
+		if !g.isCurrentClient(client) {
+			return
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			log.Printf("Failed to read JetStream metadata: %v", err)
+			return
+		}
+
+		response, err := chatMessageFrame(client, msg.Data())
+		if err != nil {
+			log.Printf("Failed to unmarshal chat message: %v", err)
+			// A malformed payload will never become deliverable
+			msg.Term()
+			return
+		}
+		response.Seq = meta.Sequence.Stream
+
+		client.pendingMutex.Lock()
+		client.pending[response.Seq] = msg
+		client.pendingMutex.Unlock()
+
+		// A message that could not be queued is handed back to JetStream;
+		// one dropped from the queue later is redelivered after AckWait
+		if !g.sendToClient(client, response) {
+			client.pendingMutex.Lock()
+			delete(client.pending, response.Seq)
+			client.pendingMutex.Unlock()
+			msg.NakWithDelay(spillRedeliveryDelay)
+		}
+	}, jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		log.Printf("Message consumer error for user %s: %v", userID, err)
+	}))
+}
+
+// ackMessage acknowledges a frame the client confirmed it received.
+func (g *Gateway) ackMessage(client *Client, seq uint64) {
+	client.pendingMutex.Lock()
+	msg, ok := client.pending[seq]
+	delete(client.pending, seq)
+	client.pendingMutex.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		log.Printf("Failed to ack message %d for user %s: %v", seq, client.UserID, err)
+	}
+}
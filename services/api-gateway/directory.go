@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	users "kubechat/proto/users"
+)
+
+// handleSearchUsers finds users by name: GET /users/search?q=ali&page_size=20.
+// Pass next_page_token back as page_token for the next page.
+func (g *Gateway) handleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("q") == "" {
+		http.Error(w, "q required", http.StatusBadRequest)
+		return
+	}
+	pageSize, _ := strconv.Atoi(query.Get("page_size"))
+
+	resp, err := g.usersClient.SearchUsers(r.Context(), &users.SearchUsersRequest{
+		Query:     query.Get("q"),
+		PageSize:  int32(pageSize),
+		PageToken: query.Get("page_token"),
+	})
+	if err != nil {
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleBatchGetUsers looks up to 100 users at once from {"user_ids": [...]}.
+func (g *Gateway) handleBatchGetUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batchReq users.BatchGetUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&batchReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.usersClient.BatchGetUsers(r.Context(), &batchReq)
+	if err != nil {
+		http.Error(w, "Lookup failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	users "kubechat/proto/users"
+)
+
+// messageFrames are the frame types that send or change message content,
+// refused to accounts whose email address is not verified yet.
+var messageFrames = map[string]bool{
+	"send_message":      true,
+	"send_room_message": true,
+	"edit_message":      true,
+}
+
+// mayMessage reports whether the client may send messages. Connections opened
+// with a restricted access token ask users-service again, so verifying the
+// address takes effect without reconnecting.
+func (g *Gateway) mayMessage(client *Client) bool {
+	if !client.emailUnverified.Load() {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := g.usersClient.GetUser(ctx, &users.GetUserRequest{
+		UserId:      client.UserID,
+		RequesterId: client.UserID,
+	})
+	if err != nil {
+		log.Printf("Failed to check email verification of user %s: %v", client.UserID, err)
+		return false
+	}
+	if resp.EmailVerified {
+		client.emailUnverified.Store(false)
+	}
+	return resp.EmailVerified
+}
+
+// handleVerifyEmail redeems the token from a verification link.
+func (g *Gateway) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var verifyReq users.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&verifyReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.usersClient.VerifyEmail(r.Context(), &verifyReq)
+	if err != nil {
+		http.Error(w, "Verification failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleResendVerification sends a new verification link. The answer does
+// not say whether the account exists.
+func (g *Gateway) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resendReq users.ResendVerificationEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&resendReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.usersClient.ResendVerificationEmail(r.Context(), &resendReq)
+	if err != nil {
+		http.Error(w, "Verification failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	users "kubechat/proto/users"
+)
+
+const (
+	// jwksRefreshInterval is how often the key set is refetched so retired
+	// keys stop being accepted.
+	jwksRefreshInterval = 5 * time.Minute
+	// jwksMinRefetch limits refetches triggered by tokens with an unknown kid,
+	// so forged headers cannot hammer users-service.
+	jwksMinRefetch = 10 * time.Second
+	// jwksStartupTimeout is how long the gateway waits for users-service to
+	// publish its keys before refusing to start.
+	jwksStartupTimeout = 30 * time.Second
+)
+
+// verificationKey is a public key from the JWKS and the algorithm it is
+// allowed to verify.
+type verificationKey struct {
+	alg    string
+	public crypto.PublicKey
+}
+
+// keySet caches a JWKS by kid: the users-service one for access tokens, or an
+// identity provider's for ID tokens.
+type keySet struct {
+	fetchKeys func(ctx context.Context) ([]*users.JsonWebKey, error)
+
+	mutex       sync.RWMutex
+	keys        map[string]verificationKey
+	lastFetched time.Time
+}
+
+func newKeySet(fetchKeys func(ctx context.Context) ([]*users.JsonWebKey, error)) *keySet {
+	return &keySet{
+		fetchKeys: fetchKeys,
+		keys:      make(map[string]verificationKey),
+	}
+}
+
+// usersServiceKeys fetches the keys that sign access tokens.
+func usersServiceKeys(usersClient users.UsersServiceClient) func(ctx context.Context) ([]*users.JsonWebKey, error) {
+	return func(ctx context.Context) ([]*users.JsonWebKey, error) {
+		resp, err := usersClient.GetJWKS(ctx, &users.GetJWKSRequest{})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Keys, nil
+	}
+}
+
+// load fetches the initial key set, retrying while users-service starts. It
+// fails after jwksStartupTimeout unless AUTH_DEV_MODE=true, in which case keys
+// are fetched on first use instead.
+func (k *keySet) load(ctx context.Context) error {
+	deadline := time.Now().Add(jwksStartupTimeout)
+	for {
+		err := k.fetch(ctx)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if os.Getenv("AUTH_DEV_MODE") == "true" {
+				log.Printf("WARNING: no signing keys yet, continuing in AUTH_DEV_MODE: %v", err)
+				return nil
+			}
+			return err
+		}
+		log.Printf("Waiting for signing keys: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// refresh refetches the key set periodically until ctx is cancelled.
+func (k *keySet) refresh(ctx context.Context) {
+	ticker := time.NewTicker(jwksRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.fetch(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to refresh signing keys, keeping cached ones: %v", err)
+			}
+		}
+	}
+}
+
+func (k *keySet) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	k.mutex.Lock()
+	k.lastFetched = time.Now()
+	k.mutex.Unlock()
+
+	jwks, err := k.fetchKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]verificationKey, len(jwks))
+	for _, jwk := range jwks {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			log.Printf("Skipping signing key %s: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable signing keys published")
+	}
+
+	k.mutex.Lock()
+	k.keys = keys
+	k.mutex.Unlock()
+	return nil
+}
+
+// lookup returns the key for kid, refetching once when it is unknown since
+// users-service may have just rotated.
+func (k *keySet) lookup(kid string) (verificationKey, bool) {
+	k.mutex.RLock()
+	key, ok := k.keys[kid]
+	stale := time.Since(k.lastFetched) >= jwksMinRefetch
+	k.mutex.RUnlock()
+	if ok || !stale {
+		return key, ok
+	}
+
+	if err := k.fetch(context.Background()); err != nil {
+		log.Printf("Failed to fetch signing keys: %v", err)
+		return verificationKey{}, false
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok = k.keys[kid]
+	return key, ok
+}
+
+// keyfunc resolves a token's verification key from its kid, accepting only
+// the algorithm the key was published for.
+func (k *keySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid")
+	}
+	key, ok := k.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// parseJWK accepts RS256, EdDSA and ES256 keys. Identity providers may leave
+// alg out, in which case the key type implies it.
+func parseJWK(jwk *users.JsonWebKey) (verificationKey, error) {
+	alg := jwk.Alg
+	if alg == "" {
+		switch jwk.Kty {
+		case "RSA":
+			alg = jwt.SigningMethodRS256.Alg()
+		case "OKP":
+			alg = jwt.SigningMethodEdDSA.Alg()
+		case "EC":
+			alg = jwt.SigningMethodES256.Alg()
+		}
+	}
+
+	switch {
+	case jwk.Kty == "RSA" && alg == jwt.SigningMethodRS256.Alg():
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		public := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return verificationKey{alg: alg, public: public}, nil
+
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519" && alg == jwt.SigningMethodEdDSA.Alg():
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return verificationKey{}, fmt.Errorf("invalid Ed25519 key length %d", len(x))
+		}
+		return verificationKey{alg: alg, public: ed25519.PublicKey(x)}, nil
+
+	case jwk.Kty == "EC" && jwk.Crv == "P-256" && alg == jwt.SigningMethodES256.Alg():
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		public := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return verificationKey{}, fmt.Errorf("EC point is not on P-256")
+		}
+		return verificationKey{alg: alg, public: public}, nil
+
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported key type %s/%s", jwk.Kty, alg)
+	}
+}
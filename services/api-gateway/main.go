@@ -5,16 +5,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/time/rate"
@@ -31,10 +37,20 @@ type Gateway struct {
 	chatClient     chat.ChatServiceClient
 	presenceClient presence.PresenceServiceClient
 	natsConn       *nats.Conn
+	js             jetstream.JetStream
 	mediaProxy     *httputil.ReverseProxy
-	clients        map[string]*Client
+	clients        map[string]map[string]*Client // user ID -> device ID -> connection
 	clientsMutex   sync.RWMutex
-	jwtSecret      []byte
+	keys           *keySet       // users-service JWKS
+	oidc           *oidcProvider // nil unless OIDC login is configured
+
+	revoked    *denylist // access tokens refused until they expire
+	registry   *Registry // cluster-wide connections, nil without JetStream
+	instanceID string
+	draining   atomic.Bool
+	connConfig connConfig
+
+	trustedProxies []*net.IPNet // may set X-Forwarded-For
 }
 
 var (
@@ -50,10 +66,34 @@ var (
 
 type Client struct {
 	UserID        string
+	DeviceID      string
+	TokenID       string // jti of the access token the connection was opened with
+	SessionID     string
 	Conn          *websocket.Conn
 	Send          chan []byte
 	Subscriptions []*nats.Subscription
+	Consumer      jetstream.ConsumeContext // durable message delivery, nil on core NATS
 	Limiter       *rate.Limiter
+
+	registryRevision uint64 // registry entry owned by this connection, 0 if unregistered
+
+	done      chan struct{} // closed once the connection is torn down
+	closeOnce sync.Once
+	slow      atomic.Bool // send queue has overflowed at least once
+
+	emailUnverified atomic.Bool // may not send messages until the address is verified
+	relations       relations   // blocks and mutes that filter frames
+
+	resumeMutex sync.Mutex
+	resuming    bool     // live frames are held while missed ones are replayed
+	held        [][]byte // live frames queued behind the replay
+
+	pendingMutex sync.Mutex
+	pending      map[uint64]jetstream.Msg // delivered but not yet acked by the client
+
+	// consumerBacklog is set when the device's consumer existed before this
+	// connection, so JetStream redelivers the messages it missed
+	consumerBacklog bool
 }
 
 type Message struct {
@@ -61,6 +101,8 @@ type Message struct {
 	UserID    string      `json:"user_id,omitempty"`
 	Content   interface{} `json:"content"`
 	Timestamp string      `json:"timestamp,omitempty"`
+	Seq       uint64      `json:"seq,omitempty"`   // JetStream sequence the client must ack
+	Muted     bool        `json:"muted,omitempty"` // From a muted sender; deliver without notifying
 }
 
 var upgrader = websocket.Upgrader{
@@ -69,25 +111,36 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func (g *Gateway) verifyToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return g.jwtSecret, nil
-	})
+func (g *Gateway) verifyToken(tokenString string) (*accessClaims, error) {
+	token, err := jwt.Parse(tokenString, g.keys.keyfunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if userID, ok := claims["user_id"].(string); ok {
-			return userID, nil
-		}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	userID, _ := claims["user_id"].(string)
+	tokenID, _ := claims["jti"].(string)
+	sessionID, _ := claims["sid"].(string)
+	emailVerified, hasEmailVerified := claims["email_verified"].(bool)
+	if userID == "" || tokenID == "" {
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
-	return "", fmt.Errorf("invalid token claims")
+	if g.revoked.contains(tokenID) {
+		return nil, fmt.Errorf("token revoked")
+	}
+
+	return &accessClaims{
+		UserID:          userID,
+		TokenID:         tokenID,
+		SessionID:       sessionID,
+		EmailUnverified: hasEmailVerified && !emailVerified,
+	}, nil
 }
 
 func (g *Gateway) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
@@ -107,31 +160,55 @@ func (g *Gateway) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		userID, err := g.verifyToken(tokenString)
+		claims, err := g.verifyToken(tokenString)
 		if err != nil {
 			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Add userID to context
-		ctx := context.WithValue(r.Context(), "user_id", userID)
+		// Add userID and session to context
+		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
+		ctx = context.WithValue(ctx, "session_id", claims.SessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
 func (g *Gateway) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Send new connections to the other replicas while shutting down
+	if g.draining.Load() {
+		http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	token := r.URL.Query().Get("token")
 	if token == "" {
 		http.Error(w, "Token required", http.StatusUnauthorized)
 		return
 	}
 
-	userID, err := g.verifyToken(token)
+	claims, err := g.verifyToken(token)
 	if err != nil {
 		log.Printf("Token verification failed: %v", err)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	userID := claims.UserID
+
+	// Each device keeps its own connection; reconnecting from the same device
+	// replaces the previous one
+	deviceID, err := parseDeviceID(r.URL.Query().Get("device_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A reconnecting client names the last message it received to have what
+	// it missed replayed
+	cursor, err := parseResumeCursor(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -140,61 +217,66 @@ func (g *Gateway) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		UserID:  userID,
-		Conn:    conn,
-		Send:    make(chan []byte, 256),
-		Limiter: rate.NewLimiter(rate.Every(200*time.Millisecond), 5), // 5 msg/s burst
-	}
+		UserID:    userID,
+		DeviceID:  deviceID,
+		TokenID:   claims.TokenID,
+		SessionID: claims.SessionID,
+		Conn:      conn,
+		Send:      make(chan []byte, g.connConfig.sendQueueSize),
+		done:      make(chan struct{}),
+		Limiter:   rate.NewLimiter(rate.Every(200*time.Millisecond), 5), // 5 msg/s burst
+		resuming:  cursor != nil,
+	}
+	client.emailUnverified.Store(claims.EmailUnverified)
+	g.loadRelations(client)
 	wsConnections.Inc()
 
-	// Subscribe to user's messages and events before adding to clients map
-	msgSub := g.subscribeToUserMessages(userID, client)
+	// Subscribe to user's events before adding to clients map
 	eventSub := g.subscribeToUserEvents(userID, client)
-	client.Subscriptions = []*nats.Subscription{msgSub, eventSub}
-
-	g.clientsMutex.Lock()
-	// Check if user already has a connection and clean it up
-	if existingClient, exists := g.clients[userID]; exists {
-		existingClient.Conn.Close()
-		for _, sub := range existingClient.Subscriptions {
-			if sub != nil {
-				sub.Unsubscribe()
-			}
-		}
+	client.Subscriptions = []*nats.Subscription{eventSub}
+
+	// Check if this device already has a connection and clean it up
+	existingClient, firstDevice := g.registerClient(client)
+	if existingClient != nil {
+		g.closeClient(existingClient)
 	}
-	g.clients[userID] = client
-	g.clientsMutex.Unlock()
 
-	// Set user online
-	g.presenceClient.SetUserOnline(context.Background(), &presence.SetUserOnlineRequest{
-		UserId: userID,
-	})
+	// Messages come from a durable JetStream consumer when possible so that
+	// anything sent while the user was offline is replayed. It starts after
+	// registration because the backlog is delivered immediately.
+	if consumer, err := g.consumeUserMessages(userID, client); err == nil {
+		g.clientsMutex.Lock()
+		client.Consumer = consumer
+		g.clientsMutex.Unlock()
+	} else {
+		log.Printf("Durable delivery unavailable for user %s, using core NATS: %v", userID, err)
+		client.Subscriptions = append(client.Subscriptions, g.subscribeToUserMessages(userID, client))
+	}
+
+	// The user comes online with their first device on any instance
+	if g.connectDevice(client, firstDevice) {
+		g.presenceClient.SetUserOnline(context.Background(), &presence.SetUserOnlineRequest{
+			UserId: userID,
+		})
+	}
 
 	go g.writePump(client)
 	go g.readPump(client)
+
+	if cursor != nil {
+		go g.resumeSession(client, cursor)
+	}
 }
 
 func (g *Gateway) readPump(client *Client) {
-	defer func() {
-		g.clientsMutex.Lock()
-		delete(g.clients, client.UserID)
-		g.clientsMutex.Unlock()
-
-		// Unsubscribe from NATS messages
-		for _, sub := range client.Subscriptions {
-			if sub != nil {
-				sub.Unsubscribe()
-			}
-		}
-
-		// Set user offline
-		g.presenceClient.SetUserOffline(context.Background(), &presence.SetUserOfflineRequest{
-			UserId: client.UserID,
-		})
+	defer g.closeClient(client)
 
-		client.Conn.Close()
-		wsConnections.Dec()
-	}()
+	// A client that stops answering pings is dropped after pongWait
+	client.Conn.SetReadLimit(g.connConfig.maxMessageSize)
+	client.Conn.SetReadDeadline(time.Now().Add(g.connConfig.pongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(g.connConfig.pongWait))
+	})
 
 	for {
 		var msg Message
@@ -204,8 +286,9 @@ func (g *Gateway) readPump(client *Client) {
 			break
 		}
 
-		// Rate limiting
-		if !client.Limiter.Allow() {
+		// Rate limiting; acks are exempt because a replayed backlog produces
+		// one per message
+		if msg.Type != "ack" && !client.Limiter.Allow() {
 			log.Printf("Rate limit exceeded for user %s", client.UserID)
 			continue
 		}
@@ -215,25 +298,40 @@ func (g *Gateway) readPump(client *Client) {
 }
 
 func (g *Gateway) writePump(client *Client) {
-	defer client.Conn.Close()
+	ticker := time.NewTicker(g.connConfig.pingInterval)
+	defer func() {
+		ticker.Stop()
+		g.closeClient(client)
+	}()
 
 	for {
 		select {
-		case message, ok := <-client.Send:
-			if !ok {
-				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+		case <-client.done:
+			return
 
+		case message := <-client.Send:
+			client.Conn.SetWriteDeadline(time.Now().Add(g.connConfig.writeWait))
 			if err := client.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				log.Printf("WebSocket write error: %v", err)
 				return
 			}
+
+		case <-ticker.C:
+			client.Conn.SetWriteDeadline(time.Now().Add(g.connConfig.writeWait))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("WebSocket ping failed for user %s: %v", client.UserID, err)
+				return
+			}
 		}
 	}
 }
 
 func (g *Gateway) handleMessage(client *Client, msg *Message) {
+	if messageFrames[msg.Type] && !g.mayMessage(client) {
+		g.sendError(client, "Verify your email address to send messages")
+		return
+	}
+
 	switch msg.Type {
 	case "send_message":
 		if content, ok := msg.Content.(map[string]interface{}); ok {
@@ -247,6 +345,8 @@ func (g *Gateway) handleMessage(client *Client, msg *Message) {
 					})
 					if err != nil {
 						log.Printf("Failed to send message: %v", err)
+					} else if !resp.Success {
+						g.sendError(client, resp.Error)
 					} else {
 						log.Printf("Message sent: %s", resp.MessageId)
 					}
@@ -254,34 +354,81 @@ func (g *Gateway) handleMessage(client *Client, msg *Message) {
 			}
 		}
 
+	case "edit_message":
+		if content, ok := msg.Content.(map[string]interface{}); ok {
+			messageID, _ := content["message_id"].(string)
+			messageText, _ := content["message"].(string)
+
+			// Participants are notified with a message_edited frame via NATS
+			resp, err := g.chatClient.EditMessage(context.Background(), &chat.EditMessageRequest{
+				MessageId: messageID,
+				UserId:    client.UserID,
+				Message:   messageText,
+			})
+			if err != nil {
+				log.Printf("Failed to edit message: %v", err)
+				g.sendError(client, "Failed to edit message")
+			} else if !resp.Success {
+				g.sendError(client, resp.Error)
+			}
+		}
+
+	case "delete_message":
+		if content, ok := msg.Content.(map[string]interface{}); ok {
+			messageID, _ := content["message_id"].(string)
+			forEveryone, _ := content["for_everyone"].(bool)
+
+			// Affected clients are notified with a message_deleted frame via NATS
+			resp, err := g.chatClient.DeleteMessage(context.Background(), &chat.DeleteMessageRequest{
+				MessageId:   messageID,
+				UserId:      client.UserID,
+				ForEveryone: forEveryone,
+			})
+			if err != nil {
+				log.Printf("Failed to delete message: %v", err)
+				g.sendError(client, "Failed to delete message")
+			} else if !resp.Success {
+				g.sendError(client, resp.Error)
+			}
+		}
+
 	case "typing_event":
 		if content, ok := msg.Content.(map[string]interface{}); ok {
 			recipientID, _ := content["recipient_id"].(string)
 			isTyping, _ := content["is_typing"].(bool)
-			
+
 			event := chat.TypingEvent{
 				SenderId:    client.UserID,
 				RecipientId: recipientID,
 				IsTyping:    isTyping,
 			}
-			
-			data, _ := json.Marshal(event)
+
+			data, _ := json.Marshal(&event)
 			g.natsConn.Publish("chat.events."+recipientID, data)
 		}
 
 	case "read_receipt":
+		// Reads are stored so the sender sees them even after reconnecting;
+		// chat-service notifies the sender
 		if content, ok := msg.Content.(map[string]interface{}); ok {
-			recipientID, _ := content["recipient_id"].(string)
 			messageID, _ := content["message_id"].(string)
-			
-			receipt := chat.ReadReceipt{
-				SenderId:    client.UserID,
-				RecipientId: recipientID,
-				MessageId:   messageID,
+
+			resp, err := g.chatClient.MarkMessageRead(context.Background(), &chat.MarkMessageReadRequest{
+				MessageId: messageID,
+				UserId:    client.UserID,
+			})
+			if err != nil {
+				log.Printf("Failed to mark message read: %v", err)
+			} else if !resp.Success {
+				log.Printf("Read receipt from user %s rejected: %s", client.UserID, resp.Error)
+			}
+		}
+
+	case "ack":
+		if content, ok := msg.Content.(map[string]interface{}); ok {
+			if seq, ok := content["seq"].(float64); ok {
+				g.ackMessage(client, uint64(seq))
 			}
-			
-			data, _ := json.Marshal(receipt)
-			g.natsConn.Publish("chat.events."+recipientID, data)
 		}
 
 	case "get_online_users":
@@ -291,52 +438,99 @@ func (g *Gateway) handleMessage(client *Client, msg *Message) {
 			return
 		}
 
-		response := Message{
+		online := make([]string, 0, len(resp.UserIds))
+		for _, userID := range resp.UserIds {
+			if !client.relations.hiddenBy(userID) {
+				online = append(online, userID)
+			}
+		}
+
+		g.sendToClient(client, Message{
 			Type:    "online_users",
-			Content: resp.UserIds,
+			Content: online,
+		})
+
+	default:
+		if !g.handleRoomMessage(client, msg) {
+			log.Printf("Unknown message type from user %s: %s", client.UserID, msg.Type)
 		}
+	}
+}
 
-		data, _ := json.Marshal(response)
-		client.Send <- data
-		wsMessages.Inc()
+// sendToClient queues a frame for the client without blocking the caller. It
+// reports whether the frame was queued.
+func (g *Gateway) sendToClient(client *Client, response Message) bool {
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to marshal %s frame: %v", response.Type, err)
+		return false
 	}
+
+	// Frames with a sequence number are JetStream deliveries
+	return g.enqueue(client, data, response.Seq != 0)
+}
+
+func (g *Gateway) sendError(client *Client, message string) {
+	g.sendToClient(client, Message{
+		Type:    "error",
+		Content: message,
+	})
 }
 
 func (g *Gateway) subscribeToUserEvents(userID string, client *Client) *nats.Subscription {
 	subject := "chat.events." + userID
 	sub, err := g.natsConn.Subscribe(subject, func(msg *nats.Msg) {
-		g.clientsMutex.RLock()
-		currentClient, exists := g.clients[userID]
-		g.clientsMutex.RUnlock()
-
-		if exists && currentClient == client {
+		if g.isCurrentClient(client) {
 			// Determine event type from JSON content
 			var raw map[string]interface{}
 			json.Unmarshal(msg.Data, &raw)
-			
+
+			// Typing indicators and read receipts never cross a block
 			var response Message
 			if _, ok := raw["is_typing"]; ok {
 				var event chat.TypingEvent
 				json.Unmarshal(msg.Data, &event)
+				if client.relations.blocks(event.SenderId) {
+					return
+				}
 				response = Message{
 					Type:    "typing_event",
-					Content: event,
+					Content: &event,
+				}
+			} else if _, ok := raw["action"]; ok {
+				var event users.ContactRequestEvent
+				json.Unmarshal(msg.Data, &event)
+				response = Message{
+					Type:    "contact_request",
+					Content: &event,
+				}
+			} else if eventType, ok := raw["event_type"].(string); ok {
+				var event chat.RoomEvent
+				json.Unmarshal(msg.Data, &event)
+				response = Message{
+					Type:    eventType,
+					Content: &event,
+				}
+			} else if _, ok := raw["conversation"]; ok {
+				var event chat.ConversationEvent
+				json.Unmarshal(msg.Data, &event)
+				response = Message{
+					Type:    "conversation_updated",
+					Content: event.Conversation,
 				}
 			} else if _, ok := raw["message_id"]; ok {
 				var receipt chat.ReadReceipt
 				json.Unmarshal(msg.Data, &receipt)
+				if client.relations.blocks(receipt.SenderId) {
+					return
+				}
 				response = Message{
 					Type:    "read_receipt",
-					Content: receipt,
+					Content: &receipt,
 				}
 			}
-			
-			data, _ := json.Marshal(response)
-			select {
-			case client.Send <- data:
-			default:
-				// Channel probably closed
-			}
+
+			g.sendToClient(client, response)
 		}
 	})
 
@@ -351,33 +545,15 @@ func (g *Gateway) subscribeToUserEvents(userID string, client *Client) *nats.Sub
 func (g *Gateway) subscribeToUserMessages(userID string, client *Client) *nats.Subscription {
 	subject := "chat.messages." + userID
 	sub, err := g.natsConn.Subscribe(subject, func(msg *nats.Msg) {
-		g.clientsMutex.RLock()
-		currentClient, exists := g.clients[userID]
-		g.clientsMutex.RUnlock()
-
-		// Only process if this is still the current client for this user
-		if exists && currentClient == client {
-			var chatMessage chat.Message
-			err := json.Unmarshal(msg.Data, &chatMessage)
+		// Only process if this is still the current connection for this device
+		if g.isCurrentClient(client) {
+			response, err := chatMessageFrame(client, msg.Data)
 			if err != nil {
 				log.Printf("Failed to unmarshal chat message: %v", err)
 				return
 			}
 
-			response := Message{
-				Type:    "new_message",
-				Content: chatMessage,
-			}
-
-			data, _ := json.Marshal(response)
-			select {
-			case client.Send <- data:
-			default:
-				close(client.Send)
-				g.clientsMutex.Lock()
-				delete(g.clients, userID)
-				g.clientsMutex.Unlock()
-			}
+			g.sendToClient(client, response)
 		}
 	})
 
@@ -389,6 +565,34 @@ func (g *Gateway) subscribeToUserMessages(userID string, client *Client) *nats.S
 	return sub
 }
 
+// chatMessageFrame converts a chat.messages.* payload into a frame for the
+// client.
+func chatMessageFrame(client *Client, data []byte) (Message, error) {
+	var chatMessage chat.Message
+	if err := json.Unmarshal(data, &chatMessage); err != nil {
+		return Message{}, err
+	}
+	return messageFrame(client, &chatMessage), nil
+}
+
+// messageFrame picks the frame type for a message and flags messages from
+// senders the client muted. Edits and deletions are delivered on the same
+// subject as the original message.
+func messageFrame(client *Client, chatMessage *chat.Message) Message {
+	messageType := "new_message"
+	if chatMessage.Deleted {
+		messageType = "message_deleted"
+	} else if chatMessage.EditedAt != nil {
+		messageType = "message_edited"
+	}
+
+	return Message{
+		Type:    messageType,
+		Content: chatMessage,
+		Muted:   client.relations.mutes(chatMessage.SenderId),
+	}
+}
+
 func (g *Gateway) subscribeToUserStatus() {
 	g.natsConn.Subscribe("users.status", func(msg *nats.Msg) {
 		var statusEvent presence.UserStatusEvent
@@ -400,22 +604,17 @@ func (g *Gateway) subscribeToUserStatus() {
 
 		response := Message{
 			Type:    "user_status",
-			Content: statusEvent,
+			Content: &statusEvent,
 		}
 
 		data, _ := json.Marshal(response)
 
-		// Broadcast to all connected clients
-		g.clientsMutex.RLock()
-		for _, client := range g.clients {
-			select {
-			case client.Send <- data:
-			default:
-				close(client.Send)
-				// Avoid map write under read lock; removal is handled on client tear-down
+		// Broadcast to all connected clients but those the user blocked
+		for _, client := range g.connectedClients() {
+			if !client.relations.hiddenBy(statusEvent.UserId) {
+				g.enqueue(client, data, false)
 			}
 		}
-		g.clientsMutex.RUnlock()
 	})
 }
 
@@ -431,6 +630,8 @@ func (g *Gateway) handleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	loginReq.UserAgent = r.UserAgent()
+	loginReq.IpAddress = g.clientIP(r)
 
 	resp, err := g.usersClient.LoginUser(context.Background(), &loginReq)
 	if err != nil {
@@ -438,8 +639,7 @@ func (g *Gateway) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	writeLoginResponse(w, resp)
 }
 
 func (g *Gateway) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -480,7 +680,8 @@ func (g *Gateway) handleGetUser(w http.ResponseWriter, r *http.Request) {
 	userID := path[6:] // Remove "/user/" prefix
 
 	resp, err := g.usersClient.GetUser(context.Background(), &users.GetUserRequest{
-		UserId: userID,
+		UserId:      userID,
+		RequesterId: r.Context().Value("user_id").(string),
 	})
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -500,29 +701,77 @@ func (g *Gateway) handleGetChatHistory(w http.ResponseWriter, r *http.Request) {
 	// Extract parameters from query string
 	userID1 := r.URL.Query().Get("user1")
 	userID2 := r.URL.Query().Get("user2")
+	roomID := r.URL.Query().Get("room_id")
+	authUserID, _ := r.Context().Value("user_id").(string)
 
-	if userID1 == "" || userID2 == "" {
-		http.Error(w, "Both user1 and user2 parameters required", http.StatusBadRequest)
+	var historyReq *chat.GetMessageHistoryRequest
+	if roomID != "" {
+		// Chat service checks room membership for the authenticated user
+		historyReq = &chat.GetMessageHistoryRequest{
+			UserId1:  authUserID,
+			RoomId:   roomID,
+			Limit:    50, // Default limit
+			ViewerId: authUserID,
+		}
+	} else {
+		if userID1 == "" || userID2 == "" {
+			http.Error(w, "Both user1 and user2 parameters required", http.StatusBadRequest)
+			return
+		}
+
+		// Verify that the authenticated user is one of the participants
+		if userID1 != authUserID && userID2 != authUserID {
+			http.Error(w, "Forbidden: You can only access your own chat history", http.StatusForbidden)
+			return
+		}
+
+		historyReq = &chat.GetMessageHistoryRequest{
+			UserId1:  userID1,
+			UserId2:  userID2,
+			Limit:    50, // Default limit
+			Offset:   0,
+			ViewerId: authUserID,
+		}
+	}
+
+	// Get chat history from chat service
+	resp, err := g.chatClient.GetMessageHistory(context.Background(), historyReq)
+	if err != nil {
+		log.Printf("Failed to get chat history: %v", err)
+		http.Error(w, "Failed to get chat history", http.StatusInternalServerError)
 		return
 	}
 
-	// Verify that the authenticated user is one of the participants
-	authUserID, _ := r.Context().Value("user_id").(string)
-	if userID1 != authUserID && userID2 != authUserID {
-		http.Error(w, "Forbidden: You can only access your own chat history", http.StatusForbidden)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (g *Gateway) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get chat history from chat service
-	resp, err := g.chatClient.GetMessageHistory(context.Background(), &chat.GetMessageHistoryRequest{
-		UserId1: userID1,
-		UserId2: userID2,
-		Limit:   50, // Default limit
-		Offset:  0,
+	// Extract message ID from URL path
+	messageID := strings.TrimPrefix(r.URL.Path, "/chat/messages/")
+	if messageID == "" {
+		http.Error(w, "Message ID required", http.StatusBadRequest)
+		return
+	}
+
+	authUserID, _ := r.Context().Value("user_id").(string)
+	resp, err := g.chatClient.DeleteMessage(context.Background(), &chat.DeleteMessageRequest{
+		MessageId:   messageID,
+		UserId:      authUserID,
+		ForEveryone: r.URL.Query().Get("for_everyone") == "true",
 	})
 	if err != nil {
-		log.Printf("Failed to get chat history: %v", err)
-		http.Error(w, "Failed to get chat history", http.StatusInternalServerError)
+		log.Printf("Failed to delete message: %v", err)
+		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		return
+	}
+	if !resp.Success {
+		http.Error(w, resp.Error, http.StatusNotFound)
 		return
 	}
 
@@ -532,7 +781,7 @@ func (g *Gateway) handleGetChatHistory(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	// Metrics
-	prometheus.MustRegister(wsConnections, wsMessages)
+	prometheus.MustRegister(wsConnections, wsMessages, droppedFrames, spilledFrames, slowConsumers)
 	metricsAddr := os.Getenv("METRICS_ADDR_GATEWAY")
 	if metricsAddr == "" {
 		metricsAddr = ":9090"
@@ -584,6 +833,11 @@ func main() {
 	}
 	defer nc.Close()
 
+	js, err := jetstream.New(nc)
+	if err != nil {
+		log.Printf("JetStream unavailable, messages will not be replayed: %v", err)
+	}
+
 	// Media Proxy
 	mediaURL := os.Getenv("MEDIA_SERVICE_URL")
 	if mediaURL == "" {
@@ -595,39 +849,135 @@ func main() {
 	}
 	proxy := httputil.NewSingleHostReverseProxy(remote)
 
-	// JWT secret
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Println("WARNING: JWT_SECRET not set; using default for demo")
-		secret = "default_secret_change_me"
-	}
-
 	gateway := &Gateway{
 		usersClient:    users.NewUsersServiceClient(usersConn),
 		chatClient:     chat.NewChatServiceClient(chatConn),
 		presenceClient: presence.NewPresenceServiceClient(presenceConn),
 		natsConn:       nc,
+		js:             js,
 		mediaProxy:     proxy,
-		clients:        make(map[string]*Client),
-		jwtSecret:      []byte(secret),
+		clients:        make(map[string]map[string]*Client),
+		keys:           newKeySet(usersServiceKeys(users.NewUsersServiceClient(usersConn))),
+		revoked:        newDenylist(),
+		instanceID:     instanceID(),
+		connConfig:     loadConnConfig(),
+		trustedProxies: loadTrustedProxies(),
+	}
+
+	// Share connection state with the other gateway replicas
+	registryCtx, stopRegistry := context.WithCancel(context.Background())
+	if js != nil {
+		registry, err := newRegistry(registryCtx, js, gateway.instanceID)
+		if err != nil {
+			log.Printf("Connection registry unavailable, presence is tracked per instance: %v", err)
+		} else {
+			gateway.registry = registry
+			go registry.Run(registryCtx, func(userID string) {
+				log.Printf("User %s lost their last connection with a failed gateway", userID)
+				gateway.presenceClient.SetUserOffline(context.Background(), &presence.SetUserOfflineRequest{
+					UserId: userID,
+				})
+			})
+		}
 	}
+	gateway.subscribeToEvictions()
 
-	// Subscribe to user status updates
+	// Subscribe to user status and profile updates
 	gateway.subscribeToUserStatus()
+	gateway.subscribeToProfiles()
+	gateway.subscribeToRelations()
+
+	// Verify access tokens against the users-service signing keys
+	if err := gateway.keys.load(registryCtx); err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	go gateway.keys.refresh(registryCtx)
+
+	// Sign in through an external identity provider when configured
+	if cfg := loadOIDCConfig(); cfg != nil {
+		gateway.oidc = newOIDCProvider(cfg)
+		log.Printf("OIDC login enabled with issuer %s", cfg.issuer)
+	}
+
+	// Refuse revoked tokens and disconnect their sessions
+	gateway.subscribeToRevocations()
+	go gateway.syncRevocations(registryCtx)
 
 	// HTTP routes
 	http.HandleFunc("/ws", gateway.handleWebSocket)
 	http.HandleFunc("/login", gateway.handleLogin)
+	http.HandleFunc("/login/2fa", gateway.handleLoginTOTP)
+	http.HandleFunc("/auth/oidc/login", gateway.handleOIDCLogin)
+	http.HandleFunc("/auth/oidc/callback", gateway.handleOIDCCallback)
 	http.HandleFunc("/register", gateway.handleRegister)
+	http.HandleFunc("/refresh", gateway.handleRefresh)
+	http.HandleFunc("/logout", gateway.authMiddleware(gateway.handleLogout))
+	http.HandleFunc("/sessions", gateway.authMiddleware(gateway.handleSessions))
+	http.HandleFunc("/2fa/enroll", gateway.authMiddleware(gateway.handleTOTPEnroll))
+	http.HandleFunc("/2fa/confirm", gateway.authMiddleware(gateway.handleTOTPConfirm))
+	http.HandleFunc("/2fa/disable", gateway.authMiddleware(gateway.handleTOTPDisable))
+	http.HandleFunc("/password/change", gateway.authMiddleware(gateway.handleChangePassword))
+	http.HandleFunc("/password/reset/request", gateway.handleRequestPasswordReset)
+	http.HandleFunc("/password/reset", gateway.handleResetPassword)
+	http.HandleFunc("/email/verify", gateway.handleVerifyEmail)
+	http.HandleFunc("/email/verify/resend", gateway.handleResendVerification)
+	http.HandleFunc("/sessions/", gateway.authMiddleware(gateway.handleSessions))
 	http.HandleFunc("/upload", gateway.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		// Uploads are filed under their owner so they go with the account
+		r.Header.Set("X-User-ID", r.Context().Value("user_id").(string))
 		gateway.mediaProxy.ServeHTTP(w, r)
 	}))
 	http.HandleFunc("/user/", gateway.authMiddleware(gateway.handleGetUser))
+	http.HandleFunc("/user/me", gateway.authMiddleware(gateway.handleProfile))
+	http.HandleFunc("/users/search", gateway.authMiddleware(gateway.handleSearchUsers))
+	http.HandleFunc("/users/batch", gateway.authMiddleware(gateway.handleBatchGetUsers))
+	http.HandleFunc("/contacts", gateway.authMiddleware(gateway.handleContacts))
+	http.HandleFunc("/contacts/", gateway.authMiddleware(gateway.handleContacts))
+	http.HandleFunc("/contacts/requests", gateway.authMiddleware(gateway.handleContactRequests))
+	http.HandleFunc("/contacts/requests/", gateway.authMiddleware(gateway.handleContactRequests))
+	http.HandleFunc("/blocks", gateway.authMiddleware(gateway.handleBlocks))
+	http.HandleFunc("/blocks/", gateway.authMiddleware(gateway.handleBlocks))
+	http.HandleFunc("/mutes", gateway.authMiddleware(gateway.handleMutes))
+	http.HandleFunc("/mutes/", gateway.authMiddleware(gateway.handleMutes))
+	http.HandleFunc("/account", gateway.authMiddleware(gateway.handleAccount))
+	http.HandleFunc("/account/export", gateway.authMiddleware(gateway.handleExport))
 	http.HandleFunc("/chat/history", gateway.authMiddleware(gateway.handleGetChatHistory))
+	http.HandleFunc("/chat/search", gateway.authMiddleware(gateway.handleSearchMessages))
+	http.HandleFunc("/conversations", gateway.authMiddleware(gateway.handleListConversations))
+	http.HandleFunc("/chat/messages/", gateway.authMiddleware(gateway.handleDeleteMessage))
+	http.HandleFunc("/readyz", gateway.handleReady)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "/app/demo/index.html")
 	})
 
-	log.Println("API Gateway listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	drainTimeout := 20 * time.Second
+	if v := os.Getenv("GATEWAY_DRAIN_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			drainTimeout = d
+		}
+	}
+
+	srv := &http.Server{Addr: ":8080"}
+	go func() {
+		log.Printf("API Gateway %s listening on :8080", gateway.instanceID)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to serve: %v", err)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	log.Println("Shutting down API Gateway...")
+	gateway.drain(drainTimeout)
+
+	stopRegistry()
+	if gateway.registry != nil {
+		gateway.registry.Leave(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
 }
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	users "kubechat/proto/users"
+)
+
+const (
+	// oidcStateCookie holds the state, nonce and PKCE verifier between the
+	// redirect to the provider and the callback, so any replica can finish
+	// the login.
+	oidcStateCookie = "kubechat_oidc"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// oidcConfig is the OpenID Connect client registration, from OIDC_* env vars.
+type oidcConfig struct {
+	issuer       string
+	clientID     string
+	clientSecret string // Empty for public clients, which rely on PKCE alone
+	redirectURL  string
+	scopes       string
+	// postLoginURL receives the kubechat tokens in the URL fragment
+	postLoginURL string
+}
+
+// loadOIDCConfig returns nil when OIDC login is not configured.
+func loadOIDCConfig() *oidcConfig {
+	cfg := &oidcConfig{
+		issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER_URL"), "/"),
+		clientID:     os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		scopes:       os.Getenv("OIDC_SCOPES"),
+		postLoginURL: os.Getenv("OIDC_POST_LOGIN_URL"),
+	}
+	if cfg.issuer == "" {
+		return nil
+	}
+	if cfg.clientID == "" || cfg.redirectURL == "" {
+		log.Printf("OIDC_ISSUER_URL set without OIDC_CLIENT_ID and OIDC_REDIRECT_URL; OIDC login disabled")
+		return nil
+	}
+	if cfg.scopes == "" {
+		cfg.scopes = "openid profile email"
+	}
+	if cfg.postLoginURL == "" {
+		cfg.postLoginURL = "/"
+	}
+	return cfg
+}
+
+// oidcDiscovery is the part of the provider's discovery document we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider talks to the identity provider. The discovery document is
+// fetched on first use and kept; the provider's keys are cached by kid.
+type oidcProvider struct {
+	config     *oidcConfig
+	httpClient *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      *keySet
+}
+
+func newOIDCProvider(config *oidcConfig) *oidcProvider {
+	return &oidcProvider{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, *keySet, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, p.keys, nil
+	}
+
+	var doc oidcDiscovery
+	if err := p.getJSON(ctx, p.config.issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.config.issuer {
+		return nil, nil, fmt.Errorf("discovery document is for issuer %q, expected %q", doc.Issuer, p.config.issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+
+	p.discovery = &doc
+	p.keys = newKeySet(func(ctx context.Context) ([]*users.JsonWebKey, error) {
+		var jwks users.GetJWKSResponse
+		if err := p.getJSON(ctx, doc.JWKSURI, &jwks); err != nil {
+			return nil, err
+		}
+		return jwks.Keys, nil
+	})
+	return p.discovery, p.keys, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// exchangeCode redeems the authorization code with the PKCE verifier and
+// returns the ID token.
+func (p *oidcProvider) exchangeCode(ctx context.Context, tokenEndpoint, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.redirectURL)
+	form.Set("client_id", p.config.clientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.clientID), url.QueryEscape(p.config.clientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s %s %s", resp.Status, result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return result.IDToken, nil
+}
+
+// verifyIDToken checks the signature against the provider's JWKS and the
+// issuer, audience, expiry and nonce.
+func (p *oidcProvider) verifyIDToken(keys *keySet, idToken, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(idToken, keys.keyfunc,
+		jwt.WithValidMethods([]string{
+			jwt.SigningMethodRS256.Alg(),
+			jwt.SigningMethodES256.Alg(),
+			jwt.SigningMethodEdDSA.Alg(),
+		}),
+		jwt.WithIssuer(p.config.issuer),
+		jwt.WithAudience(p.config.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid ID token claims")
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+	// With several audiences the token must have been issued to us
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.config.clientID {
+			return nil, fmt.Errorf("ID token authorized party %q is not this client", azp)
+		}
+	}
+	return claims, nil
+}
+
+// oidcState is kept in a cookie during the redirect round trip.
+type oidcState struct {
+	State      string `json:"state"`
+	Nonce      string `json:"nonce"`
+	Verifier   string `json:"verifier"`
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+func randomURLString() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// handleOIDCLogin sends the browser to the identity provider.
+func (g *Gateway) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if g.oidc == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	discovery, _, err := g.oidc.discover(r.Context())
+	if err != nil {
+		log.Printf("OIDC provider unavailable: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state := oidcState{
+		State:      randomURLString(),
+		Nonce:      randomURLString(),
+		Verifier:   randomURLString(),
+		DeviceName: r.URL.Query().Get("device_name"),
+	}
+	data, _ := json.Marshal(state)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(g.oidc.config.redirectURL, "https://"),
+		// Lax so the cookie comes back on the provider's top-level redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", g.oidc.config.clientID)
+	query.Set("redirect_uri", g.oidc.config.redirectURL)
+	query.Set("scope", g.oidc.config.scopes)
+	query.Set("state", state.State)
+	query.Set("nonce", state.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, discovery.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// handleOIDCCallback finishes the login: it redeems the code, verifies the ID
+// token, signs the user in through users-service and hands the kubechat
+// tokens to the web app in the URL fragment.
+func (g *Gateway) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if g.oidc == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Login expired; please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", MaxAge: -1})
+
+	var state oidcState
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || json.Unmarshal(data, &state) != nil {
+		http.Error(w, "Login expired; please try again", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("OIDC provider refused login: %s %s", providerErr, query.Get("error_description"))
+		http.Error(w, "Sign-in was not completed", http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	discovery, keys, err := g.oidc.discover(ctx)
+	if err != nil {
+		log.Printf("OIDC provider unavailable: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	idToken, err := g.oidc.exchangeCode(ctx, discovery.TokenEndpoint, query.Get("code"), state.Verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		http.Error(w, "Sign-in failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := g.oidc.verifyIDToken(keys, idToken, state.Nonce)
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	preferredUsername, _ := claims["preferred_username"].(string)
+	resp, err := g.usersClient.LoginOIDC(ctx, &users.LoginOIDCRequest{
+		Issuer:            g.oidc.config.issuer,
+		Subject:           subject,
+		Email:             email,
+		EmailVerified:     claimTrue(claims["email_verified"]),
+		PreferredUsername: preferredUsername,
+		DeviceName:        state.DeviceName,
+		UserAgent:         r.UserAgent(),
+		IpAddress:         g.clientIP(r),
+	})
+	if err != nil {
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	if !resp.Success {
+		http.Error(w, resp.Message, http.StatusUnauthorized)
+		return
+	}
+
+	// The fragment never reaches a server, so the tokens stay out of logs
+	fragment := url.Values{}
+	fragment.Set("user_id", resp.UserId)
+	fragment.Set("token", resp.Token)
+	fragment.Set("refresh_token", resp.RefreshToken)
+	fragment.Set("expires_in", strconv.FormatInt(resp.ExpiresIn, 10))
+	http.Redirect(w, r, g.oidc.config.postLoginURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// claimTrue reads a boolean claim; some providers send "true" as a string.
+func claimTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	users "kubechat/proto/users"
+)
+
+// handleChangePassword changes the caller's password and signs out every
+// session but the one making the request.
+func (g *Gateway) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var changeReq users.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&changeReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	changeReq.UserId = r.Context().Value("user_id").(string)
+	changeReq.SessionId, _ = r.Context().Value("session_id").(string)
+
+	resp, err := g.usersClient.ChangePassword(r.Context(), &changeReq)
+	if err != nil {
+		http.Error(w, "Password change failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleRequestPasswordReset sends a reset link. The answer does not say
+// whether the account exists.
+func (g *Gateway) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resetReq users.RequestPasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.usersClient.RequestPasswordReset(r.Context(), &resetReq)
+	if err != nil {
+		http.Error(w, "Password reset failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleResetPassword sets a new password with the token from a reset link.
+func (g *Gateway) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resetReq users.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.usersClient.ResetPassword(r.Context(), &resetReq)
+	if err != nil {
+		http.Error(w, "Password reset failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
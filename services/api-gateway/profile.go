@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+
+	users "kubechat/proto/users"
+)
+
+// handleProfile serves the caller's own profile at /user/me: GET reads it and
+// PATCH changes the fields present in the body.
+func (g *Gateway) handleProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		resp, err := g.usersClient.GetUser(r.Context(), &users.GetUserRequest{
+			UserId:      userID,
+			RequesterId: userID,
+		})
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPatch:
+		var updateReq users.UpdateProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		updateReq.UserId = userID
+
+		resp, err := g.usersClient.UpdateProfile(r.Context(), &updateReq)
+		if err != nil {
+			http.Error(w, "Profile update failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !resp.Success {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// subscribeToProfiles forwards profile changes to every connected client as
+// profile_updated frames so names and avatars refresh without reloading.
+func (g *Gateway) subscribeToProfiles() {
+	_, err := g.natsConn.Subscribe("users.profile", func(msg *nats.Msg) {
+		var event users.ProfileUpdatedEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Printf("Failed to unmarshal profile event: %v", err)
+			return
+		}
+
+		data, _ := json.Marshal(Message{
+			Type:    "profile_updated",
+			Content: &event,
+		})
+		for _, client := range g.connectedClients() {
+			g.enqueue(client, data, false)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to profile updates: %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	registryBucket = "gateway_registry"
+
+	instanceHeartbeatInterval = 10 * time.Second
+	// An instance that has not refreshed its heartbeat for this long is
+	// considered dead and its connections are swept
+	instanceStaleAfter = 3 * instanceHeartbeatInterval
+)
+
+// connectionEntry is the registry value stored under conn.<user>.<device>.
+type connectionEntry struct {
+	InstanceID  string    `json:"instance_id"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Registry is a cluster-wide view of WebSocket connections, kept in a NATS
+// KV bucket shared by every gateway replica. Presence transitions are
+// decided from it so that a user connected to any replica stays online; the
+// count.<user> key holds how many connections the user has, so that exactly
+// one instance sees them come online or go offline.
+type Registry struct {
+	kv         jetstream.KeyValue
+	instanceID string
+	startedAt  time.Time // connections this process registered are no older
+}
+
+func newRegistry(ctx context.Context, js jetstream.JetStream, instanceID string) (*Registry, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      registryBucket,
+		Description: "Gateway instance heartbeats and the connections they hold",
+		History:     1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{kv: kv, instanceID: instanceID, startedAt: time.Now()}, nil
+}
+
+func connectionKey(userID, deviceID string) string {
+	return "conn." + userID + "." + deviceID
+}
+
+func instanceKey(instanceID string) string {
+	return "instance." + instanceID
+}
+
+func countKey(userID string) string {
+	return "count." + userID
+}
+
+// revisionConflict reports whether a revision-checked write lost a race.
+func revisionConflict(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
+
+// Register records that this instance holds the user's device connection.
+// It returns the entry revision, needed to unregister, and whether the user
+// had no other connection anywhere in the cluster.
+func (r *Registry) Register(ctx context.Context, userID, deviceID string) (uint64, bool, error) {
+	key := connectionKey(userID, deviceID)
+	value, _ := json.Marshal(connectionEntry{
+		InstanceID:  r.instanceID,
+		ConnectedAt: time.Now(),
+	})
+
+	// A device that reconnects takes over its existing entry, which is
+	// already counted
+	for {
+		revision, err := r.kv.Create(ctx, key, value)
+		if err == nil {
+			count, err := r.adjustCount(ctx, userID, 1)
+			if err != nil {
+				return 0, false, err
+			}
+			return revision, count == 1, nil
+		}
+		if !revisionConflict(err) {
+			return 0, false, err
+		}
+
+		entry, err := r.kv.Get(ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		revision, err = r.kv.Update(ctx, key, value, entry.Revision())
+		if err == nil {
+			return revision, false, nil
+		}
+		if !revisionConflict(err) {
+			return 0, false, err
+		}
+	}
+}
+
+// Unregister removes the connection unless the same device has since
+// connected elsewhere. It reports whether the user has no connections left.
+func (r *Registry) Unregister(ctx context.Context, userID, deviceID string, revision uint64) (bool, error) {
+	err := r.kv.Delete(ctx, connectionKey(userID, deviceID), jetstream.LastRevision(revision))
+	if err != nil {
+		if revisionConflict(err) {
+			// The device reconnected to another instance, which now owns the entry
+			return false, nil
+		}
+		return false, err
+	}
+
+	count, err := r.adjustCount(ctx, userID, -1)
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// adjustCount changes the number of connections the user holds across the
+// cluster and returns the new number. Every change is checked against the
+// revision it read, so concurrent connects and disconnects on different
+// instances cannot both see the user as first or last.
+func (r *Registry) adjustCount(ctx context.Context, userID string, delta int) (int, error) {
+	key := countKey(userID)
+	for {
+		var count int
+		var revision uint64
+		entry, err := r.kv.Get(ctx, key)
+		switch {
+		case errors.Is(err, jetstream.ErrKeyNotFound):
+		case err != nil:
+			return 0, err
+		default:
+			count, _ = strconv.Atoi(string(entry.Value()))
+			revision = entry.Revision()
+		}
+
+		count = max(count+delta, 0)
+		value := []byte(strconv.Itoa(count))
+		if revision == 0 {
+			_, err = r.kv.Create(ctx, key, value)
+		} else {
+			_, err = r.kv.Update(ctx, key, value, revision)
+		}
+		if err == nil {
+			return count, nil
+		}
+		if !revisionConflict(err) {
+			return 0, err
+		}
+	}
+}
+
+// Run refreshes this instance's heartbeat and sweeps connections held by dead
+// instances until ctx is cancelled. onOffline is called for every user left
+// without connections by a sweep.
+func (r *Registry) Run(ctx context.Context, onOffline func(userID string)) {
+	ticker := time.NewTicker(instanceHeartbeatInterval)
+	defer ticker.Stop()
+
+	sweeps := 0
+	for {
+		if _, err := r.kv.Put(ctx, instanceKey(r.instanceID), []byte(time.Now().Format(time.RFC3339))); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh gateway heartbeat: %v", err)
+		}
+
+		// Sweeping is more expensive than the heartbeat, so do it less often
+		if sweeps%3 == 0 {
+			for _, userID := range r.sweep(ctx) {
+				onOffline(userID)
+			}
+		}
+		sweeps++
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Registry) sweep(ctx context.Context) []string {
+	lister, err := r.kv.ListKeysFiltered(ctx, "conn.>")
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to list gateway connections: %v", err)
+		}
+		return nil
+	}
+	var keys []string
+	for key := range lister.Keys() {
+		keys = append(keys, key)
+	}
+
+	alive := map[string]bool{r.instanceID: true}
+	var offline []string
+	for _, key := range keys {
+		entry, err := r.kv.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+		var conn connectionEntry
+		if err := json.Unmarshal(entry.Value(), &conn); err != nil {
+			continue
+		}
+
+		isAlive, checked := alive[conn.InstanceID]
+		if !checked {
+			isAlive = r.instanceAlive(ctx, conn.InstanceID)
+			alive[conn.InstanceID] = isAlive
+		}
+		// Entries under this instance's ID from before it started were left by
+		// a previous run with the same ID, and nobody else will sweep them
+		if conn.InstanceID == r.instanceID && conn.ConnectedAt.Before(r.startedAt) {
+			isAlive = false
+		}
+		if isAlive {
+			continue
+		}
+
+		if err := r.kv.Delete(ctx, key, jetstream.LastRevision(entry.Revision())); err != nil {
+			continue
+		}
+		userID := strings.SplitN(key, ".", 3)[1]
+		count, err := r.adjustCount(ctx, userID, -1)
+		if err != nil {
+			log.Printf("Failed to update connection count for user %s: %v", userID, err)
+			continue
+		}
+		if count == 0 {
+			offline = append(offline, userID)
+		}
+	}
+	return offline
+}
+
+func (r *Registry) instanceAlive(ctx context.Context, instanceID string) bool {
+	entry, err := r.kv.Get(ctx, instanceKey(instanceID))
+	if err != nil {
+		return false
+	}
+	return time.Since(entry.Created()) < instanceStaleAfter
+}
+
+// Leave removes this instance's heartbeat so that other instances sweep the
+// connections it still holds on their next pass.
+func (r *Registry) Leave(ctx context.Context) {
+	if err := r.kv.Delete(ctx, instanceKey(r.instanceID)); err != nil {
+		log.Printf("Failed to remove gateway heartbeat: %v", err)
+	}
+}
+
+func evictSubject(userID, deviceID string) string {
+	return "gateway.evict." + userID + "." + deviceID
+}
+
+// connectDevice records the connection in the cluster registry and reports
+// whether the user just came online. Without a registry the local view is
+// all there is.
+func (g *Gateway) connectDevice(client *Client, localFirst bool) bool {
+	if g.registry == nil {
+		return localFirst
+	}
+
+	revision, first, err := g.registry.Register(context.Background(), client.UserID, client.DeviceID)
+	if err != nil {
+		log.Printf("Failed to register connection for user %s: %v", client.UserID, err)
+		return localFirst
+	}
+	client.registryRevision = revision
+
+	// The same device may still hold a half-open connection on another instance
+	g.natsConn.Publish(evictSubject(client.UserID, client.DeviceID), []byte(g.instanceID))
+	return first
+}
+
+// disconnectDevice removes the connection from the cluster registry and
+// reports whether the user has no connections left on any instance.
+func (g *Gateway) disconnectDevice(client *Client, localLast bool) bool {
+	if g.registry == nil || client.registryRevision == 0 {
+		return localLast
+	}
+
+	last, err := g.registry.Unregister(context.Background(), client.UserID, client.DeviceID, client.registryRevision)
+	if err != nil {
+		log.Printf("Failed to unregister connection for user %s: %v", client.UserID, err)
+		return localLast
+	}
+	return last
+}
+
+// subscribeToEvictions closes local connections for devices that have
+// reconnected to another instance.
+func (g *Gateway) subscribeToEvictions() {
+	_, err := g.natsConn.Subscribe("gateway.evict.*.*", func(m *nats.Msg) {
+		if string(m.Data) == g.instanceID {
+			return
+		}
+		parts := strings.Split(m.Subject, ".")
+
+		g.clientsMutex.RLock()
+		client := g.clients[parts[2]][parts[3]]
+		g.clientsMutex.RUnlock()
+
+		if client != nil {
+			log.Printf("Device %s of user %s reconnected elsewhere, closing", client.DeviceID, client.UserID)
+			go g.closeClient(client)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to evictions: %v", err)
+	}
+}
+
+// drain asks every client to reconnect, which the load balancer routes to
+// another instance, and closes whatever is left once timeout expires. The
+// registry entries are left for the other instances to sweep so that users
+// who reconnect promptly never appear offline.
+func (g *Gateway) drain(timeout time.Duration) {
+	g.draining.Store(true)
+
+	clients := g.connectedClients()
+	log.Printf("Draining %d WebSocket connections", len(clients))
+
+	// Spread reconnects over the first half of the window to avoid a stampede
+	spread := int(timeout / 2 / time.Millisecond)
+	for _, client := range clients {
+		g.sendToClient(client, Message{
+			Type:    "reconnect",
+			Content: map[string]interface{}{"retry_after_ms": rand.Intn(spread + 1)},
+		})
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && len(g.connectedClients()) > 0 {
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	for _, client := range g.connectedClients() {
+		client.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(time.Second))
+		g.closeClient(client)
+	}
+}
+
+func (g *Gateway) connectedClients() []*Client {
+	g.clientsMutex.RLock()
+	defer g.clientsMutex.RUnlock()
+
+	var clients []*Client
+	for _, devices := range g.clients {
+		for _, client := range devices {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+func (g *Gateway) handleReady(w http.ResponseWriter, r *http.Request) {
+	if g.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// instanceID names this gateway replica in the registry; pods default to
+// their hostname, which is the pod name.
+func instanceID() string {
+	if id := os.Getenv("GATEWAY_INSTANCE_ID"); id != "" {
+		return id
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	b := make([]byte, 8)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
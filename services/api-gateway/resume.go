@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	chat "kubechat/proto/chat"
+)
+
+// resumePageSize is how many missed messages are fetched per round trip.
+const resumePageSize = 100
+
+// resumeCursor identifies the last message a reconnecting client received.
+type resumeCursor struct {
+	lastMessageID string
+	lastTimestamp *timestamppb.Timestamp
+}
+
+// resumeResult is the content of the resume_complete frame.
+type resumeResult struct {
+	Replayed  int    `json:"replayed"`
+	Truncated bool   `json:"truncated"` // Fetch history to fill the gap
+	Error     string `json:"error,omitempty"`
+}
+
+// parseResumeCursor reads last_message_id and last_timestamp (RFC 3339) from
+// the connect URL. It returns nil when the client is not resuming.
+func parseResumeCursor(query url.Values) (*resumeCursor, error) {
+	cursor := &resumeCursor{lastMessageID: query.Get("last_message_id")}
+	if v := query.Get("last_timestamp"); v != "" {
+		ts, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid last_timestamp")
+		}
+		cursor.lastTimestamp = timestamppb.New(ts)
+	}
+	if cursor.lastMessageID == "" && cursor.lastTimestamp == nil {
+		return nil, nil
+	}
+	return cursor, nil
+}
+
+// resumeSession replays what the client missed since the cursor: new
+// messages first, then edits, deletions and read receipts for messages it
+// already had. Live frames are held until the resume_complete marker is sent.
+// New messages are left to the device's consumer when it already has them,
+// since replaying them too would deliver each twice.
+func (g *Gateway) resumeSession(client *Client, cursor *resumeCursor) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var result resumeResult
+	defer func() {
+		g.finishResume(client, result)
+	}()
+
+	maxMessages := g.connConfig.resumeMaxMessages
+	lastID, lastTimestamp := cursor.lastMessageID, cursor.lastTimestamp
+	for !client.consumerBacklog && result.Replayed < maxMessages {
+		limit := min(resumePageSize, maxMessages-result.Replayed)
+		resp, err := g.chatClient.GetMessagesSince(ctx, &chat.GetMessagesSinceRequest{
+			UserId:        client.UserID,
+			LastMessageId: lastID,
+			LastTimestamp: lastTimestamp,
+			Limit:         int32(limit),
+		})
+		if err != nil {
+			log.Printf("Failed to replay messages for user %s: %v", client.UserID, err)
+			result.Error = "Failed to replay missed messages"
+			return
+		}
+
+		for _, message := range resp.Messages {
+			// The client never saw these, so they are new even if since edited
+			if !g.sendReplay(client, Message{Type: "new_message", Content: message}) {
+				return
+			}
+			result.Replayed++
+			lastID, lastTimestamp = message.MessageId, message.Timestamp
+		}
+		if len(resp.Messages) < limit {
+			break
+		}
+		if result.Replayed >= maxMessages {
+			result.Truncated = true
+		}
+	}
+
+	changes, err := g.chatClient.GetChangesSince(ctx, &chat.GetChangesSinceRequest{
+		UserId:        client.UserID,
+		LastMessageId: cursor.lastMessageID,
+		LastTimestamp: cursor.lastTimestamp,
+		Limit:         int32(maxMessages),
+	})
+	if err != nil {
+		log.Printf("Failed to replay changes for user %s: %v", client.UserID, err)
+		result.Error = "Failed to replay missed changes"
+		return
+	}
+	result.Truncated = result.Truncated || changes.Truncated
+
+	for _, message := range changes.Messages {
+		if !g.sendReplay(client, messageFrame(client, message)) {
+			return
+		}
+	}
+	for _, receipt := range changes.ReadReceipts {
+		if client.relations.blocks(receipt.SenderId) {
+			continue
+		}
+		if !g.sendReplay(client, Message{Type: "read_receipt", Content: receipt}) {
+			return
+		}
+	}
+}
+
+// sendReplay queues a replayed frame, waiting for room rather than applying
+// the overflow policy since the replay is bounded.
+func (g *Gateway) sendReplay(client *Client, frame Message) bool {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Failed to marshal %s frame: %v", frame.Type, err)
+		return true
+	}
+
+	select {
+	case client.Send <- data:
+		wsMessages.Inc()
+		return true
+	case <-client.done:
+		return false
+	}
+}
+
+// holdForResume buffers a live frame while the client is resuming. The
+// buffer is as large as the send queue and overflows the same way. It reports
+// whether the client was resuming and, if so, whether the frame was kept.
+func (g *Gateway) holdForResume(client *Client, data []byte, durable bool) (resuming, held bool) {
+	client.resumeMutex.Lock()
+	defer client.resumeMutex.Unlock()
+
+	if !client.resuming {
+		return false, false
+	}
+	if len(client.held) < g.connConfig.sendQueueSize {
+		client.held = append(client.held, data)
+		return true, true
+	}
+
+	policy := g.connConfig.overflowPolicy
+	g.markSlow(client, policy)
+	switch policy {
+	case overflowDisconnect:
+		droppedFrames.WithLabelValues(policy).Inc()
+		go g.closeClient(client)
+		return true, false
+
+	case overflowSpill:
+		if durable {
+			spilledFrames.Inc()
+		} else {
+			droppedFrames.WithLabelValues(policy).Inc()
+		}
+		return true, false
+
+	default:
+		client.held = append(client.held[1:], data)
+		droppedFrames.WithLabelValues(policy).Inc()
+		return true, true
+	}
+}
+
+// finishResume sends the resume_complete marker followed by the live frames
+// held during the replay, then switches the client to live delivery.
+func (g *Gateway) finishResume(client *Client, result resumeResult) {
+	client.resumeMutex.Lock()
+	defer client.resumeMutex.Unlock()
+
+	held := client.held
+	client.held = nil
+	client.resuming = false
+
+	if !g.sendReplay(client, Message{Type: "resume_complete", Content: result}) {
+		return
+	}
+	// Live producers wait on resumeMutex meanwhile, so ordering is kept
+	for _, data := range held {
+		select {
+		case client.Send <- data:
+			wsMessages.Inc()
+		case <-client.done:
+			return
+		}
+	}
+}
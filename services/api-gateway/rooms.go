@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	chat "kubechat/proto/chat"
+)
+
+// handleRoomMessage processes send_room_message and room_* frames. It reports
+// whether the frame type was a room frame.
+func (g *Gateway) handleRoomMessage(client *Client, msg *Message) bool {
+	content, _ := msg.Content.(map[string]interface{})
+	roomID, _ := content["room_id"].(string)
+	ctx := context.Background()
+
+	switch msg.Type {
+	case "send_room_message":
+		messageText, _ := content["message"].(string)
+		if roomID == "" || messageText == "" {
+			g.sendError(client, "room_id and message required")
+			return true
+		}
+		resp, err := g.chatClient.SendMessage(ctx, &chat.SendMessageRequest{
+			SenderId: client.UserID,
+			RoomId:   roomID,
+			Message:  messageText,
+		})
+		if err != nil {
+			log.Printf("Failed to send room message: %v", err)
+			g.sendError(client, "Failed to send message")
+		} else if !resp.Success {
+			g.sendError(client, resp.Error)
+		}
+
+	case "room_create":
+		name, _ := content["name"].(string)
+		resp, err := g.chatClient.CreateRoom(ctx, &chat.CreateRoomRequest{
+			OwnerId:   client.UserID,
+			Name:      name,
+			MemberIds: stringSlice(content["member_ids"]),
+		})
+		g.checkRoomResponse(client, resp, err)
+
+	case "room_rename":
+		name, _ := content["name"].(string)
+		resp, err := g.chatClient.RenameRoom(ctx, &chat.RenameRoomRequest{
+			RoomId: roomID,
+			UserId: client.UserID,
+			Name:   name,
+		})
+		g.checkRoomResponse(client, resp, err)
+
+	case "room_delete":
+		resp, err := g.chatClient.DeleteRoom(ctx, &chat.DeleteRoomRequest{
+			RoomId: roomID,
+			UserId: client.UserID,
+		})
+		if err != nil {
+			log.Printf("Failed to delete room: %v", err)
+			g.sendError(client, "Failed to delete room")
+		} else if !resp.Success {
+			g.sendError(client, resp.Error)
+		}
+
+	case "room_add_member":
+		memberID, _ := content["member_id"].(string)
+		resp, err := g.chatClient.AddRoomMember(ctx, &chat.RoomMemberRequest{
+			RoomId:   roomID,
+			UserId:   client.UserID,
+			MemberId: memberID,
+		})
+		g.checkRoomResponse(client, resp, err)
+
+	case "room_remove_member":
+		// Omitting member_id means leaving the room
+		memberID, _ := content["member_id"].(string)
+		if memberID == "" {
+			memberID = client.UserID
+		}
+		resp, err := g.chatClient.RemoveRoomMember(ctx, &chat.RoomMemberRequest{
+			RoomId:   roomID,
+			UserId:   client.UserID,
+			MemberId: memberID,
+		})
+		g.checkRoomResponse(client, resp, err)
+
+	case "room_list":
+		resp, err := g.chatClient.ListRooms(ctx, &chat.ListRoomsRequest{UserId: client.UserID})
+		if err != nil {
+			log.Printf("Failed to list rooms: %v", err)
+			g.sendError(client, "Failed to list rooms")
+			return true
+		}
+		g.sendToClient(client, Message{
+			Type:    "room_list",
+			Content: resp.Rooms,
+		})
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// checkRoomResponse reports failures back to the caller. Successful changes
+// reach every member, including the caller, as room events over NATS.
+func (g *Gateway) checkRoomResponse(client *Client, resp *chat.RoomResponse, err error) {
+	if err != nil {
+		log.Printf("Room request failed: %v", err)
+		g.sendError(client, "Room request failed")
+		return
+	}
+	if !resp.Success {
+		g.sendError(client, resp.Error)
+	}
+}
+
+func stringSlice(value interface{}) []string {
+	items, _ := value.([]interface{})
+	var result []string
+	for _, item := range items {
+		if s, ok := item.(string); ok && s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
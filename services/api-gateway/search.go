@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	chat "kubechat/proto/chat"
+)
+
+// parseSearchTime reads an RFC 3339 time or a date. A date as the upper bound
+// includes that whole day.
+func parseSearchTime(value string, upper bool) (*timestamppb.Timestamp, bool) {
+	if value == "" {
+		return nil, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamppb.New(t), true
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, false
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return timestamppb.New(t), true
+}
+
+// handleSearchMessages finds messages in the caller's conversations:
+// GET /chat/search?q=link&sender=ID&room_id=ID&with=ID&after=2026-09-01
+// &before=2026-09-30&has_attachment=true&limit=20. Results are newest first;
+// pass next_cursor back as cursor for the next page.
+func (g *Gateway) handleSearchMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	searchReq := &chat.SearchMessagesRequest{
+		UserId:     r.Context().Value("user_id").(string),
+		Query:      query.Get("q"),
+		SenderId:   query.Get("sender"),
+		RoomId:     query.Get("room_id"),
+		WithUserId: query.Get("with"),
+		Cursor:     query.Get("cursor"),
+	}
+	searchReq.HasAttachment, _ = strconv.ParseBool(query.Get("has_attachment"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	searchReq.Limit = int32(limit)
+
+	var ok bool
+	if searchReq.After, ok = parseSearchTime(query.Get("after"), false); !ok {
+		http.Error(w, "after must be a date or RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+	if searchReq.Before, ok = parseSearchTime(query.Get("before"), true); !ok {
+		http.Error(w, "before must be a date or RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+
+	// Without any criteria this would be the whole history
+	if searchReq.Query == "" && searchReq.SenderId == "" && searchReq.RoomId == "" && searchReq.WithUserId == "" &&
+		searchReq.After == nil && searchReq.Before == nil && !searchReq.HasAttachment {
+		http.Error(w, "q or a filter required", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.chatClient.SearchMessages(r.Context(), searchReq)
+	if err != nil {
+		log.Printf("Failed to search messages: %v", err)
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
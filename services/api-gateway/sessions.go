@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	users "kubechat/proto/users"
+)
+
+// loadTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of
+// addresses or CIDR ranges of the proxies in front of the gateway.
+func loadTrustedProxies() []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+func (g *Gateway) trustedProxy(ip net.IP) bool {
+	for _, network := range g.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address a request came from. Login throttling is keyed on
+// it, so X-Forwarded-For, which the client can set, is only believed when the
+// connection comes from a trusted proxy. Then the hops are read from the
+// right, the end the proxies append to, and the first untrusted one wins.
+func (g *Gateway) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !g.trustedProxy(remote) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		client = hop
+		if !g.trustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// handleSessions lists the caller's sessions on GET /sessions and revokes one
+// on DELETE /sessions/{id}.
+func (g *Gateway) handleSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	sessionID, _ := r.Context().Value("session_id").(string)
+	targetID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && targetID == "":
+		resp, err := g.usersClient.ListSessions(r.Context(), &users.ListSessionsRequest{
+			UserId:           userID,
+			CurrentSessionId: sessionID,
+		})
+		if err != nil {
+			log.Printf("Failed to list sessions: %v", err)
+			http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case r.Method == http.MethodDelete && targetID != "":
+		resp, err := g.usersClient.RevokeSession(r.Context(), &users.RevokeSessionRequest{
+			UserId:    userID,
+			SessionId: targetID,
+		})
+		if err != nil {
+			log.Printf("Failed to revoke session: %v", err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+		if !resp.Success {
+			http.Error(w, resp.Message, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	users "kubechat/proto/users"
+)
+
+// handleLoginTOTP completes a login that returned two_factor_required.
+func (g *Gateway) handleLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var verifyReq users.VerifyLoginTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&verifyReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.usersClient.VerifyLoginTOTP(r.Context(), &verifyReq)
+	if err != nil {
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+
+	writeLoginResponse(w, resp)
+}
+
+// writeLoginResponse answers a login step, with 429 and Retry-After when
+// users-service is throttling the account or address, and 403 when the email
+// address must be verified first.
+func writeLoginResponse(w http.ResponseWriter, resp *users.LoginUserResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if resp.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(resp.RetryAfter, 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+	} else if resp.EmailVerificationRequired {
+		w.WriteHeader(http.StatusForbidden)
+	} else if !resp.Success && resp.ChallengeToken == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleTOTPEnroll starts two-factor enrollment for the caller.
+func (g *Gateway) handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := g.usersClient.EnrollTOTP(r.Context(), &users.EnrollTOTPRequest{
+		UserId: r.Context().Value("user_id").(string),
+	})
+	if err != nil {
+		http.Error(w, "Enrollment failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleTOTPConfirm enables two-factor authentication with a first code and
+// returns the recovery codes.
+func (g *Gateway) handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var confirmReq users.ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&confirmReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	confirmReq.UserId = r.Context().Value("user_id").(string)
+
+	resp, err := g.usersClient.ConfirmTOTP(r.Context(), &confirmReq)
+	if err != nil {
+		http.Error(w, "Confirmation failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleTOTPDisable turns two-factor authentication off; it needs the
+// password and a code.
+func (g *Gateway) handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var disableReq users.DisableTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&disableReq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	disableReq.UserId = r.Context().Value("user_id").(string)
+
+	resp, err := g.usersClient.DisableTOTP(r.Context(), &disableReq)
+	if err != nil {
+		http.Error(w, "Disable failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
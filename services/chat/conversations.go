@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	chat "kubechat/proto/chat"
+	messagestore "kubechat/proto/messagestore"
+)
+
+// ListConversations returns the user's inbox, most recently active first.
+func (s *server) ListConversations(ctx context.Context, req *chat.ListConversationsRequest) (*chat.ListConversationsResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if s.messageStoreConn == nil {
+		return &chat.ListConversationsResponse{
+			Conversations: []*chat.Conversation{},
+		}, nil
+	}
+
+	resp, err := s.messageStoreConn.ListConversations(ctx, &messagestore.ListConversationsRequest{
+		UserId: req.UserId,
+		Cursor: req.Cursor,
+		Limit:  req.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]*chat.Conversation, 0, len(resp.Conversations))
+	for _, conv := range resp.Conversations {
+		conversations = append(conversations, toChatConversation(conv))
+	}
+
+	return &chat.ListConversationsResponse{
+		Conversations: conversations,
+		NextCursor:    resp.NextCursor,
+	}, nil
+}
+
+// publishConversationUpdates sends each user the new state of the
+// conversation message belongs to as a conversation event. It runs after the
+// request has returned, so it uses its own deadline.
+func (s *server) publishConversationUpdates(userIDs []string, message *chat.Message) {
+	if s.messageStoreConn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, userID := range userIDs {
+		conversationID := message.RoomId
+		if conversationID == "" {
+			conversationID = message.SenderId
+			if message.SenderId == userID {
+				conversationID = message.RecipientId
+			}
+		}
+
+		resp, err := s.messageStoreConn.ListConversations(ctx, &messagestore.ListConversationsRequest{
+			UserId:         userID,
+			ConversationId: conversationID,
+			Limit:          1,
+		})
+		if err != nil {
+			log.Printf("Failed to load conversation %s for %s: %v", conversationID, userID, err)
+			continue
+		}
+		if len(resp.Conversations) == 0 {
+			continue
+		}
+
+		data, err := json.Marshal(&chat.ConversationEvent{Conversation: toChatConversation(resp.Conversations[0])})
+		if err != nil {
+			log.Printf("Failed to marshal conversation event: %v", err)
+			continue
+		}
+		if err := s.natsConn.Publish("chat.events."+userID, data); err != nil {
+			log.Printf("Failed to publish conversation event to %s: %v", userID, err)
+		}
+	}
+}
+
+func toChatConversation(conv *messagestore.Conversation) *chat.Conversation {
+	result := &chat.Conversation{
+		ConversationId: conv.ConversationId,
+		RoomId:         conv.RoomId,
+		UserId:         conv.UserId,
+		UnreadCount:    conv.UnreadCount,
+		LastActivity:   conv.LastActivity,
+	}
+	if conv.LastMessage != nil {
+		result.LastMessage = toChatMessage(conv.LastMessage)
+	}
+	return result
+}
@@ -5,13 +5,17 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
@@ -20,12 +24,16 @@ import (
 
 	chat "kubechat/proto/chat"
 	messagestore "kubechat/proto/messagestore"
+	users "kubechat/proto/users"
 )
 
 type server struct {
 	chat.UnimplementedChatServiceServer
 	natsConn         *nats.Conn
+	js               jetstream.JetStream // nil when JetStream is unavailable
 	messageStoreConn messagestore.MessageStoreServiceClient
+	usersClient      users.UsersServiceClient
+	contactsOnly     bool // Direct messages only between contacts
 }
 
 var (
@@ -41,7 +49,29 @@ func (s *server) SendMessage(ctx context.Context, req *chat.SendMessageRequest)
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	messageID := generateMessageID()
+	// Room messages fan out to every member; direct messages go to the
+	// recipient and back to the sender so they can see their own message
+	var recipients []string
+	if req.RoomId != "" {
+		room, err := s.loadRoomForMember(ctx, req.RoomId, req.SenderId)
+		if err != nil {
+			return &chat.SendMessageResponse{
+				Success: false,
+				Error:   "Room not found or not a member",
+			}, nil
+		}
+		recipients = s.roomRecipients(ctx, req.SenderId, room.MemberIds)
+	} else {
+		if refusal := s.directMessageRefusal(ctx, req.SenderId, req.RecipientId); refusal != "" {
+			return &chat.SendMessageResponse{
+				Success: false,
+				Error:   refusal,
+			}, nil
+		}
+		recipients = []string{req.RecipientId, req.SenderId}
+	}
+
+	messageID := generateID()
 	now := time.Now()
 
 	message := &chat.Message{
@@ -50,6 +80,26 @@ func (s *server) SendMessage(ctx context.Context, req *chat.SendMessageRequest)
 		RecipientId: req.RecipientId,
 		Content:     req.Message,
 		Timestamp:   timestamppb.New(now),
+		RoomId:      req.RoomId,
+	}
+
+	// Persist before delivering, so that every message a participant sees is
+	// also in history, resume, search and the inbox
+	if s.messageStoreConn != nil {
+		if err := s.storeMessage(ctx, &messagestore.StoreMessageRequest{
+			MessageId:   messageID,
+			SenderId:    req.SenderId,
+			RecipientId: req.RecipientId,
+			Content:     req.Message,
+			Timestamp:   timestamppb.New(now),
+			RoomId:      req.RoomId,
+		}); err != nil {
+			log.Printf("Failed to store message: %v", err)
+			return &chat.SendMessageResponse{
+				Success: false,
+				Error:   "Failed to store message",
+			}, nil
+		}
 	}
 
 	// Publish message to NATS for real-time delivery
@@ -61,40 +111,18 @@ func (s *server) SendMessage(ctx context.Context, req *chat.SendMessageRequest)
 		}, err
 	}
 
-	// Publish to recipient's channel
-	recipientSubject := "chat.messages." + req.RecipientId
 	publishStart := time.Now()
-	err = s.natsConn.Publish(recipientSubject, messageData)
-	if err != nil {
-		return &chat.SendMessageResponse{
-			Success: false,
-			Error:   "Failed to publish message to recipient",
-		}, err
+	for _, userID := range recipients {
+		// The message is already stored, so a participant who misses it live
+		// gets it when their client resumes; failing here would only invite a
+		// duplicate retry
+		if err := s.publishMessage(ctx, "chat.messages."+userID, messageData); err != nil {
+			log.Printf("Failed to publish message to %s: %v", userID, err)
+		}
 	}
 	publishLatency.Observe(time.Since(publishStart).Seconds())
 
-	// Also publish to sender's channel so they can see their own message
-	senderSubject := "chat.messages." + req.SenderId
-	err = s.natsConn.Publish(senderSubject, messageData)
-	if err != nil {
-		log.Printf("Failed to publish message to sender: %v", err)
-		// Don't return error for sender notification failure, message was delivered to recipient
-	}
-
-	// Store message in message store service
-	if s.messageStoreConn != nil {
-		storeReq := &messagestore.StoreMessageRequest{
-			MessageId:   messageID,
-			SenderId:    req.SenderId,
-			RecipientId: req.RecipientId,
-			Content:     req.Message,
-			Timestamp:   timestamppb.New(now),
-		}
-		_, err := s.messageStoreConn.StoreMessage(ctx, storeReq)
-		if err != nil {
-			log.Printf("Failed to store message: %v", err)
-		}
-	}
+	go s.publishConversationUpdates(recipients, message)
 
 	return &chat.SendMessageResponse{
 		MessageId: messageID,
@@ -102,6 +130,126 @@ func (s *server) SendMessage(ctx context.Context, req *chat.SendMessageRequest)
 	}, nil
 }
 
+// storeAttempts bounds the writes of one message. StoreMessage ignores a
+// message_id it already has, so a write that succeeded but timed out is safe
+// to repeat.
+const storeAttempts = 3
+
+func (s *server) storeMessage(ctx context.Context, req *messagestore.StoreMessageRequest) error {
+	var err error
+	for attempt := 1; attempt <= storeAttempts; attempt++ {
+		var resp *messagestore.StoreMessageResponse
+		resp, err = s.messageStoreConn.StoreMessage(ctx, req)
+		if err == nil && !resp.Success {
+			err = errors.New(resp.Error)
+		}
+		if err == nil {
+			return nil
+		}
+		if attempt == storeAttempts {
+			break
+		}
+		log.Printf("Failed to store message %s (attempt %d): %v", req.MessageId, attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+		}
+	}
+	return err
+}
+
+// relation is how one user stands towards another for messaging purposes.
+type relation int
+
+const (
+	relationAllowed    relation = iota
+	relationBlocked             // the other user blocked this one
+	relationBlocking            // this user blocked the other
+	relationNotContact          // MESSAGING_POLICY=contacts and they are not contacts
+)
+
+// checkRelation asks users-service whether userID may reach otherUserID.
+// Blocks apply either way; MESSAGING_POLICY=contacts also requires them to be
+// contacts.
+func (s *server) checkRelation(ctx context.Context, userID, otherUserID string) (relation, error) {
+	if userID == otherUserID {
+		return relationAllowed, nil
+	}
+
+	block, err := s.usersClient.CheckBlock(ctx, &users.CheckBlockRequest{
+		UserId:      userID,
+		OtherUserId: otherUserID,
+	})
+	if err != nil {
+		return relationAllowed, fmt.Errorf("check blocks between %s and %s: %w", userID, otherUserID, err)
+	}
+	if block.Blocked {
+		return relationBlocked, nil
+	}
+	if block.Blocking {
+		return relationBlocking, nil
+	}
+
+	if !s.contactsOnly {
+		return relationAllowed, nil
+	}
+	contacts, err := s.usersClient.AreContacts(ctx, &users.AreContactsRequest{
+		UserId:      userID,
+		OtherUserId: otherUserID,
+	})
+	if err != nil {
+		return relationAllowed, fmt.Errorf("check contacts %s and %s: %w", userID, otherUserID, err)
+	}
+	if !contacts.Contacts {
+		return relationNotContact, nil
+	}
+	return relationAllowed, nil
+}
+
+// directMessageRefusal returns why the sender may not message the recipient,
+// or "" if they may. If users-service cannot tell, the message is refused.
+func (s *server) directMessageRefusal(ctx context.Context, senderID, recipientID string) string {
+	rel, err := s.checkRelation(ctx, senderID, recipientID)
+	if err != nil {
+		log.Printf("Refusing message from %s to %s: %v", senderID, recipientID, err)
+		return "Failed to send message, try again"
+	}
+	switch rel {
+	case relationBlocked:
+		return "You cannot message this user"
+	case relationBlocking:
+		return "Unblock this user to message them"
+	case relationNotContact:
+		return "You can only message your contacts"
+	}
+	return ""
+}
+
+// roomRecipients leaves out the members who blocked the sender. A member
+// whose blocks cannot be checked is left out too; the message is stored, so
+// they still get it from history.
+func (s *server) roomRecipients(ctx context.Context, senderID string, memberIDs []string) []string {
+	recipients := make([]string, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		if memberID != senderID {
+			block, err := s.usersClient.CheckBlock(ctx, &users.CheckBlockRequest{
+				UserId:      senderID,
+				OtherUserId: memberID,
+			})
+			if err != nil {
+				log.Printf("Failed to check blocks between %s and %s: %v", senderID, memberID, err)
+				continue
+			}
+			if block.Blocked {
+				continue
+			}
+		}
+		recipients = append(recipients, memberID)
+	}
+	return recipients
+}
+
 func (s *server) GetMessageHistory(ctx context.Context, req *chat.GetMessageHistoryRequest) (*chat.GetMessageHistoryResponse, error) {
 	if s.messageStoreConn == nil {
 		return &chat.GetMessageHistoryResponse{
@@ -109,13 +257,22 @@ func (s *server) GetMessageHistory(ctx context.Context, req *chat.GetMessageHist
 		}, nil
 	}
 
+	// Room history is only visible to members; user_id1 is the requesting user
+	if req.RoomId != "" {
+		if _, err := s.loadRoomForMember(ctx, req.RoomId, req.UserId1); err != nil {
+			return nil, err
+		}
+	}
+
 	storeReq := &messagestore.GetMessageHistoryRequest{
-		UserId1:         req.UserId1,
-		UserId2:         req.UserId2,
-		Limit:           req.Limit,
-		Offset:          req.Offset,
-		LastMessageId:   req.LastMessageId,
-		LastTimestamp:   req.LastTimestamp,
+		UserId1:       req.UserId1,
+		UserId2:       req.UserId2,
+		Limit:         req.Limit,
+		Offset:        req.Offset,
+		LastMessageId: req.LastMessageId,
+		LastTimestamp: req.LastTimestamp,
+		RoomId:        req.RoomId,
+		ViewerId:      req.ViewerId,
 	}
 
 	resp, err := s.messageStoreConn.GetMessageHistory(ctx, storeReq)
@@ -125,13 +282,7 @@ func (s *server) GetMessageHistory(ctx context.Context, req *chat.GetMessageHist
 
 	var messages []*chat.Message
 	for _, stored := range resp.Messages {
-		messages = append(messages, &chat.Message{
-			MessageId:   stored.MessageId,
-			SenderId:    stored.SenderId,
-			RecipientId: stored.RecipientId,
-			Content:     stored.Content,
-			Timestamp:   stored.Timestamp,
-		})
+		messages = append(messages, toChatMessage(stored))
 	}
 
 	return &chat.GetMessageHistoryResponse{
@@ -139,7 +290,139 @@ func (s *server) GetMessageHistory(ctx context.Context, req *chat.GetMessageHist
 	}, nil
 }
 
-func generateMessageID() string {
+func (s *server) EditMessage(ctx context.Context, req *chat.EditMessageRequest) (*chat.EditMessageResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if s.messageStoreConn == nil {
+		return &chat.EditMessageResponse{
+			Success: false,
+			Error:   "Message store not available",
+		}, nil
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		return &chat.EditMessageResponse{
+			Success: false,
+			Error:   "Message cannot be empty",
+		}, nil
+	}
+
+	resp, err := s.messageStoreConn.EditMessage(ctx, &messagestore.EditMessageRequest{
+		MessageId: req.MessageId,
+		UserId:    req.UserId,
+		Content:   req.Message,
+	})
+	if err != nil {
+		return &chat.EditMessageResponse{
+			Success: false,
+			Error:   "Failed to edit message",
+		}, err
+	}
+	if !resp.Success {
+		return &chat.EditMessageResponse{
+			Success: false,
+			Error:   resp.Error,
+		}, nil
+	}
+
+	message := toChatMessage(resp.Message)
+	s.publishMessageUpdate(ctx, message)
+
+	return &chat.EditMessageResponse{
+		Message: message,
+		Success: true,
+	}, nil
+}
+
+func (s *server) DeleteMessage(ctx context.Context, req *chat.DeleteMessageRequest) (*chat.DeleteMessageResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if s.messageStoreConn == nil {
+		return &chat.DeleteMessageResponse{
+			Success: false,
+			Error:   "Message store not available",
+		}, nil
+	}
+
+	resp, err := s.messageStoreConn.DeleteMessage(ctx, &messagestore.DeleteMessageRequest{
+		MessageId:   req.MessageId,
+		UserId:      req.UserId,
+		ForEveryone: req.ForEveryone,
+	})
+	if err != nil {
+		return &chat.DeleteMessageResponse{
+			Success: false,
+			Error:   "Failed to delete message",
+		}, err
+	}
+	if !resp.Success {
+		return &chat.DeleteMessageResponse{
+			Success: false,
+			Error:   resp.Error,
+		}, nil
+	}
+
+	// Retract the message live: from everyone for a tombstone, otherwise only
+	// from the requesting user's own clients
+	message := toChatMessage(resp.Message)
+	message.Deleted = true
+	if req.ForEveryone {
+		s.publishMessageUpdate(ctx, message)
+	} else {
+		s.publishMessageUpdateTo(ctx, []string{req.UserId}, message)
+	}
+
+	return &chat.DeleteMessageResponse{
+		Success: true,
+	}, nil
+}
+
+// publishMessageUpdate notifies every participant of a conversation that an
+// existing message changed.
+func (s *server) publishMessageUpdate(ctx context.Context, message *chat.Message) {
+	recipients := []string{message.RecipientId, message.SenderId}
+	if message.RoomId != "" {
+		resp, err := s.messageStoreConn.GetRoom(ctx, &messagestore.GetRoomRequest{RoomId: message.RoomId})
+		if err != nil || !resp.Success {
+			log.Printf("Failed to load room %s for message update: %v", message.RoomId, err)
+			recipients = []string{message.SenderId}
+		} else {
+			recipients = resp.Room.MemberIds
+		}
+	}
+	s.publishMessageUpdateTo(ctx, recipients, message)
+}
+
+func (s *server) publishMessageUpdateTo(ctx context.Context, recipients []string, message *chat.Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal message update: %v", err)
+		return
+	}
+	for _, userID := range recipients {
+		if err := s.publishMessage(ctx, "chat.messages."+userID, data); err != nil {
+			log.Printf("Failed to publish message update to %s: %v", userID, err)
+		}
+	}
+	// The change may alter the conversation's preview or unread count
+	go s.publishConversationUpdates(recipients, message)
+}
+
+func toChatMessage(stored *messagestore.StoredMessage) *chat.Message {
+	return &chat.Message{
+		MessageId:   stored.MessageId,
+		SenderId:    stored.SenderId,
+		RecipientId: stored.RecipientId,
+		Content:     stored.Content,
+		Timestamp:   stored.Timestamp,
+		RoomId:      stored.RoomId,
+		EditedAt:    stored.EditedAt,
+		Deleted:     stored.Deleted,
+	}
+}
+
+func generateID() string {
 	bytes := make([]byte, 16)
 	rand.Read(bytes)
 	return hex.EncodeToString(bytes)
@@ -169,6 +452,17 @@ func main() {
 	}
 	defer nc.Close()
 
+	// Durable delivery through JetStream; fall back to core NATS if the
+	// server was started without JetStream
+	var js jetstream.JetStream
+	if stream, err := jetstream.New(nc); err != nil {
+		log.Printf("JetStream unavailable, using core NATS publish: %v", err)
+	} else if err := ensureMessageStream(context.Background(), stream); err != nil {
+		log.Printf("Failed to create %s stream, using core NATS publish: %v", messageStreamName, err)
+	} else {
+		js = stream
+	}
+
 	// Connect to message store service (optional)
 	var messageStoreConn messagestore.MessageStoreServiceClient
 	messageStoreURL := os.Getenv("MESSAGE_STORE_URL")
@@ -183,6 +477,27 @@ func main() {
 		defer conn.Close()
 	}
 
+	// Direct messages are checked against blocks and, with
+	// MESSAGING_POLICY=contacts, contacts, which users-service keeps
+	usersURL := os.Getenv("USERS_SERVICE_URL")
+	if usersURL == "" {
+		usersURL = "localhost:50051"
+	}
+	usersConn, err := grpc.NewClient(usersURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to users service: %v", err)
+	}
+	defer usersConn.Close()
+
+	contactsOnly := false
+	switch policy := os.Getenv("MESSAGING_POLICY"); policy {
+	case "", "anyone":
+	case "contacts":
+		contactsOnly = true
+	default:
+		log.Printf("Invalid MESSAGING_POLICY %q, using anyone", policy)
+	}
+
 	lis, err := net.Listen("tcp", ":50053")
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
@@ -191,7 +506,10 @@ func main() {
 	s := grpc.NewServer()
 	chatServer := &server{
 		natsConn:         nc,
+		js:               js,
 		messageStoreConn: messageStoreConn,
+		usersClient:      users.NewUsersServiceClient(usersConn),
+		contactsOnly:     contactsOnly,
 	}
 
 	chat.RegisterChatServiceServer(s, chatServer)
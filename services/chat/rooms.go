@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	chat "kubechat/proto/chat"
+	messagestore "kubechat/proto/messagestore"
+)
+
+func (s *server) CreateRoom(ctx context.Context, req *chat.CreateRoomRequest) (*chat.RoomResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.RoomResponse{Success: false, Error: "Message store not available"}, nil
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return &chat.RoomResponse{Success: false, Error: "Room name required"}, nil
+	}
+	for _, memberID := range req.MemberIds {
+		if refusal := s.roomMemberRefusal(ctx, req.OwnerId, memberID); refusal != "" {
+			return &chat.RoomResponse{Success: false, Error: refusal}, nil
+		}
+	}
+
+	resp, err := s.messageStoreConn.CreateRoom(ctx, &messagestore.CreateRoomRequest{
+		RoomId:    generateID(),
+		OwnerId:   req.OwnerId,
+		Name:      name,
+		MemberIds: req.MemberIds,
+	})
+	if err != nil {
+		return &chat.RoomResponse{Success: false, Error: "Failed to create room"}, err
+	}
+
+	return s.roomResult(resp, "room_created", req.OwnerId, "", nil), nil
+}
+
+func (s *server) RenameRoom(ctx context.Context, req *chat.RenameRoomRequest) (*chat.RoomResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.RoomResponse{Success: false, Error: "Message store not available"}, nil
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return &chat.RoomResponse{Success: false, Error: "Room name required"}, nil
+	}
+
+	resp, err := s.messageStoreConn.RenameRoom(ctx, &messagestore.RenameRoomRequest{
+		RoomId: req.RoomId,
+		UserId: req.UserId,
+		Name:   name,
+	})
+	if err != nil {
+		return &chat.RoomResponse{Success: false, Error: "Failed to rename room"}, err
+	}
+
+	return s.roomResult(resp, "room_renamed", req.UserId, "", nil), nil
+}
+
+func (s *server) DeleteRoom(ctx context.Context, req *chat.DeleteRoomRequest) (*chat.DeleteRoomResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.DeleteRoomResponse{Success: false, Error: "Message store not available"}, nil
+	}
+
+	// Load the room first so members can be notified after it is gone
+	roomResp, err := s.messageStoreConn.GetRoom(ctx, &messagestore.GetRoomRequest{RoomId: req.RoomId})
+	if err != nil {
+		return &chat.DeleteRoomResponse{Success: false, Error: "Failed to delete room"}, err
+	}
+	if !roomResp.Success {
+		return &chat.DeleteRoomResponse{Success: false, Error: roomResp.Error}, nil
+	}
+
+	resp, err := s.messageStoreConn.DeleteRoom(ctx, &messagestore.DeleteRoomRequest{
+		RoomId: req.RoomId,
+		UserId: req.UserId,
+	})
+	if err != nil {
+		return &chat.DeleteRoomResponse{Success: false, Error: "Failed to delete room"}, err
+	}
+	if !resp.Success {
+		return &chat.DeleteRoomResponse{Success: false, Error: resp.Error}, nil
+	}
+
+	room := toChatRoom(roomResp.Room)
+	s.publishRoomEvent(room.MemberIds, &chat.RoomEvent{
+		EventType: "room_deleted",
+		Room:      room,
+		ActorId:   req.UserId,
+	})
+
+	return &chat.DeleteRoomResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *server) AddRoomMember(ctx context.Context, req *chat.RoomMemberRequest) (*chat.RoomResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.RoomResponse{Success: false, Error: "Message store not available"}, nil
+	}
+	if refusal := s.roomMemberRefusal(ctx, req.UserId, req.MemberId); refusal != "" {
+		return &chat.RoomResponse{Success: false, Error: refusal}, nil
+	}
+
+	resp, err := s.messageStoreConn.AddRoomMember(ctx, &messagestore.RoomMemberRequest{
+		RoomId:   req.RoomId,
+		UserId:   req.UserId,
+		MemberId: req.MemberId,
+	})
+	if err != nil {
+		return &chat.RoomResponse{Success: false, Error: "Failed to add room member"}, err
+	}
+
+	return s.roomResult(resp, "room_member_added", req.UserId, req.MemberId, nil), nil
+}
+
+func (s *server) RemoveRoomMember(ctx context.Context, req *chat.RoomMemberRequest) (*chat.RoomResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.RoomResponse{Success: false, Error: "Message store not available"}, nil
+	}
+
+	resp, err := s.messageStoreConn.RemoveRoomMember(ctx, &messagestore.RoomMemberRequest{
+		RoomId:   req.RoomId,
+		UserId:   req.UserId,
+		MemberId: req.MemberId,
+	})
+	if err != nil {
+		return &chat.RoomResponse{Success: false, Error: "Failed to remove room member"}, err
+	}
+
+	// The removed member is no longer in the room but still needs to hear about it
+	return s.roomResult(resp, "room_member_removed", req.UserId, req.MemberId, []string{req.MemberId}), nil
+}
+
+func (s *server) ListRooms(ctx context.Context, req *chat.ListRoomsRequest) (*chat.ListRoomsResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.ListRoomsResponse{Rooms: []*chat.Room{}}, nil
+	}
+
+	resp, err := s.messageStoreConn.ListRooms(ctx, &messagestore.ListRoomsRequest{UserId: req.UserId})
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]*chat.Room, 0, len(resp.Rooms))
+	for _, room := range resp.Rooms {
+		rooms = append(rooms, toChatRoom(room))
+	}
+
+	return &chat.ListRoomsResponse{
+		Rooms: rooms,
+	}, nil
+}
+
+// roomMemberRefusal returns why actorID may not add memberID to a room, or ""
+// if they may. Adding someone is held to the same blocks and
+// MESSAGING_POLICY as messaging them directly, so rooms are no way around
+// either.
+func (s *server) roomMemberRefusal(ctx context.Context, actorID, memberID string) string {
+	rel, err := s.checkRelation(ctx, actorID, memberID)
+	if err != nil {
+		log.Printf("Refusing to add %s for %s: %v", memberID, actorID, err)
+		return "Failed to add room member, try again"
+	}
+	switch rel {
+	case relationBlocked:
+		return "You cannot add this user"
+	case relationBlocking:
+		return "Unblock this user to add them"
+	case relationNotContact:
+		return "You can only add your contacts"
+	}
+	return ""
+}
+
+// loadRoomForMember returns the room if userID belongs to it.
+func (s *server) loadRoomForMember(ctx context.Context, roomID, userID string) (*chat.Room, error) {
+	if s.messageStoreConn == nil {
+		return nil, fmt.Errorf("message store not available")
+	}
+
+	resp, err := s.messageStoreConn.GetRoom(ctx, &messagestore.GetRoomRequest{RoomId: roomID})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+
+	room := toChatRoom(resp.Room)
+	for _, memberID := range room.MemberIds {
+		if memberID == userID {
+			return room, nil
+		}
+	}
+	return nil, fmt.Errorf("not a member of room %s", roomID)
+}
+
+// roomResult converts a message-store room response and, on success, notifies
+// every member plus any extra recipients of the change.
+func (s *server) roomResult(resp *messagestore.RoomResponse, eventType, actorID, memberID string, extra []string) *chat.RoomResponse {
+	if !resp.Success {
+		return &chat.RoomResponse{Success: false, Error: resp.Error}
+	}
+
+	room := toChatRoom(resp.Room)
+	s.publishRoomEvent(append(room.MemberIds, extra...), &chat.RoomEvent{
+		EventType: eventType,
+		Room:      room,
+		ActorId:   actorID,
+		MemberId:  memberID,
+	})
+
+	return &chat.RoomResponse{
+		Room:    room,
+		Success: true,
+	}
+}
+
+func (s *server) publishRoomEvent(recipients []string, event *chat.RoomEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal room event: %v", err)
+		return
+	}
+
+	for _, userID := range recipients {
+		if err := s.natsConn.Publish("chat.events."+userID, data); err != nil {
+			log.Printf("Failed to publish room event to %s: %v", userID, err)
+		}
+	}
+}
+
+func toChatRoom(room *messagestore.Room) *chat.Room {
+	if room == nil {
+		return nil
+	}
+	return &chat.Room{
+		RoomId:    room.RoomId,
+		Name:      room.Name,
+		OwnerId:   room.OwnerId,
+		MemberIds: room.MemberIds,
+		CreatedAt: room.CreatedAt,
+	}
+}
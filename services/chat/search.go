@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	chat "kubechat/proto/chat"
+	messagestore "kubechat/proto/messagestore"
+)
+
+// SearchMessages finds messages in the user's conversations. message-store
+// limits results to conversations user_id takes part in.
+func (s *server) SearchMessages(ctx context.Context, req *chat.SearchMessagesRequest) (*chat.SearchMessagesResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if s.messageStoreConn == nil {
+		return &chat.SearchMessagesResponse{
+			Results: []*chat.SearchResult{},
+		}, nil
+	}
+
+	resp, err := s.messageStoreConn.SearchMessages(ctx, &messagestore.SearchMessagesRequest{
+		UserId:        req.UserId,
+		Query:         req.Query,
+		SenderId:      req.SenderId,
+		RoomId:        req.RoomId,
+		WithUserId:    req.WithUserId,
+		After:         req.After,
+		Before:        req.Before,
+		HasAttachment: req.HasAttachment,
+		Cursor:        req.Cursor,
+		Limit:         req.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*chat.SearchResult, 0, len(resp.Results))
+	for _, result := range resp.Results {
+		results = append(results, &chat.SearchResult{
+			Message: toChatMessage(result.Message),
+			Snippet: result.Snippet,
+		})
+	}
+
+	return &chat.SearchMessagesResponse{
+		Results:    results,
+		NextCursor: resp.NextCursor,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// messageStreamName is the JetStream stream that retains chat.messages.*
+// so gateways can replay what a user missed while disconnected.
+const messageStreamName = "CHAT_MESSAGES"
+
+func ensureMessageStream(ctx context.Context, js jetstream.JetStream) error {
+	_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      messageStreamName,
+		Subjects:  []string{"chat.messages.*"},
+		Storage:   jetstream.FileStorage,
+		Retention: jetstream.LimitsPolicy,
+		MaxAge:    7 * 24 * time.Hour,
+	})
+	return err
+}
+
+// publishMessage delivers a message event to a user's subject, through
+// JetStream when available so it survives the recipient being offline.
+func (s *server) publishMessage(ctx context.Context, subject string, data []byte) error {
+	if s.js == nil {
+		return s.natsConn.Publish(subject, data)
+	}
+	_, err := s.js.Publish(ctx, subject, data)
+	return err
+}
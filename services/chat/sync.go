@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	chat "kubechat/proto/chat"
+	messagestore "kubechat/proto/messagestore"
+)
+
+// GetMessagesSince returns messages the user received after their last seen
+// message, oldest first, so a reconnecting client can catch up.
+func (s *server) GetMessagesSince(ctx context.Context, req *chat.GetMessagesSinceRequest) (*chat.GetMessageHistoryResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.GetMessageHistoryResponse{
+			Messages: []*chat.Message{},
+		}, nil
+	}
+
+	resp, err := s.messageStoreConn.GetMessagesSince(ctx, &messagestore.GetMessagesSinceRequest{
+		UserId:        req.UserId,
+		LastMessageId: req.LastMessageId,
+		LastTimestamp: req.LastTimestamp,
+		Limit:         req.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*chat.Message, 0, len(resp.Messages))
+	for _, stored := range resp.Messages {
+		messages = append(messages, toChatMessage(stored))
+	}
+
+	return &chat.GetMessageHistoryResponse{
+		Messages: messages,
+	}, nil
+}
+
+// GetChangesSince returns edits, deletions and read receipts the user missed
+// for messages they had already received.
+func (s *server) GetChangesSince(ctx context.Context, req *chat.GetChangesSinceRequest) (*chat.GetChangesSinceResponse, error) {
+	if s.messageStoreConn == nil {
+		return &chat.GetChangesSinceResponse{}, nil
+	}
+
+	resp, err := s.messageStoreConn.GetChangesSince(ctx, &messagestore.GetChangesSinceRequest{
+		UserId:        req.UserId,
+		LastMessageId: req.LastMessageId,
+		LastTimestamp: req.LastTimestamp,
+		Limit:         req.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := &chat.GetChangesSinceResponse{
+		Truncated: resp.Truncated,
+	}
+	for _, stored := range resp.Messages {
+		changes.Messages = append(changes.Messages, toChatMessage(stored))
+	}
+	for _, receipt := range resp.ReadReceipts {
+		changes.ReadReceipts = append(changes.ReadReceipts, &chat.ReadReceipt{
+			SenderId:    receipt.ReaderId,
+			RecipientId: receipt.SenderId,
+			MessageId:   receipt.MessageId,
+		})
+	}
+
+	return changes, nil
+}
+
+// MarkMessageRead records the read, notifies the message's sender and
+// refreshes the reader's unread count.
+func (s *server) MarkMessageRead(ctx context.Context, req *chat.MarkMessageReadRequest) (*chat.MarkMessageReadResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if s.messageStoreConn == nil {
+		return &chat.MarkMessageReadResponse{
+			Success: false,
+			Error:   "Message store not available",
+		}, nil
+	}
+
+	resp, err := s.messageStoreConn.MarkMessageRead(ctx, &messagestore.MarkMessageReadRequest{
+		MessageId: req.MessageId,
+		UserId:    req.UserId,
+	})
+	if err != nil {
+		return &chat.MarkMessageReadResponse{
+			Success: false,
+			Error:   "Failed to mark message read",
+		}, err
+	}
+	if !resp.Success {
+		return &chat.MarkMessageReadResponse{
+			Success: false,
+			Error:   resp.Error,
+		}, nil
+	}
+
+	// The sender is taken from the stored message rather than trusted from the reader
+	receipt := &chat.ReadReceipt{
+		SenderId:    req.UserId,
+		RecipientId: resp.Message.SenderId,
+		MessageId:   req.MessageId,
+	}
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		log.Printf("Failed to marshal read receipt: %v", err)
+	} else if err := s.natsConn.Publish("chat.events."+receipt.RecipientId, data); err != nil {
+		log.Printf("Failed to publish read receipt: %v", err)
+	}
+	go s.publishConversationUpdates([]string{req.UserId}, toChatMessage(resp.Message))
+
+	return &chat.MarkMessageReadResponse{
+		Success: true,
+	}, nil
+}
@@ -41,6 +41,76 @@ func (s *server) GetMetadata(ctx context.Context, req *media.GetMetadataRequest)
 	}, nil
 }
 
+// userPrefix is where a user's uploads are kept, so they can be listed and
+// deleted with their account.
+func userPrefix(userID string) string {
+	return userID + "/"
+}
+
+func (s *server) ListUserMedia(ctx context.Context, req *media.ListUserMediaRequest) (*media.ListUserMediaResponse, error) {
+	if req.UserId == "" {
+		return nil, fmt.Errorf("user_id is required")
+	}
+
+	resp := &media.ListUserMediaResponse{}
+	for obj := range s.minioClient.ListObjects(ctx, s.bucketName, minio.ListObjectsOptions{Prefix: userPrefix(req.UserId), Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		metadata, err := s.GetMetadata(ctx, &media.GetMetadataRequest{MediaId: obj.Key})
+		if err != nil {
+			return nil, err
+		}
+		resp.Media = append(resp.Media, metadata)
+	}
+	return resp, nil
+}
+
+func (s *server) DeleteUserMedia(ctx context.Context, req *media.DeleteUserMediaRequest) (*media.DeleteUserMediaResponse, error) {
+	if req.UserId == "" {
+		return nil, fmt.Errorf("user_id is required")
+	}
+
+	objects := s.minioClient.ListObjects(ctx, s.bucketName, minio.ListObjectsOptions{Prefix: userPrefix(req.UserId), Recursive: true})
+	var deleted int32
+	var listErr error
+	toRemove := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(toRemove)
+		for obj := range objects {
+			if obj.Err != nil {
+				listErr = obj.Err
+				return
+			}
+			select {
+			case toRemove <- obj:
+				deleted++
+			case <-ctx.Done():
+				listErr = ctx.Err()
+				return
+			}
+		}
+	}()
+
+	// Results are drained in full so neither goroutine is left blocked
+	var removeErr error
+	for result := range s.minioClient.RemoveObjects(ctx, s.bucketName, toRemove, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			log.Printf("Failed to delete %s of user %s: %v", result.ObjectName, req.UserId, result.Err)
+			removeErr = result.Err
+		}
+	}
+	if listErr != nil {
+		return nil, listErr
+	}
+	if removeErr != nil {
+		return nil, removeErr
+	}
+
+	log.Printf("Deleted %d uploads of user %s", deleted, req.UserId)
+	return &media.DeleteUserMediaResponse{Deleted: deleted}, nil
+}
+
 func (s *server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -58,6 +128,10 @@ func (s *server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	mediaID := uuid.New().String() + filepath.Ext(handler.Filename)
+	// Set by the gateway from the uploader's token
+	if userID := r.Header.Get("X-User-ID"); userID != "" {
+		mediaID = userPrefix(userID) + mediaID
+	}
 	
 	_, err = s.minioClient.PutObject(r.Context(), s.bucketName, mediaID, file, handler.Size, minio.PutObjectOptions{
 		ContentType:  handler.Header.Get("Content-Type"),
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	messagestore "kubechat/proto/messagestore"
+)
+
+// deletedUserID stands in for the sender or recipient of messages whose
+// account was deleted.
+const deletedUserID = "deleted-user"
+
+func deleteUserDataError(err error) *messagestore.DeleteUserDataResponse {
+	return &messagestore.DeleteUserDataResponse{
+		Success: false,
+		Error:   err.Error(),
+	}
+}
+
+// DeleteUserData removes a deleted account from the store. Messages it sent
+// are kept as tombstones so conversations stay intact, but lose their content,
+// revisions and sender; direct messages it received lose their recipient.
+func (s *server) DeleteUserData(ctx context.Context, req *messagestore.DeleteUserDataRequest) (*messagestore.DeleteUserDataResponse, error) {
+	if s.db == nil {
+		return deleteUserDataError(errNoDatabase), nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return deleteUserDataError(err), err
+	}
+	defer tx.Rollback()
+
+	// Owned rooms pass to the member who joined first; rooms left empty go
+	// the way of DeleteRoom
+	_, err = tx.ExecContext(ctx, `
+		UPDATE rooms SET owner_id = heir.user_id
+		FROM (
+			SELECT DISTINCT ON (room_id) room_id, user_id
+			FROM room_members
+			WHERE user_id <> $1
+			ORDER BY room_id, joined_at, user_id
+		) heir
+		WHERE rooms.room_id = heir.room_id AND rooms.owner_id = $1`, req.UserId)
+	if err != nil {
+		return deleteUserDataError(err), err
+	}
+
+	statements := []string{
+		`DELETE FROM messages WHERE room_id IN (SELECT room_id FROM rooms WHERE owner_id = $1)`,
+		`DELETE FROM rooms WHERE owner_id = $1`,
+		`DELETE FROM room_members WHERE user_id = $1`,
+		`DELETE FROM message_edits WHERE message_id IN (SELECT message_id FROM messages WHERE sender_id = $1)`,
+		`DELETE FROM message_reads WHERE user_id = $1`,
+		`DELETE FROM message_hidden WHERE user_id = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, req.UserId); err != nil {
+			return deleteUserDataError(err), err
+		}
+	}
+
+	// Setting deleted_at makes the tombstones show up in other participants'
+	// GetChangesSince
+	result, err := tx.ExecContext(ctx, `
+		UPDATE messages SET content = '', deleted_at = COALESCE(deleted_at, $2), sender_id = $3
+		WHERE sender_id = $1`,
+		req.UserId, time.Now(), deletedUserID)
+	if err != nil {
+		return deleteUserDataError(err), err
+	}
+	deleted, _ := result.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE messages SET recipient_id = $2 WHERE recipient_id = $1`, req.UserId, deletedUserID); err != nil {
+		return deleteUserDataError(err), err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return deleteUserDataError(err), err
+	}
+
+	log.Printf("Deleted data of user %s: %d messages", req.UserId, deleted)
+	return &messagestore.DeleteUserDataResponse{
+		Success:         true,
+		MessagesDeleted: deleted,
+	}, nil
+}
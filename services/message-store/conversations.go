@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	messagestore "kubechat/proto/messagestore"
+)
+
+var errInvalidConversationCursor = errors.New("invalid conversation cursor")
+
+// conversationCursor encodes the position after a conversation as
+// "<last activity in microseconds>:<conversation_id>".
+func conversationCursor(lastActivity time.Time, conversationID string) string {
+	return strconv.FormatInt(lastActivity.UnixMicro(), 10) + ":" + conversationID
+}
+
+func parseConversationCursor(cursor string) (time.Time, string, error) {
+	micros, conversationID, ok := strings.Cut(cursor, ":")
+	if !ok {
+		return time.Time{}, "", errInvalidConversationCursor
+	}
+	usec, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return time.Time{}, "", errInvalidConversationCursor
+	}
+	return time.UnixMicro(usec).UTC(), conversationID, nil
+}
+
+// ListConversations pages through the user's conversations, most recently
+// active first. A direct conversation exists once a message was exchanged and
+// until the other account is deleted; rooms are listed from the moment the
+// user joined. A message counts as unread when someone else sent it after the
+// last message the user sent or read in that conversation.
+func (s *server) ListConversations(ctx context.Context, req *messagestore.ListConversationsRequest) (*messagestore.ListConversationsResponse, error) {
+	if s.db == nil {
+		return &messagestore.ListConversationsResponse{Conversations: []*messagestore.Conversation{}}, nil
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	args := []interface{}{req.UserId}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Direct messages with deleted accounts all name deletedUserID, so they
+	// cannot be told apart and are left out rather than merged into one
+	// conversation. Narrowing the scan itself keeps single-conversation
+	// lookups cheap.
+	messageConditions := []string{
+		participantFilter,
+		`NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $1)`,
+		fmt.Sprintf(`(room_id <> '' OR %s NOT IN (sender_id, recipient_id))`, arg(deletedUserID)),
+	}
+	roomConditions := []string{`m.user_id = $1`}
+	if req.ConversationId != "" {
+		only := arg(req.ConversationId)
+		messageConditions = append(messageConditions, fmt.Sprintf(
+			`(room_id = %s OR (room_id = '' AND (sender_id = %s OR recipient_id = %s)))`, only, only, only))
+		roomConditions = append(roomConditions, `m.room_id = `+only)
+	}
+	var pageConditions []string
+	if req.Cursor != "" {
+		lastActivity, lastID, err := parseConversationCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		pageConditions = append(pageConditions,
+			fmt.Sprintf(`(c.last_activity, c.conversation_id) < (%s, %s)`, arg(lastActivity), arg(lastID)))
+	}
+	where := ""
+	if len(pageConditions) > 0 {
+		where = "WHERE " + strings.Join(pageConditions, " AND ")
+	}
+
+	stmt := `
+		WITH mine AS (
+			SELECT message_id, sender_id, room_id, timestamp, deleted_at,
+			       CASE WHEN room_id <> '' THEN room_id
+			            WHEN sender_id = $1 THEN recipient_id
+			            ELSE sender_id END AS conversation_id
+			FROM messages
+			WHERE ` + strings.Join(messageConditions, "\n\t\t\t  AND ") + `
+		),
+		latest AS (
+			SELECT DISTINCT ON (conversation_id) conversation_id, room_id, message_id, timestamp
+			FROM mine
+			ORDER BY conversation_id, timestamp DESC, message_id DESC
+		),
+		seen AS (
+			SELECT conversation_id, MAX(timestamp) AS seen_at
+			FROM mine
+			WHERE sender_id = $1
+			   OR EXISTS (SELECT 1 FROM message_reads r WHERE r.message_id = mine.message_id AND r.user_id = $1)
+			GROUP BY conversation_id
+		),
+		conversations AS (
+			SELECT conversation_id, room_id, message_id, timestamp AS last_activity FROM latest
+			UNION ALL
+			SELECT m.room_id, m.room_id, '', m.joined_at
+			FROM room_members m
+			WHERE ` + strings.Join(roomConditions, " AND ") + `
+			  AND NOT EXISTS (SELECT 1 FROM latest l WHERE l.conversation_id = m.room_id)
+		)
+		SELECT c.conversation_id, c.room_id, c.message_id, c.last_activity,
+		       (SELECT COUNT(*) FROM mine u
+		        WHERE u.conversation_id = c.conversation_id
+		          AND u.sender_id <> $1
+		          AND u.deleted_at IS NULL
+		          AND u.timestamp > COALESCE(s.seen_at, '-infinity'))
+		FROM conversations c
+		LEFT JOIN seen s ON s.conversation_id = c.conversation_id
+		` + where + `
+		ORDER BY c.last_activity DESC, c.conversation_id DESC
+		LIMIT ` + arg(limit+1)
+
+	qStart := time.Now()
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		log.Printf("Failed to list conversations: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	dbQueryLatency.Observe(time.Since(qStart).Seconds())
+
+	resp := &messagestore.ListConversationsResponse{Conversations: []*messagestore.Conversation{}}
+	lastMessageIDs := make(map[*messagestore.Conversation]string)
+	for rows.Next() {
+		var conv messagestore.Conversation
+		var lastMessageID string
+		var lastActivity time.Time
+		if err := rows.Scan(&conv.ConversationId, &conv.RoomId, &lastMessageID, &lastActivity, &conv.UnreadCount); err != nil {
+			log.Printf("Error scanning conversation: %v", err)
+			continue
+		}
+		if conv.RoomId == "" {
+			conv.UserId = conv.ConversationId
+		}
+		conv.LastActivity = timestamppb.New(lastActivity)
+		if lastMessageID != "" {
+			lastMessageIDs[&conv] = lastMessageID
+		}
+		resp.Conversations = append(resp.Conversations, &conv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Conversations) > int(limit) {
+		resp.Conversations = resp.Conversations[:limit]
+		last := resp.Conversations[limit-1]
+		resp.NextCursor = conversationCursor(last.LastActivity.AsTime(), last.ConversationId)
+	}
+
+	ids := make([]string, 0, len(resp.Conversations))
+	for _, conv := range resp.Conversations {
+		if id, ok := lastMessageIDs[conv]; ok {
+			ids = append(ids, id)
+		}
+	}
+	messages, err := s.loadMessages(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, conv := range resp.Conversations {
+		conv.LastMessage = messages[lastMessageIDs[conv]]
+	}
+	return resp, nil
+}
+
+// loadMessages fetches the given messages by ID.
+func (s *server) loadMessages(ctx context.Context, ids []string) (map[string]*messagestore.StoredMessage, error) {
+	messages := make(map[string]*messagestore.StoredMessage)
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE message_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		log.Printf("Failed to load last messages: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		msg, err := scanStoredMessage(rows)
+		if err != nil {
+			log.Printf("Error scanning message: %v", err)
+			continue
+		}
+		messages[msg.MessageId] = msg
+	}
+	return messages, rows.Err()
+}
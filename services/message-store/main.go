@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -12,20 +11,17 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
-	"github.com/nats-io/nats.go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
-	chat "kubechat/proto/chat"
 	messagestore "kubechat/proto/messagestore"
 )
 
 type server struct {
 	messagestore.UnimplementedMessageStoreServiceServer
-	db       *sql.DB
-	natsConn *nats.Conn
+	db *sql.DB
 }
 
 var (
@@ -34,6 +30,10 @@ var (
 		Help:    "Latency of DB queries",
 		Buckets: prometheus.DefBuckets,
 	})
+	dedupedWrites = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "messagestore_deduplicated_writes_total",
+		Help: "Number of StoreMessage calls for a message that was already stored",
+	})
 )
 
 func (s *server) StoreMessage(ctx context.Context, req *messagestore.StoreMessageRequest) (*messagestore.StoreMessageResponse, error) {
@@ -46,17 +46,20 @@ func (s *server) StoreMessage(ctx context.Context, req *messagestore.StoreMessag
 		}, nil
 	}
 
+	// Retried deliveries of the same message are acknowledged without a second row
 	query := `
-		INSERT INTO messages (message_id, sender_id, recipient_id, content, timestamp, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)`
+		INSERT INTO messages (message_id, sender_id, recipient_id, content, timestamp, created_at, room_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (message_id) DO NOTHING`
 
-	_, err := s.db.ExecContext(ctx, query,
+	result, err := s.db.ExecContext(ctx, query,
 		req.MessageId,
 		req.SenderId,
 		req.RecipientId,
 		req.Content,
 		req.Timestamp.AsTime(),
 		time.Now(),
+		req.RoomId,
 	)
 
 	if err != nil {
@@ -67,6 +70,10 @@ func (s *server) StoreMessage(ctx context.Context, req *messagestore.StoreMessag
 		}, err
 	}
 
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		dedupedWrites.Inc()
+	}
+
 	return &messagestore.StoreMessageResponse{
 		Success: true,
 	}, nil
@@ -90,25 +97,29 @@ func (s *server) GetMessageHistory(ctx context.Context, req *messagestore.GetMes
 	var err error
 	qStart := time.Now()
 
-	if req.LastTimestamp != nil {
+	if req.RoomId != "" {
+		rows, err = s.queryRoomHistory(ctx, req, limit)
+	} else if req.LastTimestamp != nil {
 		// Cursor-based pagination: filter by timestamp and message_id to handle same-second messages
 		query := `
-			SELECT message_id, sender_id, recipient_id, content, timestamp, created_at
+			SELECT message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at
 			FROM messages
 			WHERE ((sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1))
 			  AND (timestamp < $3 OR (timestamp = $3 AND message_id < $4))
+			  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $6)
 			ORDER BY timestamp DESC, message_id DESC
 			LIMIT $5`
-		rows, err = s.db.QueryContext(ctx, query, req.UserId1, req.UserId2, req.LastTimestamp.AsTime(), req.LastMessageId, limit)
+		rows, err = s.db.QueryContext(ctx, query, req.UserId1, req.UserId2, req.LastTimestamp.AsTime(), req.LastMessageId, limit, req.ViewerId)
 	} else {
 		// Standard query (first page or legacy offset)
 		query := `
-			SELECT message_id, sender_id, recipient_id, content, timestamp, created_at
+			SELECT message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at
 			FROM messages
-			WHERE (sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1)
+			WHERE ((sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1))
+			  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $5)
 			ORDER BY timestamp DESC, message_id DESC
 			LIMIT $3 OFFSET $4`
-		rows, err = s.db.QueryContext(ctx, query, req.UserId1, req.UserId2, limit, req.Offset)
+		rows, err = s.db.QueryContext(ctx, query, req.UserId1, req.UserId2, limit, req.Offset, req.ViewerId)
 	}
 
 	if err != nil {
@@ -122,25 +133,12 @@ func (s *server) GetMessageHistory(ctx context.Context, req *messagestore.GetMes
 
 	var messages []*messagestore.StoredMessage
 	for rows.Next() {
-		var msg messagestore.StoredMessage
-		var timestamp, createdAt time.Time
-
-		err := rows.Scan(
-			&msg.MessageId,
-			&msg.SenderId,
-			&msg.RecipientId,
-			&msg.Content,
-			&timestamp,
-			&createdAt,
-		)
+		msg, err := scanStoredMessage(rows)
 		if err != nil {
 			log.Printf("Error scanning message: %v", err)
 			continue
 		}
-
-		msg.Timestamp = timestamppb.New(timestamp)
-		msg.CreatedAt = timestamppb.New(createdAt)
-		messages = append(messages, &msg)
+		messages = append(messages, msg)
 	}
 
 	return &messagestore.GetMessageHistoryResponse{
@@ -148,66 +146,219 @@ func (s *server) GetMessageHistory(ctx context.Context, req *messagestore.GetMes
 	}, nil
 }
 
-func (s *server) DeleteMessage(ctx context.Context, req *messagestore.DeleteMessageRequest) (*messagestore.DeleteMessageResponse, error) {
-	query := `DELETE FROM messages WHERE message_id = $1 AND sender_id = $2`
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	result, err := s.db.ExecContext(ctx, query, req.MessageId, req.UserId)
+// deletedPlaceholder replaces the content of messages deleted for everyone.
+const deletedPlaceholder = "message deleted"
+
+// scanStoredMessage reads a row selected as message_id, sender_id,
+// recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at.
+func scanStoredMessage(row rowScanner) (*messagestore.StoredMessage, error) {
+	var msg messagestore.StoredMessage
+	var timestamp, createdAt time.Time
+	var editedAt, deletedAt sql.NullTime
+
+	err := row.Scan(
+		&msg.MessageId,
+		&msg.SenderId,
+		&msg.RecipientId,
+		&msg.Content,
+		&timestamp,
+		&createdAt,
+		&msg.RoomId,
+		&editedAt,
+		&deletedAt,
+	)
 	if err != nil {
+		return nil, err
+	}
+
+	msg.Timestamp = timestamppb.New(timestamp)
+	msg.CreatedAt = timestamppb.New(createdAt)
+	if editedAt.Valid {
+		msg.EditedAt = timestamppb.New(editedAt.Time)
+	}
+	if deletedAt.Valid {
+		msg.Deleted = true
+		msg.Content = deletedPlaceholder
+	}
+	return &msg, nil
+}
+
+func (s *server) queryRoomHistory(ctx context.Context, req *messagestore.GetMessageHistoryRequest, limit int32) (*sql.Rows, error) {
+	if req.LastTimestamp != nil {
+		query := `
+			SELECT message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at
+			FROM messages
+			WHERE room_id = $1
+			  AND (timestamp < $2 OR (timestamp = $2 AND message_id < $3))
+			  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $5)
+			ORDER BY timestamp DESC, message_id DESC
+			LIMIT $4`
+		return s.db.QueryContext(ctx, query, req.RoomId, req.LastTimestamp.AsTime(), req.LastMessageId, limit, req.ViewerId)
+	}
+
+	query := `
+		SELECT message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at
+		FROM messages
+		WHERE room_id = $1
+		  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $4)
+		ORDER BY timestamp DESC, message_id DESC
+		LIMIT $2 OFFSET $3`
+	return s.db.QueryContext(ctx, query, req.RoomId, limit, req.Offset, req.ViewerId)
+}
+
+func (s *server) DeleteMessage(ctx context.Context, req *messagestore.DeleteMessageRequest) (*messagestore.DeleteMessageResponse, error) {
+	if s.db == nil {
 		return &messagestore.DeleteMessageResponse{
 			Success: false,
-			Error:   err.Error(),
-		}, err
+			Error:   "Database not available",
+		}, nil
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	if req.ForEveryone {
+		return s.tombstoneMessage(ctx, req)
+	}
+	return s.hideMessage(ctx, req)
+}
+
+// tombstoneMessage deletes a message for everyone. The row is kept so history
+// can show where it was, but its content and prior revisions are removed.
+func (s *server) tombstoneMessage(ctx context.Context, req *messagestore.DeleteMessageRequest) (*messagestore.DeleteMessageResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
+		return &messagestore.DeleteMessageResponse{Success: false, Error: err.Error()}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		UPDATE messages SET content = '', deleted_at = $1
+		WHERE message_id = $2 AND sender_id = $3 AND deleted_at IS NULL
+		RETURNING message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at`,
+		time.Now(), req.MessageId, req.UserId)
+	msg, err := scanStoredMessage(row)
+	if err == sql.ErrNoRows {
 		return &messagestore.DeleteMessageResponse{
 			Success: false,
-			Error:   err.Error(),
-		}, err
+			Error:   "Message not found or not authorized to delete",
+		}, nil
+	}
+	if err != nil {
+		return &messagestore.DeleteMessageResponse{Success: false, Error: err.Error()}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_edits WHERE message_id = $1`, req.MessageId); err != nil {
+		return &messagestore.DeleteMessageResponse{Success: false, Error: err.Error()}, err
 	}
 
-	if rowsAffected == 0 {
+	if err := tx.Commit(); err != nil {
+		return &messagestore.DeleteMessageResponse{Success: false, Error: err.Error()}, err
+	}
+
+	return &messagestore.DeleteMessageResponse{
+		Success: true,
+		Message: msg,
+	}, nil
+}
+
+// hideMessage deletes a message for the requesting user only. Any participant
+// of the conversation may hide a message.
+func (s *server) hideMessage(ctx context.Context, req *messagestore.DeleteMessageRequest) (*messagestore.DeleteMessageResponse, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at
+		FROM messages
+		WHERE message_id = $1
+		  AND (sender_id = $2 OR recipient_id = $2
+		       OR (room_id <> '' AND EXISTS (
+		           SELECT 1 FROM room_members m WHERE m.room_id = messages.room_id AND m.user_id = $2)))`,
+		req.MessageId, req.UserId)
+	msg, err := scanStoredMessage(row)
+	if err == sql.ErrNoRows {
 		return &messagestore.DeleteMessageResponse{
 			Success: false,
 			Error:   "Message not found or not authorized to delete",
 		}, nil
 	}
+	if err != nil {
+		return &messagestore.DeleteMessageResponse{Success: false, Error: err.Error()}, err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO message_hidden (message_id, user_id, hidden_at) VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id) DO NOTHING`,
+		req.MessageId, req.UserId, time.Now())
+	if err != nil {
+		return &messagestore.DeleteMessageResponse{Success: false, Error: err.Error()}, err
+	}
 
 	return &messagestore.DeleteMessageResponse{
 		Success: true,
+		Message: msg,
 	}, nil
 }
 
-func (s *server) subscribeToMessages() {
-	_, err := s.natsConn.Subscribe("chat.messages.*", func(msg *nats.Msg) {
-		var message chat.Message
-		err := json.Unmarshal(msg.Data, &message)
-		if err != nil {
-			log.Printf("Failed to unmarshal message: %v", err)
-			return
-		}
+func (s *server) EditMessage(ctx context.Context, req *messagestore.EditMessageRequest) (*messagestore.EditMessageResponse, error) {
+	if s.db == nil {
+		return &messagestore.EditMessageResponse{
+			Success: false,
+			Error:   "Database not available",
+		}, nil
+	}
 
-		// Store the message
-		storeReq := &messagestore.StoreMessageRequest{
-			MessageId:   message.MessageId,
-			SenderId:    message.SenderId,
-			RecipientId: message.RecipientId,
-			Content:     message.Content,
-			Timestamp:   message.Timestamp,
-		}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &messagestore.EditMessageResponse{Success: false, Error: err.Error()}, err
+	}
+	defer tx.Rollback()
+
+	// Only the sender may edit; lock the row so concurrent edits keep a linear history
+	var previousContent string
+	err = tx.QueryRowContext(ctx, `
+		SELECT content FROM messages
+		WHERE message_id = $1 AND sender_id = $2 AND deleted_at IS NULL
+		FOR UPDATE`, req.MessageId, req.UserId,
+	).Scan(&previousContent)
+	if err == sql.ErrNoRows {
+		return &messagestore.EditMessageResponse{
+			Success: false,
+			Error:   "Message not found or not authorized to edit",
+		}, nil
+	}
+	if err != nil {
+		return &messagestore.EditMessageResponse{Success: false, Error: err.Error()}, err
+	}
 
-		_, err = s.StoreMessage(context.Background(), storeReq)
-		if err != nil {
-			log.Printf("Failed to store message from NATS: %v", err)
-		}
-	})
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_edits (message_id, content, replaced_at)
+		VALUES ($1, $2, $3)`, req.MessageId, previousContent, now)
+	if err != nil {
+		log.Printf("Failed to record edit history: %v", err)
+		return &messagestore.EditMessageResponse{Success: false, Error: err.Error()}, err
+	}
 
+	row := tx.QueryRowContext(ctx, `
+		UPDATE messages SET content = $1, edited_at = $2
+		WHERE message_id = $3
+		RETURNING message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at`,
+		req.Content, now, req.MessageId)
+	msg, err := scanStoredMessage(row)
 	if err != nil {
-		log.Printf("Failed to subscribe to messages: %v", err)
-	} else {
-		log.Println("Subscribed to chat messages from NATS")
+		log.Printf("Failed to edit message: %v", err)
+		return &messagestore.EditMessageResponse{Success: false, Error: err.Error()}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &messagestore.EditMessageResponse{Success: false, Error: err.Error()}, err
 	}
+
+	return &messagestore.EditMessageResponse{
+		Message: msg,
+		Success: true,
+	}, nil
 }
 
 func initDB() (*sql.DB, error) {
@@ -256,9 +407,9 @@ func initDB() (*sql.DB, error) {
 		return nil, err
 	}
 
-	// Create messages table
-	createTableQuery := `
-		CREATE TABLE IF NOT EXISTS messages (
+	// Create tables; every statement is idempotent so existing databases are migrated in place
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS messages (
 			id SERIAL PRIMARY KEY,
 			message_id VARCHAR(255) UNIQUE NOT NULL,
 			sender_id VARCHAR(255) NOT NULL,
@@ -266,13 +417,61 @@ func initDB() (*sql.DB, error) {
 			content TEXT NOT NULL,
 			timestamp TIMESTAMP NOT NULL,
 			created_at TIMESTAMP NOT NULL
-		)`
+		)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS room_id VARCHAR(255) NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS messages_room_timestamp_idx ON messages (room_id, timestamp DESC, message_id DESC) WHERE room_id <> ''`,
+		`CREATE TABLE IF NOT EXISTS rooms (
+			room_id VARCHAR(255) PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			owner_id VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS room_members (
+			room_id VARCHAR(255) NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL,
+			joined_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (room_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS room_members_user_idx ON room_members (user_id)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP`,
+		// Prior revisions of edited messages, newest replaced_at last
+		`CREATE TABLE IF NOT EXISTS message_edits (
+			id SERIAL PRIMARY KEY,
+			message_id VARCHAR(255) NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			replaced_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS message_edits_message_idx ON message_edits (message_id, replaced_at)`,
+		// Set when the sender deletes a message for everyone; the row stays as a tombstone
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		// Messages a participant deleted for themselves only
+		`CREATE TABLE IF NOT EXISTS message_hidden (
+			message_id VARCHAR(255) NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL,
+			hidden_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (message_id, user_id)
+		)`,
+		// Cursor queries over all of a user's conversations when a client resumes
+		`CREATE INDEX IF NOT EXISTS messages_sender_timestamp_idx ON messages (sender_id, timestamp, message_id)`,
+		`CREATE INDEX IF NOT EXISTS messages_recipient_timestamp_idx ON messages (recipient_id, timestamp, message_id)`,
+		// First time each participant read a message
+		`CREATE TABLE IF NOT EXISTS message_reads (
+			message_id VARCHAR(255) NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL,
+			read_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (message_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS message_reads_read_at_idx ON message_reads (read_at)`,
+		// Full-text search; the expression must match the one SearchMessages uses
+		`CREATE INDEX IF NOT EXISTS messages_content_search_idx ON messages USING GIN (to_tsvector('simple', content))`,
+	}
 
-	_, err = db.Exec(createTableQuery)
-	if err != nil {
-		log.Printf("Failed to create table: %v", err)
-		db.Close()
-		return nil, err
+	for _, stmt := range schema {
+		if _, err = db.Exec(stmt); err != nil {
+			log.Printf("Failed to create table: %v", err)
+			db.Close()
+			return nil, err
+		}
 	}
 
 	log.Println("Successfully connected to database and created tables")
@@ -281,7 +480,7 @@ func initDB() (*sql.DB, error) {
 
 func main() {
 	// Metrics
-	prometheus.MustRegister(dbQueryLatency)
+	prometheus.MustRegister(dbQueryLatency, dedupedWrites)
 	metricsAddr := os.Getenv("METRICS_ADDR_STORE")
 	if metricsAddr == "" {
 		metricsAddr = ":9093"
@@ -298,31 +497,15 @@ func main() {
 		log.Printf("Database connection failed, running without persistence: %v", err)
 	}
 
-	// Connect to NATS
-	natsURL := os.Getenv("NATS_URL")
-	if natsURL == "" {
-		natsURL = nats.DefaultURL
-	}
-	nc, err := nats.Connect(natsURL)
-	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
-	}
-	defer nc.Close()
-
 	lis, err := net.Listen("tcp", ":50054")
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
 	s := grpc.NewServer()
+	// StoreMessage, called by chat-service, is the only ingestion path
 	messageStoreServer := &server{
-		db:       db,
-		natsConn: nc,
-	}
-
-	// Subscribe to incoming messages from NATS
-	if nc != nil {
-		go messageStoreServer.subscribeToMessages()
+		db: db,
 	}
 
 	messagestore.RegisterMessageStoreServiceServer(s, messageStoreServer)
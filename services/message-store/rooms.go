@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	messagestore "kubechat/proto/messagestore"
+)
+
+var (
+	errNoDatabase       = errors.New("database not available")
+	errRoomNotFound     = errors.New("room not found")
+	errRoomUnauthorized = errors.New("room not found or not authorized")
+)
+
+func roomError(err error) *messagestore.RoomResponse {
+	return &messagestore.RoomResponse{
+		Success: false,
+		Error:   err.Error(),
+	}
+}
+
+func (s *server) CreateRoom(ctx context.Context, req *messagestore.CreateRoomRequest) (*messagestore.RoomResponse, error) {
+	if s.db == nil {
+		return roomError(errNoDatabase), nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return roomError(err), err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO rooms (room_id, name, owner_id, created_at) VALUES ($1, $2, $3, $4)`,
+		req.RoomId, req.Name, req.OwnerId, now)
+	if err != nil {
+		log.Printf("Failed to create room: %v", err)
+		return roomError(err), err
+	}
+
+	// The owner is always a member
+	members := append([]string{req.OwnerId}, req.MemberIds...)
+	for _, memberID := range members {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO room_members (room_id, user_id, joined_at) VALUES ($1, $2, $3)
+			ON CONFLICT (room_id, user_id) DO NOTHING`,
+			req.RoomId, memberID, now)
+		if err != nil {
+			log.Printf("Failed to add room member: %v", err)
+			return roomError(err), err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return roomError(err), err
+	}
+
+	return s.roomResponse(ctx, req.RoomId)
+}
+
+func (s *server) GetRoom(ctx context.Context, req *messagestore.GetRoomRequest) (*messagestore.RoomResponse, error) {
+	if s.db == nil {
+		return roomError(errNoDatabase), nil
+	}
+	return s.roomResponse(ctx, req.RoomId)
+}
+
+func (s *server) RenameRoom(ctx context.Context, req *messagestore.RenameRoomRequest) (*messagestore.RoomResponse, error) {
+	if s.db == nil {
+		return roomError(errNoDatabase), nil
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE rooms SET name = $1 WHERE room_id = $2 AND owner_id = $3`,
+		req.Name, req.RoomId, req.UserId)
+	if err != nil {
+		return roomError(err), err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return roomError(errRoomUnauthorized), nil
+	}
+
+	return s.roomResponse(ctx, req.RoomId)
+}
+
+func (s *server) DeleteRoom(ctx context.Context, req *messagestore.DeleteRoomRequest) (*messagestore.DeleteRoomResponse, error) {
+	if s.db == nil {
+		return &messagestore.DeleteRoomResponse{Success: false, Error: errNoDatabase.Error()}, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &messagestore.DeleteRoomResponse{Success: false, Error: err.Error()}, err
+	}
+	defer tx.Rollback()
+
+	// room_members rows are removed by ON DELETE CASCADE
+	result, err := tx.ExecContext(ctx,
+		`DELETE FROM rooms WHERE room_id = $1 AND owner_id = $2`, req.RoomId, req.UserId)
+	if err != nil {
+		return &messagestore.DeleteRoomResponse{Success: false, Error: err.Error()}, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &messagestore.DeleteRoomResponse{Success: false, Error: errRoomUnauthorized.Error()}, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE room_id = $1`, req.RoomId); err != nil {
+		return &messagestore.DeleteRoomResponse{Success: false, Error: err.Error()}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &messagestore.DeleteRoomResponse{Success: false, Error: err.Error()}, err
+	}
+
+	return &messagestore.DeleteRoomResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *server) AddRoomMember(ctx context.Context, req *messagestore.RoomMemberRequest) (*messagestore.RoomResponse, error) {
+	if s.db == nil {
+		return roomError(errNoDatabase), nil
+	}
+
+	// Any member may invite others
+	isMember, err := s.isRoomMember(ctx, req.RoomId, req.UserId)
+	if err != nil {
+		return roomError(err), err
+	}
+	if !isMember {
+		return roomError(errRoomUnauthorized), nil
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO room_members (room_id, user_id, joined_at) VALUES ($1, $2, $3)
+		ON CONFLICT (room_id, user_id) DO NOTHING`,
+		req.RoomId, req.MemberId, time.Now())
+	if err != nil {
+		return roomError(err), err
+	}
+
+	return s.roomResponse(ctx, req.RoomId)
+}
+
+func (s *server) RemoveRoomMember(ctx context.Context, req *messagestore.RoomMemberRequest) (*messagestore.RoomResponse, error) {
+	if s.db == nil {
+		return roomError(errNoDatabase), nil
+	}
+
+	room, err := s.loadRoom(ctx, req.RoomId)
+	if err != nil {
+		if errors.Is(err, errRoomNotFound) {
+			return roomError(err), nil
+		}
+		return roomError(err), err
+	}
+
+	// The owner may remove anyone; members may only remove themselves
+	if req.UserId != room.OwnerId && req.UserId != req.MemberId {
+		return roomError(errRoomUnauthorized), nil
+	}
+	if req.MemberId == room.OwnerId {
+		return roomError(errors.New("the owner cannot leave the room; delete it instead")), nil
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, req.RoomId, req.MemberId)
+	if err != nil {
+		return roomError(err), err
+	}
+
+	return s.roomResponse(ctx, req.RoomId)
+}
+
+func (s *server) ListRooms(ctx context.Context, req *messagestore.ListRoomsRequest) (*messagestore.ListRoomsResponse, error) {
+	if s.db == nil {
+		return &messagestore.ListRoomsResponse{Rooms: []*messagestore.Room{}}, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.room_id
+		FROM rooms r
+		JOIN room_members m ON m.room_id = r.room_id
+		WHERE m.user_id = $1
+		ORDER BY r.created_at DESC`, req.UserId)
+	if err != nil {
+		log.Printf("Failed to list rooms: %v", err)
+		return nil, err
+	}
+
+	var roomIDs []string
+	for rows.Next() {
+		var roomID string
+		if err := rows.Scan(&roomID); err != nil {
+			log.Printf("Error scanning room: %v", err)
+			continue
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	rows.Close()
+
+	rooms := []*messagestore.Room{}
+	for _, roomID := range roomIDs {
+		room, err := s.loadRoom(ctx, roomID)
+		if err != nil {
+			log.Printf("Failed to load room %s: %v", roomID, err)
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+
+	return &messagestore.ListRoomsResponse{
+		Rooms: rooms,
+	}, nil
+}
+
+func (s *server) roomResponse(ctx context.Context, roomID string) (*messagestore.RoomResponse, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		if errors.Is(err, errRoomNotFound) {
+			return roomError(err), nil
+		}
+		return roomError(err), err
+	}
+	return &messagestore.RoomResponse{
+		Room:    room,
+		Success: true,
+	}, nil
+}
+
+func (s *server) loadRoom(ctx context.Context, roomID string) (*messagestore.Room, error) {
+	var room messagestore.Room
+	var createdAt time.Time
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT room_id, name, owner_id, created_at FROM rooms WHERE room_id = $1`, roomID,
+	).Scan(&room.RoomId, &room.Name, &room.OwnerId, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	room.CreatedAt = timestamppb.New(createdAt)
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT user_id FROM room_members WHERE room_id = $1 ORDER BY joined_at, user_id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var memberID string
+		if err := rows.Scan(&memberID); err != nil {
+			return nil, err
+		}
+		room.MemberIds = append(room.MemberIds, memberID)
+	}
+
+	return &room, rows.Err()
+}
+
+func (s *server) isRoomMember(ctx context.Context, roomID, userID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`,
+		roomID, userID,
+	).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	messagestore "kubechat/proto/messagestore"
+)
+
+// attachmentPrefix starts the content of messages that share an upload.
+const attachmentPrefix = "IMAGE:"
+
+// searchConfig is the text search configuration of the content index. It
+// does no stemming, so it suits messages in any language.
+const searchConfig = "simple"
+
+// headlineOptions keep snippets to a few short fragments around the matches.
+const headlineOptions = `StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=8, MaxFragments=2, FragmentDelimiter=" … "`
+
+// SearchMessages pages backward through the messages the user can see that
+// match the query and filters. Deleted and hidden messages are left out.
+func (s *server) SearchMessages(ctx context.Context, req *messagestore.SearchMessagesRequest) (*messagestore.SearchMessagesResponse, error) {
+	if s.db == nil {
+		return &messagestore.SearchMessagesResponse{Results: []*messagestore.SearchResult{}}, nil
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	// $1 is the user for participantFilter; filters append their own
+	args := []interface{}{req.UserId}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions := []string{
+		participantFilter,
+		`deleted_at IS NULL`,
+		`NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $1)`,
+	}
+
+	query := strings.TrimSpace(req.Query)
+	var queryArg string
+	if query != "" {
+		queryArg = arg(query)
+		conditions = append(conditions, fmt.Sprintf(`to_tsvector('%s', content) @@ websearch_to_tsquery('%s', %s)`,
+			searchConfig, searchConfig, queryArg))
+	}
+	if req.SenderId != "" {
+		conditions = append(conditions, `sender_id = `+arg(req.SenderId))
+	}
+	if req.RoomId != "" {
+		conditions = append(conditions, `room_id = `+arg(req.RoomId))
+	}
+	if req.WithUserId != "" {
+		with := arg(req.WithUserId)
+		conditions = append(conditions, fmt.Sprintf(
+			`room_id = '' AND ((sender_id = $1 AND recipient_id = %s) OR (sender_id = %s AND recipient_id = $1))`, with, with))
+	}
+	if req.After != nil {
+		conditions = append(conditions, `timestamp >= `+arg(req.After.AsTime()))
+	}
+	if req.Before != nil {
+		conditions = append(conditions, `timestamp < `+arg(req.Before.AsTime()))
+	}
+	if req.HasAttachment {
+		conditions = append(conditions, `content LIKE `+arg(attachmentPrefix+"%"))
+	}
+	if req.Cursor != "" {
+		since, lastID, err := s.resolveCursor(ctx, req.Cursor, nil)
+		if err != nil {
+			return nil, err
+		}
+		ts, id := arg(since), arg(lastID)
+		conditions = append(conditions, fmt.Sprintf(`(timestamp < %s OR (timestamp = %s AND message_id < %s))`, ts, ts, id))
+	}
+
+	// Snippets are only built for the page, outside the filtered scan
+	snippet := `left(content, 200)`
+	if query != "" {
+		snippet = fmt.Sprintf(`ts_headline('%s', content, websearch_to_tsquery('%s', %s), '%s')`,
+			searchConfig, searchConfig, queryArg, headlineOptions)
+	}
+	// One extra row tells whether there is another page
+	stmt := `
+		SELECT ` + messageColumns + `, ` + snippet + `
+		FROM (
+			SELECT ` + messageColumns + `
+			FROM messages
+			WHERE ` + strings.Join(conditions, "\n\t\t\t  AND ") + `
+			ORDER BY timestamp DESC, message_id DESC
+			LIMIT ` + arg(limit+1) + `
+		) page
+		ORDER BY timestamp DESC, message_id DESC`
+
+	qStart := time.Now()
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		log.Printf("Failed to search messages: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	dbQueryLatency.Observe(time.Since(qStart).Seconds())
+
+	resp := &messagestore.SearchMessagesResponse{Results: []*messagestore.SearchResult{}}
+	for rows.Next() {
+		var result messagestore.SearchResult
+		msg, err := scanStoredMessage(searchRow{rows, &result.Snippet})
+		if err != nil {
+			log.Printf("Error scanning search result: %v", err)
+			continue
+		}
+		result.Message = msg
+		resp.Results = append(resp.Results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Results) > int(limit) {
+		resp.Results = resp.Results[:limit]
+		resp.NextCursor = resp.Results[limit-1].Message.MessageId
+	}
+	return resp, nil
+}
+
+// searchRow scans a search result row: the columns scanStoredMessage reads,
+// then the snippet.
+type searchRow struct {
+	row     rowScanner
+	snippet *string
+}
+
+func (r searchRow) Scan(dest ...interface{}) error {
+	return r.row.Scan(append(dest, r.snippet)...)
+}
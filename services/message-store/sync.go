@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	messagestore "kubechat/proto/messagestore"
+)
+
+// participantFilter restricts messages to conversations user $1 takes part
+// in: direct messages to or from them and messages in their rooms.
+const participantFilter = `(sender_id = $1 OR recipient_id = $1
+	OR (room_id <> '' AND room_id IN (SELECT room_id FROM room_members WHERE user_id = $1)))`
+
+const messageColumns = `message_id, sender_id, recipient_id, content, timestamp, created_at, room_id, edited_at, deleted_at`
+
+var errUnknownCursor = errors.New("unknown last_message_id and no last_timestamp")
+
+// resolveCursor returns the position of the client's last received message.
+// The stored timestamp is preferred over the client's copy, which may have
+// been rounded differently.
+func (s *server) resolveCursor(ctx context.Context, messageID string, timestamp *timestamppb.Timestamp) (time.Time, string, error) {
+	if messageID != "" {
+		var ts time.Time
+		err := s.db.QueryRowContext(ctx,
+			`SELECT timestamp FROM messages WHERE message_id = $1`, messageID).Scan(&ts)
+		if err == nil {
+			return ts, messageID, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, "", err
+		}
+	}
+	if timestamp == nil {
+		return time.Time{}, "", errUnknownCursor
+	}
+	// Without a known message, resume after everything at that instant
+	return timestamp.AsTime(), "", nil
+}
+
+// GetMessagesSince pages forward through the user's messages after the cursor,
+// oldest first. Callers continue from the last message returned.
+func (s *server) GetMessagesSince(ctx context.Context, req *messagestore.GetMessagesSinceRequest) (*messagestore.GetMessageHistoryResponse, error) {
+	if s.db == nil {
+		return &messagestore.GetMessageHistoryResponse{Messages: []*messagestore.StoredMessage{}}, nil
+	}
+
+	since, lastID, err := s.resolveCursor(ctx, req.LastMessageId, req.LastTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	qStart := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE `+participantFilter+`
+		  AND (timestamp > $2 OR (timestamp = $2 AND $3 <> '' AND message_id > $3))
+		  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $1)
+		ORDER BY timestamp, message_id
+		LIMIT $4`,
+		req.UserId, since, lastID, limit)
+	if err != nil {
+		log.Printf("Failed to query messages since cursor: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	dbQueryLatency.Observe(time.Since(qStart).Seconds())
+
+	messages := []*messagestore.StoredMessage{}
+	for rows.Next() {
+		msg, err := scanStoredMessage(rows)
+		if err != nil {
+			log.Printf("Error scanning message: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	return &messagestore.GetMessageHistoryResponse{
+		Messages: messages,
+	}, rows.Err()
+}
+
+// GetChangesSince returns what happened after the cursor to messages the user
+// had already received: edits, deletions for everyone, deletions for the user
+// alone, and reads of the user's own messages by others.
+func (s *server) GetChangesSince(ctx context.Context, req *messagestore.GetChangesSinceRequest) (*messagestore.GetChangesSinceResponse, error) {
+	if s.db == nil {
+		return &messagestore.GetChangesSinceResponse{}, nil
+	}
+
+	since, lastID, err := s.resolveCursor(ctx, req.LastMessageId, req.LastTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 500
+	}
+
+	resp := &messagestore.GetChangesSinceResponse{}
+
+	// Fetch one extra row of each kind to detect truncation
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+messageColumns+`, hidden
+		FROM (
+			SELECT `+messageColumns+`, false AS hidden,
+			       GREATEST(COALESCE(edited_at, 'epoch'), COALESCE(deleted_at, 'epoch')) AS changed_at
+			FROM messages
+			WHERE `+participantFilter+`
+			  AND (edited_at > $2 OR deleted_at > $2)
+			  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = messages.message_id AND h.user_id = $1)
+			UNION ALL
+			SELECT `+prefixedMessageColumns("m")+`, true AS hidden, h.hidden_at AS changed_at
+			FROM messages m
+			JOIN message_hidden h ON h.message_id = m.message_id AND h.user_id = $1
+			WHERE h.hidden_at > $2
+		) changes
+		WHERE timestamp < $2 OR (timestamp = $2 AND ($3 = '' OR message_id <= $3))
+		ORDER BY changed_at
+		LIMIT $4`,
+		req.UserId, since, lastID, limit+1)
+	if err != nil {
+		log.Printf("Failed to query message changes: %v", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var hidden bool
+		msg, err := scanStoredMessage(hiddenScanner{rows, &hidden})
+		if err != nil {
+			log.Printf("Error scanning message change: %v", err)
+			continue
+		}
+		// Deleting for oneself is delivered like a deletion, to that user only
+		if hidden {
+			msg.Deleted = true
+		}
+		resp.Messages = append(resp.Messages, msg)
+	}
+	rows.Close()
+	if len(resp.Messages) > int(limit) {
+		resp.Messages = resp.Messages[:limit]
+		resp.Truncated = true
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT r.message_id, r.user_id, m.sender_id, r.read_at
+		FROM message_reads r
+		JOIN messages m ON m.message_id = r.message_id
+		WHERE m.sender_id = $1 AND r.read_at > $2
+		ORDER BY r.read_at
+		LIMIT $3`,
+		req.UserId, since, limit+1)
+	if err != nil {
+		log.Printf("Failed to query read receipts: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var receipt messagestore.ReadReceipt
+		var readAt time.Time
+		if err := rows.Scan(&receipt.MessageId, &receipt.ReaderId, &receipt.SenderId, &readAt); err != nil {
+			log.Printf("Error scanning read receipt: %v", err)
+			continue
+		}
+		receipt.ReadAt = timestamppb.New(readAt)
+		resp.ReadReceipts = append(resp.ReadReceipts, &receipt)
+	}
+	if len(resp.ReadReceipts) > int(limit) {
+		resp.ReadReceipts = resp.ReadReceipts[:limit]
+		resp.Truncated = true
+	}
+
+	return resp, rows.Err()
+}
+
+// MarkMessageRead records that a participant other than the sender has read
+// a message. Marking the same message twice keeps the first read time.
+func (s *server) MarkMessageRead(ctx context.Context, req *messagestore.MarkMessageReadRequest) (*messagestore.MarkMessageReadResponse, error) {
+	if s.db == nil {
+		return &messagestore.MarkMessageReadResponse{
+			Success: false,
+			Error:   "Database not available",
+		}, nil
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE message_id = $2 AND sender_id <> $1 AND `+participantFilter,
+		req.UserId, req.MessageId)
+	msg, err := scanStoredMessage(row)
+	if err == sql.ErrNoRows {
+		return &messagestore.MarkMessageReadResponse{
+			Success: false,
+			Error:   "Message not found",
+		}, nil
+	}
+	if err != nil {
+		return &messagestore.MarkMessageReadResponse{Success: false, Error: err.Error()}, err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO message_reads (message_id, user_id, read_at) VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id) DO NOTHING`,
+		req.MessageId, req.UserId, time.Now())
+	if err != nil {
+		return &messagestore.MarkMessageReadResponse{Success: false, Error: err.Error()}, err
+	}
+
+	return &messagestore.MarkMessageReadResponse{
+		Success: true,
+		Message: msg,
+	}, nil
+}
+
+func prefixedMessageColumns(alias string) string {
+	return alias + ".message_id, " + alias + ".sender_id, " + alias + ".recipient_id, " +
+		alias + ".content, " + alias + ".timestamp, " + alias + ".created_at, " +
+		alias + ".room_id, " + alias + ".edited_at, " + alias + ".deleted_at"
+}
+
+// hiddenScanner reads the message columns followed by a trailing hidden flag.
+type hiddenScanner struct {
+	rows   *sql.Rows
+	hidden *bool
+}
+
+func (h hiddenScanner) Scan(dest ...interface{}) error {
+	return h.rows.Scan(append(dest, h.hidden)...)
+}
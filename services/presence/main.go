@@ -60,11 +60,25 @@ func (s *server) SetUserOnline(ctx context.Context, req *presence.SetUserOnlineR
 func (s *server) SetUserOffline(ctx context.Context, req *presence.SetUserOfflineRequest) (*presence.SetUserOfflineResponse, error) {
 	now := time.Now()
 
+	// A missing status means the user was cleared after deleting their
+	// account, while their connections were still closing
+	exists, err := s.redisClient.Exists(ctx, fmt.Sprintf(userStatusKey, req.UserId)).Result()
+	if err != nil {
+		log.Printf("Failed to check user status in Redis: %v", err)
+		return nil, err
+	}
+	if exists == 0 {
+		return &presence.SetUserOfflineResponse{
+			Success: true,
+			Message: "User has no status",
+		}, nil
+	}
+
 	// Update Redis
 	pipe := s.redisClient.Pipeline()
 	pipe.SRem(ctx, onlineUsersKey, req.UserId)
 	pipe.HSet(ctx, fmt.Sprintf(userStatusKey, req.UserId), "online", false, "last_seen", now.Format(time.RFC3339))
-	_, err := pipe.Exec(ctx)
+	_, err = pipe.Exec(ctx)
 
 	if err != nil {
 		log.Printf("Failed to set user offline in Redis: %v", err)
@@ -119,6 +133,34 @@ func (s *server) GetOnlineUsers(ctx context.Context, req *presence.GetOnlineUser
 	}, nil
 }
 
+// ClearUser removes a deleted user's keys and tells their contacts they went
+// offline.
+func (s *server) ClearUser(ctx context.Context, req *presence.ClearUserRequest) (*presence.ClearUserResponse, error) {
+	pipe := s.redisClient.Pipeline()
+	pipe.SRem(ctx, onlineUsersKey, req.UserId)
+	pipe.Del(ctx, fmt.Sprintf(userStatusKey, req.UserId))
+	_, err := pipe.Exec(ctx)
+
+	if err != nil {
+		log.Printf("Failed to clear user in Redis: %v", err)
+		return nil, err
+	}
+
+	event := &presence.UserStatusEvent{
+		UserId:    req.UserId,
+		Online:    false,
+		Timestamp: timestamppb.New(time.Now()),
+	}
+
+	eventData, _ := json.Marshal(event)
+	s.natsConn.Publish("users.status", eventData)
+
+	return &presence.ClearUserResponse{
+		Success: true,
+		Message: "User cleared",
+	}, nil
+}
+
 func main() {
 	// Connect to NATS
 	natsURL := os.Getenv("NATS_URL")
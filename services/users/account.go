@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	media "kubechat/proto/media"
+	messagestore "kubechat/proto/messagestore"
+	presence "kubechat/proto/presence"
+	users "kubechat/proto/users"
+)
+
+// recentLoginWindow is how long after signing in an account without a
+// password, such as one created through OIDC, may confirm its deletion.
+const recentLoginWindow = 5 * time.Minute
+
+// exportPageSize is how many messages are read from message-store at a time
+// while exporting.
+const exportPageSize = 500
+
+// reauthenticate returns why the request does not prove it comes from the
+// account owner, or "" if it does. Failures count as failed logins.
+func (s *server) reauthenticate(ctx context.Context, user *User, req *users.DeleteAccountRequest) (string, error) {
+	if wait := s.loginRetryAfter(ctx, user.Username, ""); wait > 0 {
+		return "Too many failed attempts; try again later", nil
+	}
+
+	if user.Password != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+			s.recordLoginFailure(ctx, user.Username, "")
+			return "Password is incorrect", nil
+		}
+	} else {
+		session, err := s.sessions.GetSession(ctx, req.SessionId)
+		if errors.Is(err, ErrSessionNotFound) || (err == nil && (session.UserID != user.ID || time.Since(session.CreatedAt) > recentLoginWindow)) {
+			return fmt.Sprintf("Sign in again, then delete your account within %d minutes", int(recentLoginWindow.Minutes())), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	totp, err := s.twoFactor.GetTOTP(ctx, user.ID)
+	if errors.Is(err, ErrTOTPNotFound) || (err == nil && !totp.Enabled) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	ok, err := s.verifySecondFactor(ctx, totp, req.Code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		s.recordLoginFailure(ctx, user.Username, "")
+		return "Invalid two-factor code", nil
+	}
+	return "", nil
+}
+
+// DeleteAccount erases the user everywhere: their messages are anonymized in
+// message-store, their uploads deleted from media-service, their presence
+// cleared and every device disconnected. Sessions are revoked first, so a
+// failure part way leaves the account signed out but intact, and deleting it
+// again after signing in finishes the job.
+func (s *server) DeleteAccount(ctx context.Context, req *users.DeleteAccountRequest) (*users.DeleteAccountResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	user, err := s.store.GetUserByID(ctx, req.UserId)
+	if errors.Is(err, ErrUserNotFound) {
+		return &users.DeleteAccountResponse{
+			Success: false,
+			Message: "User not found",
+		}, nil
+	}
+	if err != nil {
+		return &users.DeleteAccountResponse{
+			Success: false,
+			Message: "Failed to delete account",
+		}, err
+	}
+
+	refusal, err := s.reauthenticate(ctx, user, req)
+	if err != nil {
+		log.Printf("Failed to re-authenticate user %s: %v", user.ID, err)
+		return &users.DeleteAccountResponse{
+			Success: false,
+			Message: "Failed to delete account",
+		}, err
+	}
+	if refusal != "" {
+		return &users.DeleteAccountResponse{
+			Success: false,
+			Message: refusal,
+		}, nil
+	}
+
+	failed := &users.DeleteAccountResponse{
+		Success: false,
+		Message: "Failed to delete account; sign in and try again",
+	}
+	if err := s.revokeOtherSessions(ctx, user.ID, ""); err != nil {
+		log.Printf("Failed to revoke sessions of deleted user %s: %v", user.ID, err)
+		return failed, err
+	}
+	stored, err := s.messageStore.DeleteUserData(ctx, &messagestore.DeleteUserDataRequest{UserId: user.ID})
+	if err == nil && !stored.Success {
+		err = errors.New(stored.Error)
+	}
+	if err != nil {
+		log.Printf("Failed to delete messages of user %s: %v", user.ID, err)
+		return failed, err
+	}
+	uploads, err := s.media.DeleteUserMedia(ctx, &media.DeleteUserMediaRequest{UserId: user.ID})
+	if err != nil {
+		log.Printf("Failed to delete uploads of user %s: %v", user.ID, err)
+		return failed, err
+	}
+	if err := s.store.DeleteUser(ctx, user.ID); err != nil {
+		log.Printf("Failed to delete user %s: %v", user.ID, err)
+		return failed, err
+	}
+
+	// Presence expires with the connections anyway, so this is best effort
+	if _, err := s.presence.ClearUser(ctx, &presence.ClearUserRequest{UserId: user.ID}); err != nil {
+		log.Printf("Failed to clear presence of deleted user %s: %v", user.ID, err)
+	}
+
+	log.Printf("Deleted account %s (%s): %d messages, %d uploads", user.ID, user.Username, stored.MessagesDeleted, uploads.Deleted)
+	s.publishAudit(auditEvent{
+		Type:      "account_deleted",
+		Username:  user.Username,
+		Timestamp: time.Now().UTC(),
+	})
+	if user.Email != "" {
+		s.notify(Notification{
+			To:      user.Email,
+			Subject: "Your KubeChat account was deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nYour KubeChat account, its messages and its uploads were deleted as you asked.\n",
+				user.Username),
+		})
+	}
+
+	return &users.DeleteAccountResponse{
+		Success: true,
+		Message: "Account deleted",
+	}, nil
+}
+
+type exportedProfile struct {
+	UserID           string            `json:"user_id"`
+	Username         string            `json:"username"`
+	Email            string            `json:"email,omitempty"`
+	EmailVerified    bool              `json:"email_verified"`
+	DisplayName      string            `json:"display_name,omitempty"`
+	Bio              string            `json:"bio,omitempty"`
+	AvatarURL        string            `json:"avatar_url,omitempty"`
+	TimeZone         string            `json:"time_zone,omitempty"`
+	TwoFactorEnabled bool              `json:"two_factor_enabled"`
+	Sessions         []exportedSession `json:"sessions"`
+	Contacts         []exportedContact `json:"contacts"`
+	BlockedUserIDs   []string          `json:"blocked_user_ids"`
+	MutedUserIDs     []string          `json:"muted_user_ids"`
+}
+
+type exportedSession struct {
+	DeviceName string    `json:"device_name,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type exportedContact struct {
+	UserID string    `json:"user_id"`
+	Since  time.Time `json:"since"`
+}
+
+type exportedMessage struct {
+	MessageID   string     `json:"message_id"`
+	SenderID    string     `json:"sender_id"`
+	RecipientID string     `json:"recipient_id,omitempty"`
+	RoomID      string     `json:"room_id,omitempty"`
+	Content     string     `json:"content"`
+	Timestamp   time.Time  `json:"timestamp"`
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+type exportedMedia struct {
+	MediaID      string    `json:"media_id"`
+	OriginalName string    `json:"original_name"`
+	MimeType     string    `json:"mime_type"`
+	Size         int64     `json:"size"`
+	URL          string    `json:"url"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+}
+
+// chunkWriter sends whatever is written to it as export chunks.
+type chunkWriter struct {
+	stream users.UsersService_ExportMyDataServer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&users.ExportChunk{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ExportMyData streams a zip archive of everything kept about the user:
+// profile.json with the account, sessions, contacts, blocks and mutes,
+// messages.json with every message they can see, and media.json listing
+// their uploads.
+func (s *server) ExportMyData(req *users.ExportMyDataRequest, stream users.UsersService_ExportMyDataServer) error {
+	ctx := stream.Context()
+
+	profile, err := s.exportProfile(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to export profile of user %s: %v", req.UserId, err)
+		return err
+	}
+
+	buffered := bufio.NewWriterSize(chunkWriter{stream: stream}, 64<<10)
+	archive := zip.NewWriter(buffered)
+
+	if err := writeJSONEntry(archive, "profile.json", profile); err != nil {
+		return err
+	}
+	if err := s.exportMessages(ctx, archive, req.UserId); err != nil {
+		log.Printf("Failed to export messages of user %s: %v", req.UserId, err)
+		return err
+	}
+	if err := s.exportMedia(ctx, archive, req.UserId); err != nil {
+		log.Printf("Failed to export uploads of user %s: %v", req.UserId, err)
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func writeJSONEntry(archive *zip.Writer, name string, value interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func (s *server) exportProfile(ctx context.Context, userID string) (*exportedProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	profile := &exportedProfile{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarURL,
+		TimeZone:      user.TimeZone,
+		Sessions:      []exportedSession{},
+		Contacts:      []exportedContact{},
+	}
+
+	totp, err := s.twoFactor.GetTOTP(ctx, userID)
+	if err != nil && !errors.Is(err, ErrTOTPNotFound) {
+		return nil, err
+	}
+	profile.TwoFactorEnabled = err == nil && totp.Enabled
+
+	sessions, err := s.sessions.ListSessions(ctx, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		profile.Sessions = append(profile.Sessions, exportedSession{
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+		})
+	}
+
+	contacts, err := s.contacts.ListContacts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, contact := range contacts {
+		profile.Contacts = append(profile.Contacts, exportedContact{UserID: contact.ContactID, Since: contact.Since})
+	}
+
+	blocked, err := s.blocks.ListBlocked(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	muted, err := s.blocks.ListMuted(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	profile.BlockedUserIDs = append([]string{}, blocked...)
+	profile.MutedUserIDs = append([]string{}, muted...)
+	return profile, nil
+}
+
+// exportMessages writes messages.json a page at a time, so histories of any
+// length fit in memory.
+func (s *server) exportMessages(ctx context.Context, archive *zip.Writer, userID string) error {
+	entry, err := archive.Create("messages.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(entry, "["); err != nil {
+		return err
+	}
+
+	cursor := &messagestore.GetMessagesSinceRequest{
+		UserId:        userID,
+		LastTimestamp: timestamppb.New(time.Unix(0, 0)),
+		Limit:         exportPageSize,
+	}
+	separator := "\n"
+	for {
+		pageCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		page, err := s.messageStore.GetMessagesSince(pageCtx, cursor)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range page.Messages {
+			exported := exportedMessage{
+				MessageID:   msg.MessageId,
+				SenderID:    msg.SenderId,
+				RecipientID: msg.RecipientId,
+				RoomID:      msg.RoomId,
+				Content:     msg.Content,
+				Timestamp:   msg.Timestamp.AsTime(),
+				Deleted:     msg.Deleted,
+			}
+			if msg.Deleted {
+				exported.Content = ""
+			}
+			if msg.EditedAt != nil {
+				editedAt := msg.EditedAt.AsTime()
+				exported.EditedAt = &editedAt
+			}
+			data, err := json.Marshal(exported)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(entry, separator); err != nil {
+				return err
+			}
+			if _, err := entry.Write(data); err != nil {
+				return err
+			}
+			separator = ",\n"
+		}
+
+		if len(page.Messages) < exportPageSize {
+			break
+		}
+		last := page.Messages[len(page.Messages)-1]
+		cursor.LastMessageId, cursor.LastTimestamp = last.MessageId, last.Timestamp
+	}
+
+	_, err = io.WriteString(entry, "\n]\n")
+	return err
+}
+
+func (s *server) exportMedia(ctx context.Context, archive *zip.Writer, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := s.media.ListUserMedia(ctx, &media.ListUserMediaRequest{UserId: userID})
+	if err != nil {
+		return err
+	}
+	uploads := []exportedMedia{}
+	for _, item := range resp.Media {
+		uploads = append(uploads, exportedMedia{
+			MediaID:      item.MediaId,
+			OriginalName: item.OriginalName,
+			MimeType:     item.MimeType,
+			Size:         item.Size,
+			URL:          item.Url,
+			UploadedAt:   item.UploadedAt.AsTime(),
+		})
+	}
+	return writeJSONEntry(archive, "media.json", uploads)
+}
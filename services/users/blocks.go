@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	users "kubechat/proto/users"
+)
+
+// relationsSubject carries users.RelationEvent JSON so gateways can update
+// the filters of open connections.
+const relationsSubject = "users.relations"
+
+// checkRelationTarget returns why userID may not block or mute targetUserID,
+// or "" if it may.
+func (s *server) checkRelationTarget(ctx context.Context, userID, targetUserID string) (string, error) {
+	if targetUserID == userID {
+		return "You cannot block or mute yourself", nil
+	}
+	if _, err := s.store.GetUserByID(ctx, targetUserID); errors.Is(err, ErrUserNotFound) {
+		return "User not found", nil
+	} else if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// BlockUser stops the target from messaging the user, seeing their presence
+// and exchanging typing indicators or read receipts with them. It also ends
+// their contact relationship.
+func (s *server) BlockUser(ctx context.Context, req *users.BlockUserRequest) (*users.BlockUserResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	refusal, err := s.checkRelationTarget(ctx, req.UserId, req.TargetUserId)
+	if err != nil {
+		return &users.BlockUserResponse{
+			Success: false,
+			Message: "Failed to block user",
+		}, err
+	}
+	if refusal != "" {
+		return &users.BlockUserResponse{
+			Success: false,
+			Message: refusal,
+		}, nil
+	}
+
+	if err := s.blocks.AddBlock(ctx, req.UserId, req.TargetUserId, time.Now().UTC()); err != nil {
+		log.Printf("Failed to block user %s for %s: %v", req.TargetUserId, req.UserId, err)
+		return &users.BlockUserResponse{
+			Success: false,
+			Message: "Failed to block user",
+		}, err
+	}
+	s.publishRelation(req.UserId, req.TargetUserId, "block", true)
+
+	// The block already stands, so leftovers are only logged
+	if err := s.contacts.RemoveContact(ctx, req.UserId, req.TargetUserId); err != nil && !errors.Is(err, ErrContactNotFound) {
+		log.Printf("Failed to remove contact %s of blocking user %s: %v", req.TargetUserId, req.UserId, err)
+	}
+	for _, pair := range [][2]string{{req.UserId, req.TargetUserId}, {req.TargetUserId, req.UserId}} {
+		request, err := s.contacts.FindContactRequest(ctx, pair[0], pair[1])
+		if err == nil {
+			err = s.contacts.DeleteContactRequest(ctx, request.ID)
+		}
+		if err != nil && !errors.Is(err, ErrContactRequestNotFound) {
+			log.Printf("Failed to delete contact request from %s to %s: %v", pair[0], pair[1], err)
+		}
+	}
+
+	return &users.BlockUserResponse{
+		Success: true,
+		Message: "User blocked",
+	}, nil
+}
+
+func (s *server) UnblockUser(ctx context.Context, req *users.BlockUserRequest) (*users.BlockUserResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := s.blocks.RemoveBlock(ctx, req.UserId, req.TargetUserId)
+	if errors.Is(err, ErrBlockNotFound) {
+		return &users.BlockUserResponse{
+			Success: false,
+			Message: "User is not blocked",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to unblock user %s for %s: %v", req.TargetUserId, req.UserId, err)
+		return &users.BlockUserResponse{
+			Success: false,
+			Message: "Failed to unblock user",
+		}, err
+	}
+	s.publishRelation(req.UserId, req.TargetUserId, "block", false)
+
+	return &users.BlockUserResponse{
+		Success: true,
+		Message: "User unblocked",
+	}, nil
+}
+
+func (s *server) ListBlocked(ctx context.Context, req *users.ListBlockedRequest) (*users.ListBlockedResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	blocked, err := s.blocks.ListBlocked(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to list users blocked by %s: %v", req.UserId, err)
+		return nil, err
+	}
+	return &users.ListBlockedResponse{Users: s.publicProfiles(ctx, blocked)}, nil
+}
+
+// MuteUser keeps messages from the target coming but flags them so clients
+// do not notify.
+func (s *server) MuteUser(ctx context.Context, req *users.MuteUserRequest) (*users.MuteUserResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	refusal, err := s.checkRelationTarget(ctx, req.UserId, req.TargetUserId)
+	if err != nil {
+		return &users.MuteUserResponse{
+			Success: false,
+			Message: "Failed to mute user",
+		}, err
+	}
+	if refusal != "" {
+		return &users.MuteUserResponse{
+			Success: false,
+			Message: refusal,
+		}, nil
+	}
+
+	if err := s.blocks.AddMute(ctx, req.UserId, req.TargetUserId, time.Now().UTC()); err != nil {
+		log.Printf("Failed to mute user %s for %s: %v", req.TargetUserId, req.UserId, err)
+		return &users.MuteUserResponse{
+			Success: false,
+			Message: "Failed to mute user",
+		}, err
+	}
+	s.publishRelation(req.UserId, req.TargetUserId, "mute", true)
+
+	return &users.MuteUserResponse{
+		Success: true,
+		Message: "User muted",
+	}, nil
+}
+
+func (s *server) UnmuteUser(ctx context.Context, req *users.MuteUserRequest) (*users.MuteUserResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := s.blocks.RemoveMute(ctx, req.UserId, req.TargetUserId)
+	if errors.Is(err, ErrMuteNotFound) {
+		return &users.MuteUserResponse{
+			Success: false,
+			Message: "User is not muted",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to unmute user %s for %s: %v", req.TargetUserId, req.UserId, err)
+		return &users.MuteUserResponse{
+			Success: false,
+			Message: "Failed to unmute user",
+		}, err
+	}
+	s.publishRelation(req.UserId, req.TargetUserId, "mute", false)
+
+	return &users.MuteUserResponse{
+		Success: true,
+		Message: "User unmuted",
+	}, nil
+}
+
+func (s *server) ListMuted(ctx context.Context, req *users.ListMutedRequest) (*users.ListMutedResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	muted, err := s.blocks.ListMuted(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to list users muted by %s: %v", req.UserId, err)
+		return nil, err
+	}
+	return &users.ListMutedResponse{Users: s.publicProfiles(ctx, muted)}, nil
+}
+
+// publicProfiles returns the public profiles of the users that exist, in the
+// order given.
+func (s *server) publicProfiles(ctx context.Context, userIDs []string) []*users.GetUserResponse {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	profiles := s.profilesByID(ctx, userIDs)
+	var public []*users.GetUserResponse
+	for _, userID := range userIDs {
+		if user, exists := profiles[userID]; exists {
+			public = append(public, toPublicUserProto(user))
+		}
+	}
+	return public
+}
+
+// GetRelations gives the gateway the blocks and mutes that filter a user's
+// connection.
+func (s *server) GetRelations(ctx context.Context, req *users.GetRelationsRequest) (*users.GetRelationsResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	resp := &users.GetRelationsResponse{}
+	var err error
+	if resp.BlockedUserIds, err = s.blocks.ListBlocked(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+	if resp.BlockedByUserIds, err = s.blocks.ListBlockedBy(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+	if resp.MutedUserIds, err = s.blocks.ListMuted(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CheckBlock reports blocks in either direction between two users.
+func (s *server) CheckBlock(ctx context.Context, req *users.CheckBlockRequest) (*users.CheckBlockResponse, error) {
+	blocked, err := s.blocks.IsBlocked(ctx, req.OtherUserId, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	blocking, err := s.blocks.IsBlocked(ctx, req.UserId, req.OtherUserId)
+	if err != nil {
+		return nil, err
+	}
+	return &users.CheckBlockResponse{
+		Blocked:  blocked,
+		Blocking: blocking,
+	}, nil
+}
+
+func (s *server) publishRelation(userID, targetUserID, relation string, active bool) {
+	if s.natsConn == nil {
+		return
+	}
+	data, err := json.Marshal(&users.RelationEvent{
+		UserId:       userID,
+		TargetUserId: targetUserID,
+		Relation:     relation,
+		Active:       active,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal relation event: %v", err)
+		return
+	}
+	if err := s.natsConn.Publish(relationsSubject, data); err != nil {
+		log.Printf("Failed to publish relation event: %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	presence "kubechat/proto/presence"
+	users "kubechat/proto/users"
+)
+
+// Contact request statuses. Only pending requests are stored; the others are
+// reported once, in responses and events.
+const (
+	contactRequestPending   = "pending"
+	contactRequestAccepted  = "accepted"
+	contactRequestDeclined  = "declined"
+	contactRequestCancelled = "cancelled"
+)
+
+// contactRequestProto converts a request, adding the public profiles found in
+// profiles.
+func contactRequestProto(request *ContactRequest, status string, profiles map[string]*User) *users.ContactRequest {
+	converted := &users.ContactRequest{
+		RequestId:  request.ID,
+		FromUserId: request.FromUserID,
+		ToUserId:   request.ToUserID,
+		Status:     status,
+		CreatedAt:  request.CreatedAt.Unix(),
+	}
+	if user, exists := profiles[request.FromUserID]; exists {
+		converted.FromUser = toPublicUserProto(user)
+	}
+	if user, exists := profiles[request.ToUserID]; exists {
+		converted.ToUser = toPublicUserProto(user)
+	}
+	return converted
+}
+
+// profilesByID looks up users for display. Failures only leave profiles out.
+func (s *server) profilesByID(ctx context.Context, userIDs []string) map[string]*User {
+	found, err := s.store.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		log.Printf("Failed to look up %d users: %v", len(userIDs), err)
+	}
+	profiles := make(map[string]*User, len(found))
+	for _, user := range found {
+		profiles[user.ID] = user
+	}
+	return profiles
+}
+
+// SendContactRequest invites another user. If they already invited the
+// caller, their request is accepted instead.
+func (s *server) SendContactRequest(ctx context.Context, req *users.SendContactRequestRequest) (*users.ContactRequestResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if req.ContactId == req.UserId {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "You cannot add yourself as a contact",
+		}, nil
+	}
+	if _, err := s.store.GetUserByID(ctx, req.ContactId); errors.Is(err, ErrUserNotFound) {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "User not found",
+		}, nil
+	} else if err != nil {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to send contact request",
+		}, err
+	}
+
+	block, err := s.CheckBlock(ctx, &users.CheckBlockRequest{UserId: req.UserId, OtherUserId: req.ContactId})
+	if err != nil {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to send contact request",
+		}, err
+	}
+	if block.Blocked || block.Blocking {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "You cannot add this user as a contact",
+		}, nil
+	}
+
+	contacts, err := s.contacts.AreContacts(ctx, req.UserId, req.ContactId)
+	if err != nil {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to send contact request",
+		}, err
+	}
+	if contacts {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Already a contact",
+		}, nil
+	}
+
+	if reverse, err := s.contacts.FindContactRequest(ctx, req.ContactId, req.UserId); err == nil {
+		return s.acceptContactRequest(ctx, reverse.ID)
+	} else if !errors.Is(err, ErrContactRequestNotFound) {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to send contact request",
+		}, err
+	}
+
+	request := &ContactRequest{
+		ID:         generateID(),
+		FromUserID: req.UserId,
+		ToUserID:   req.ContactId,
+		CreatedAt:  time.Now().UTC(),
+	}
+	err = s.contacts.CreateContactRequest(ctx, request)
+	if errors.Is(err, ErrContactRequestExists) {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Contact request already sent",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to save contact request from %s to %s: %v", req.UserId, req.ContactId, err)
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to send contact request",
+		}, err
+	}
+
+	converted := contactRequestProto(request, contactRequestPending,
+		s.profilesByID(ctx, []string{request.FromUserID, request.ToUserID}))
+	s.publishContactEvent(request.ToUserID, "received", converted)
+
+	return &users.ContactRequestResponse{
+		Success: true,
+		Message: "Contact request sent",
+		Request: converted,
+	}, nil
+}
+
+func (s *server) AcceptContactRequest(ctx context.Context, req *users.ContactRequestActionRequest) (*users.ContactRequestResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	request, err := s.contacts.GetContactRequest(ctx, req.RequestId)
+	if errors.Is(err, ErrContactRequestNotFound) || (err == nil && request.ToUserID != req.UserId) {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Contact request not found",
+		}, nil
+	}
+	if err != nil {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to accept contact request",
+		}, err
+	}
+
+	return s.acceptContactRequest(ctx, request.ID)
+}
+
+// acceptContactRequest makes the two users contacts and tells the sender.
+func (s *server) acceptContactRequest(ctx context.Context, requestID string) (*users.ContactRequestResponse, error) {
+	request, err := s.contacts.AcceptContactRequest(ctx, requestID, time.Now().UTC())
+	if errors.Is(err, ErrContactRequestNotFound) {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Contact request not found",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to accept contact request %s: %v", requestID, err)
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to accept contact request",
+		}, err
+	}
+
+	converted := contactRequestProto(request, contactRequestAccepted,
+		s.profilesByID(ctx, []string{request.FromUserID, request.ToUserID}))
+	s.publishContactEvent(request.FromUserID, "accepted", converted)
+
+	return &users.ContactRequestResponse{
+		Success: true,
+		Message: "Contact request accepted",
+		Request: converted,
+	}, nil
+}
+
+// DeclineContactRequest refuses a request; the sender may ask again later.
+func (s *server) DeclineContactRequest(ctx context.Context, req *users.ContactRequestActionRequest) (*users.ContactRequestResponse, error) {
+	return s.closeContactRequest(ctx, req, contactRequestDeclined)
+}
+
+// CancelContactRequest withdraws a request the caller sent.
+func (s *server) CancelContactRequest(ctx context.Context, req *users.ContactRequestActionRequest) (*users.ContactRequestResponse, error) {
+	return s.closeContactRequest(ctx, req, contactRequestCancelled)
+}
+
+// closeContactRequest deletes a request, declined by its recipient or
+// cancelled by its sender, and tells the other party.
+func (s *server) closeContactRequest(ctx context.Context, req *users.ContactRequestActionRequest, status string) (*users.ContactRequestResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Only the recipient may decline and only the sender may cancel
+	request, err := s.contacts.GetContactRequest(ctx, req.RequestId)
+	var owner, other string
+	if err == nil {
+		owner, other = request.ToUserID, request.FromUserID
+		if status == contactRequestCancelled {
+			owner, other = other, owner
+		}
+		if owner != req.UserId {
+			err = ErrContactRequestNotFound
+		}
+	}
+	if err == nil {
+		err = s.contacts.DeleteContactRequest(ctx, request.ID)
+	}
+	if errors.Is(err, ErrContactRequestNotFound) {
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Contact request not found",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to close contact request %s: %v", req.RequestId, err)
+		return &users.ContactRequestResponse{
+			Success: false,
+			Message: "Failed to update contact request",
+		}, err
+	}
+
+	converted := contactRequestProto(request, status,
+		s.profilesByID(ctx, []string{request.FromUserID, request.ToUserID}))
+	s.publishContactEvent(other, status, converted)
+
+	return &users.ContactRequestResponse{
+		Success: true,
+		Message: "Contact request " + status,
+		Request: converted,
+	}, nil
+}
+
+func (s *server) ListContactRequests(ctx context.Context, req *users.ListContactRequestsRequest) (*users.ListContactRequestsResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	requests, err := s.contacts.ListContactRequests(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to list contact requests of user %s: %v", req.UserId, err)
+		return nil, err
+	}
+
+	var userIDs []string
+	for _, request := range requests {
+		userIDs = append(userIDs, request.FromUserID, request.ToUserID)
+	}
+	profiles := s.profilesByID(ctx, userIDs)
+
+	resp := &users.ListContactRequestsResponse{}
+	for _, request := range requests {
+		converted := contactRequestProto(request, contactRequestPending, profiles)
+		if request.ToUserID == req.UserId {
+			resp.Incoming = append(resp.Incoming, converted)
+		} else {
+			resp.Outgoing = append(resp.Outgoing, converted)
+		}
+	}
+	return resp, nil
+}
+
+// ListContacts returns the user's contacts with their presence. Contacts
+// whose presence cannot be read are shown offline.
+func (s *server) ListContacts(ctx context.Context, req *users.ListContactsRequest) (*users.ListContactsResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	contacts, err := s.contacts.ListContacts(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to list contacts of user %s: %v", req.UserId, err)
+		return nil, err
+	}
+
+	var contactIDs []string
+	for _, contact := range contacts {
+		contactIDs = append(contactIDs, contact.ContactID)
+	}
+	profiles := s.profilesByID(ctx, contactIDs)
+
+	resp := &users.ListContactsResponse{}
+	for _, contact := range contacts {
+		user, exists := profiles[contact.ContactID]
+		if !exists {
+			continue
+		}
+		converted := &users.Contact{
+			User:  toPublicUserProto(user),
+			Since: contact.Since.Unix(),
+		}
+		status, err := s.presence.GetUserStatus(ctx, &presence.GetUserStatusRequest{UserId: contact.ContactID})
+		if err != nil {
+			log.Printf("Failed to get presence of user %s: %v", contact.ContactID, err)
+		} else {
+			converted.Online = status.Online
+			if status.LastSeen != nil {
+				converted.LastSeen = status.LastSeen.AsTime().Unix()
+			}
+		}
+		resp.Contacts = append(resp.Contacts, converted)
+	}
+
+	sort.Slice(resp.Contacts, func(i, j int) bool {
+		a, b := resp.Contacts[i], resp.Contacts[j]
+		if a.Online != b.Online {
+			return a.Online
+		}
+		return a.User.Username < b.User.Username
+	})
+	return resp, nil
+}
+
+func (s *server) RemoveContact(ctx context.Context, req *users.RemoveContactRequest) (*users.RemoveContactResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := s.contacts.RemoveContact(ctx, req.UserId, req.ContactId)
+	if errors.Is(err, ErrContactNotFound) {
+		return &users.RemoveContactResponse{
+			Success: false,
+			Message: "Contact not found",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to remove contact %s of user %s: %v", req.ContactId, req.UserId, err)
+		return &users.RemoveContactResponse{
+			Success: false,
+			Message: "Failed to remove contact",
+		}, err
+	}
+
+	return &users.RemoveContactResponse{
+		Success: true,
+		Message: "Contact removed",
+	}, nil
+}
+
+// AreContacts lets chat-service restrict direct messages to contacts.
+func (s *server) AreContacts(ctx context.Context, req *users.AreContactsRequest) (*users.AreContactsResponse, error) {
+	contacts, err := s.contacts.AreContacts(ctx, req.UserId, req.OtherUserId)
+	if err != nil {
+		log.Printf("Failed to check contacts %s and %s: %v", req.UserId, req.OtherUserId, err)
+		return nil, err
+	}
+	return &users.AreContactsResponse{Contacts: contacts}, nil
+}
+
+// publishContactEvent notifies the user on their chat.events subject, which
+// the gateway forwards as a contact_request frame.
+func (s *server) publishContactEvent(userID, action string, request *users.ContactRequest) {
+	if s.natsConn == nil {
+		return
+	}
+	data, err := json.Marshal(&users.ContactRequestEvent{
+		Action:  action,
+		Request: request,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal contact event: %v", err)
+		return
+	}
+	if err := s.natsConn.Publish("chat.events."+userID, data); err != nil {
+		log.Printf("Failed to publish contact event to %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	users "kubechat/proto/users"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 50
+	maxBatchUsers         = 100
+)
+
+// toPublicUserProto is what any user may see of another: the profile without
+// the email address.
+func toPublicUserProto(user *User) *users.GetUserResponse {
+	public := toUserProto(user)
+	public.Email = ""
+	public.EmailVerified = false
+	return public
+}
+
+// SearchUsers finds users by username or display name. Pages are addressed
+// by offset, carried in an opaque page token.
+func (s *server) SearchUsers(ctx context.Context, req *users.SearchUsersRequest) (*users.SearchUsersResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := strings.TrimSpace(req.Query)
+	if query == "" {
+		return &users.SearchUsersResponse{}, nil
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultSearchPageSize
+	}
+	pageSize = min(pageSize, maxSearchPageSize)
+	offset, err := strconv.Atoi(req.PageToken)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	// One extra row tells whether there is another page
+	found, err := s.store.SearchUsers(ctx, query, offset, pageSize+1)
+	if err != nil {
+		log.Printf("Failed to search users for %q: %v", query, err)
+		return nil, err
+	}
+
+	resp := &users.SearchUsersResponse{}
+	if len(found) > pageSize {
+		found = found[:pageSize]
+		resp.NextPageToken = strconv.Itoa(offset + pageSize)
+	}
+	for _, user := range found {
+		resp.Users = append(resp.Users, toPublicUserProto(user))
+	}
+	return resp, nil
+}
+
+// BatchGetUsers looks up many users at once, such as everyone in a room.
+func (s *server) BatchGetUsers(ctx context.Context, req *users.BatchGetUsersRequest) (*users.BatchGetUsersResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	userIDs := req.UserIds
+	if len(userIDs) > maxBatchUsers {
+		userIDs = userIDs[:maxBatchUsers]
+	}
+
+	found, err := s.store.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		log.Printf("Failed to look up %d users: %v", len(userIDs), err)
+		return nil, err
+	}
+	byID := make(map[string]*User, len(found))
+	for _, user := range found {
+		byID[user.ID] = user
+	}
+
+	resp := &users.BatchGetUsersResponse{}
+	seen := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if user, exists := byID[userID]; exists && !seen[userID] {
+			seen[userID] = true
+			resp.Users = append(resp.Users, toPublicUserProto(user))
+		}
+	}
+	return resp, nil
+}
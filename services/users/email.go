@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	users "kubechat/proto/users"
+)
+
+const (
+	maxEmailLength = 254
+	// maxVerificationEmails is how many verification messages one account is
+	// sent per hour.
+	maxVerificationEmails = 3
+)
+
+// emailPolicy is what accounts may do before verifying their email address.
+type emailPolicy string
+
+const (
+	emailPolicyOff       emailPolicy = "off"       // Anything; verification is optional
+	emailPolicyLogin     emailPolicy = "login"     // Nothing; login is refused
+	emailPolicyMessaging emailPolicy = "messaging" // Sign in, but not send messages
+)
+
+// loadEmailPolicy reads EMAIL_VERIFICATION_POLICY. Unless it is off, new
+// accounts must give an email address.
+func loadEmailPolicy() emailPolicy {
+	switch policy := emailPolicy(os.Getenv("EMAIL_VERIFICATION_POLICY")); policy {
+	case "", emailPolicyOff:
+		return emailPolicyOff
+	case emailPolicyLogin, emailPolicyMessaging:
+		return policy
+	default:
+		log.Printf("Invalid EMAIL_VERIFICATION_POLICY %q, using %s", policy, emailPolicyOff)
+		return emailPolicyOff
+	}
+}
+
+// checkEmail returns why the address is refused, or "" if it is acceptable.
+// Only plain addresses are accepted, without a display name or comments.
+// normalizeEmail is the form an address is stored and looked up in. Mailbox
+// names are case-sensitive in theory but not in practice, and treating them
+// so would let one inbox back several accounts.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func checkEmail(email string) string {
+	if len(email) > maxEmailLength {
+		return "Email address is too long"
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "Invalid email address"
+	}
+	if _, domain, _ := strings.Cut(email, "@"); !strings.Contains(domain, ".") {
+		return "Invalid email address"
+	}
+	return ""
+}
+
+// loginBlocked reports whether the policy keeps the user from signing in.
+func (s *server) loginBlocked(user *User) bool {
+	return s.emailPolicy == emailPolicyLogin && !user.EmailVerified
+}
+
+// emailVerificationRequired refuses a login until the address is verified.
+func emailVerificationRequired(userID string) *users.LoginUserResponse {
+	return &users.LoginUserResponse{
+		UserId:                    userID,
+		Success:                   false,
+		Message:                   "Verify your email address before signing in",
+		EmailVerificationRequired: true,
+	}
+}
+
+// messagingRestricted reports whether the user's access tokens must keep them
+// from sending messages. Lookup errors do not restrict anyone.
+func (s *server) messagingRestricted(ctx context.Context, userID string) bool {
+	if s.emailPolicy != emailPolicyMessaging {
+		return false
+	}
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to check email verification of user %s: %v", userID, err)
+		return false
+	}
+	return !user.EmailVerified
+}
+
+// sendVerificationEmail mails the user a link that verifies their current
+// address.
+func (s *server) sendVerificationEmail(ctx context.Context, user *User) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+
+	now := time.Now().UTC()
+	err := s.verifications.SaveEmailVerificationToken(ctx, &EmailVerificationToken{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.verificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Verify your KubeChat email address",
+		Body: fmt.Sprintf("Hi %s,\n\nTo confirm that this is your address, open:\n\n%s%s\n\n"+
+			"The link expires in %s. If you did not sign up for KubeChat, ignore this message.\n",
+			user.Username, s.verifyURL, token, s.verificationTTL),
+	})
+	return nil
+}
+
+func (s *server) VerifyEmail(ctx context.Context, req *users.VerifyEmailRequest) (*users.VerifyEmailResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	token, err := s.verifications.UseEmailVerificationToken(ctx, hashToken(req.Token), time.Now().UTC())
+	if errors.Is(err, ErrVerificationTokenNotFound) {
+		return &users.VerifyEmailResponse{
+			Success: false,
+			Message: "Invalid or expired verification link",
+		}, nil
+	}
+	if err != nil {
+		return &users.VerifyEmailResponse{
+			Success: false,
+			Message: "Failed to verify email address",
+		}, err
+	}
+
+	// The link only counts for the address it was sent to
+	err = s.store.MarkEmailVerified(ctx, token.UserID, token.Email)
+	if errors.Is(err, ErrUserNotFound) {
+		return &users.VerifyEmailResponse{
+			Success: false,
+			Message: "The email address has changed since this link was sent",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to verify email of user %s: %v", token.UserID, err)
+		return &users.VerifyEmailResponse{
+			Success: false,
+			Message: "Failed to verify email address",
+		}, err
+	}
+
+	return &users.VerifyEmailResponse{
+		Success: true,
+		Message: "Email address verified",
+	}, nil
+}
+
+// ResendVerificationEmail sends a new link to an unverified account. It
+// answers the same whether or not the account exists.
+func (s *server) ResendVerificationEmail(ctx context.Context, req *users.ResendVerificationEmailRequest) (*users.ResendVerificationEmailResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if req.Email == "" && req.Username == "" {
+		return &users.ResendVerificationEmailResponse{
+			Success: false,
+			Message: "Email or username required",
+		}, nil
+	}
+	resp := &users.ResendVerificationEmailResponse{
+		Success: true,
+		Message: "If the account exists and is unverified, a verification link has been sent",
+	}
+
+	var user *User
+	var err error
+	if req.Email != "" {
+		user, err = s.store.GetUserByEmail(ctx, req.Email)
+	} else {
+		user, err = s.store.GetUserByUsername(ctx, req.Username)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			log.Printf("Failed to look up user for email verification: %v", err)
+		}
+		return resp, nil
+	}
+	if user.Email == "" || user.EmailVerified {
+		return resp, nil
+	}
+
+	requests, err := s.throttle.RecordLoginFailure(ctx, "verify:"+user.ID, time.Now().UTC(), time.Hour)
+	if err != nil {
+		log.Printf("Failed to count verification requests of user %s: %v", user.ID, err)
+	} else if requests > maxVerificationEmails {
+		log.Printf("Not sending another verification link to user %s this hour", user.ID)
+		return resp, nil
+	}
+
+	if err := s.sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+	}
+	return resp, nil
+}
+
+// verifyURLFromEnv is where verification links point; the token is appended.
+func verifyURLFromEnv() string {
+	if url := os.Getenv("EMAIL_VERIFY_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080/#verify_token="
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	users "kubechat/proto/users"
+)
+
+// minRSAKeyBits is the smallest RSA signing key accepted.
+const minRSAKeyBits = 2048
+
+// signingKey is a private key that signs access tokens, identified by the kid
+// header so verifiers can pick the matching public key.
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// keyRing holds the signing keys loaded from JWT_SIGNING_KEYS_DIR. Every key is
+// published in the JWKS; only the active one signs. To rotate, add a new key
+// file (it becomes active once it sorts last, or when named by JWT_ACTIVE_KID)
+// and remove the old one after ACCESS_TOKEN_TTL has passed.
+type keyRing struct {
+	dir       string
+	activeKID string
+
+	mutex  sync.RWMutex
+	active *signingKey
+	keys   []*signingKey
+}
+
+// newKeyRing loads the signing keys. Without a key directory it refuses to
+// start unless AUTH_DEV_MODE=true, in which case an ephemeral key is generated.
+func newKeyRing() (*keyRing, error) {
+	ring := &keyRing{
+		dir:       os.Getenv("JWT_SIGNING_KEYS_DIR"),
+		activeKID: os.Getenv("JWT_ACTIVE_KID"),
+	}
+
+	if ring.dir == "" {
+		if os.Getenv("AUTH_DEV_MODE") != "true" {
+			return nil, fmt.Errorf("JWT_SIGNING_KEYS_DIR not set; set AUTH_DEV_MODE=true to use an ephemeral key")
+		}
+		log.Println("WARNING: AUTH_DEV_MODE set; signing with an ephemeral key (not for production)")
+		_, private, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, err
+		}
+		key := &signingKey{kid: "dev-" + generateID()[:8], method: jwt.SigningMethodEdDSA, private: private}
+		ring.active, ring.keys = key, []*signingKey{key}
+		return ring, nil
+	}
+
+	if err := ring.reload(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// reload reads every *.pem file in the key directory; the file name without
+// the extension is the kid. The current keys are kept if anything is wrong.
+func (r *keyRing) reload() error {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	var keys []*signingKey
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no signing keys in %s", r.dir)
+	}
+
+	active := keys[len(keys)-1]
+	if r.activeKID != "" {
+		active = nil
+		for _, key := range keys {
+			if key.kid == r.activeKID {
+				active = key
+			}
+		}
+		if active == nil {
+			return fmt.Errorf("JWT_ACTIVE_KID %q does not match any key in %s", r.activeKID, r.dir)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.active == nil || r.active.kid != active.kid {
+		log.Printf("Signing access tokens with key %s (%s)", active.kid, active.method.Alg())
+	}
+	r.active, r.keys = active, keys
+	return nil
+}
+
+// watch reloads the key directory until ctx is cancelled, so a rotated key
+// mounted from a secret is picked up without a restart.
+func (r *keyRing) watch(ctx context.Context, interval time.Duration) {
+	if r.dir == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				log.Printf("Failed to reload signing keys, keeping current ones: %v", err)
+			}
+		}
+	}
+}
+
+func loadSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key is %d bits, need at least %d", private.N.BitLen(), minRSAKeyBits)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: private}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: private}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T; use RSA or Ed25519", parsed)
+	}
+}
+
+// sign signs the claims with the active key.
+func (r *keyRing) sign(claims jwt.Claims) (string, error) {
+	r.mutex.RLock()
+	key := r.active
+	r.mutex.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// jwks returns the public half of every loaded key.
+func (r *keyRing) jwks() *users.GetJWKSResponse {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	resp := &users.GetJWKSResponse{}
+	for _, key := range r.keys {
+		jwk := &users.JsonWebKey{
+			Kid: key.kid,
+			Use: "sig",
+			Alg: key.method.Alg(),
+		}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		resp.Keys = append(resp.Keys, jwk)
+	}
+	return resp
+}
+
+func (s *server) GetJWKS(ctx context.Context, req *users.GetJWKSRequest) (*users.GetJWKSResponse, error) {
+	return s.keys.jwks(), nil
+}
+
+// handleJWKS serves the key set at /.well-known/jwks.json for verifiers that
+// do not speak gRPC.
+func (s *server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	json.NewEncoder(w).Encode(s.keys.jwks())
+}
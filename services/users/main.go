@@ -5,37 +5,70 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"log"
 	"net"
 	"net/http"
-	"sync"
 	"time"
 
 	"os"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/nats-io/nats.go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 
+	media "kubechat/proto/media"
+	messagestore "kubechat/proto/messagestore"
+	presence "kubechat/proto/presence"
 	users "kubechat/proto/users"
 )
 
 type User struct {
-	ID       string
-	Username string
-	Email    string
-	Password string
-	Online   bool
+	ID            string
+	Username      string
+	Email         string
+	EmailVerified bool
+	Password      string
+	Online        bool
+	Profile
 }
 
 type server struct {
 	users.UnimplementedUsersServiceServer
-	users     map[string]*User
-	mutex     sync.RWMutex
-	jwtSecret []byte
+	store         UserStore
+	tokens        TokenStore
+	sessions      SessionStore
+	twoFactor     TwoFactorStore
+	identities    IdentityStore
+	throttle      LoginThrottleStore
+	resets        PasswordResetStore
+	verifications EmailVerificationStore
+	contacts      ContactStore
+	blocks        BlockStore
+	notifier      Notifier
+	natsConn      *nats.Conn // publishes revocations; nil if NATS is unavailable
+	media         media.MediaServiceClient
+	presence      presence.PresenceServiceClient
+	messageStore  messagestore.MessageStoreServiceClient
+	keys          *keyRing
+
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	totpIssuer string // Shown in authenticator apps
+
+	accountThrottle throttlePolicy
+	ipThrottle      throttlePolicy
+
+	passwordPolicy passwordPolicy
+	resetTTL       time.Duration
+	resetURL       string // Reset links are this followed by the token
+
+	emailPolicy     emailPolicy
+	verificationTTL time.Duration
+	verifyURL       string // Verification links are this followed by the token
 }
 
 var (
@@ -55,15 +88,24 @@ func (s *server) CreateUser(ctx context.Context, req *users.CreateUserRequest) (
 	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	// Check if username already exists
-	for _, user := range s.users {
-		if user.Username == req.Username {
+	if msg := s.passwordPolicy.check(req.Password); msg != "" {
+		return &users.CreateUserResponse{
+			Success: false,
+			Message: msg,
+		}, nil
+	}
+	email := normalizeEmail(req.Email)
+	if email == "" && s.emailPolicy != emailPolicyOff {
+		return &users.CreateUserResponse{
+			Success: false,
+			Message: "Email address required",
+		}, nil
+	}
+	if email != "" {
+		if msg := checkEmail(email); msg != "" {
 			return &users.CreateUserResponse{
 				Success: false,
-				Message: "Username already exists",
+				Message: msg,
 			}, nil
 		}
 	}
@@ -83,12 +125,36 @@ func (s *server) CreateUser(ctx context.Context, req *users.CreateUserRequest) (
 	user := &User{
 		ID:       userID,
 		Username: req.Username,
-		Email:    req.Email,
+		Email:    email,
 		Password: string(hashedPassword),
 		Online:   false,
 	}
 
-	s.users[userID] = user
+	err = s.store.CreateUser(ctx, user)
+	switch {
+	case errors.Is(err, ErrUsernameTaken):
+		return &users.CreateUserResponse{
+			Success: false,
+			Message: "Username already exists",
+		}, nil
+	case errors.Is(err, ErrEmailTaken):
+		return &users.CreateUserResponse{
+			Success: false,
+			Message: "Email already exists",
+		}, nil
+	case err != nil:
+		log.Printf("Failed to create user: %v", err)
+		return &users.CreateUserResponse{
+			Success: false,
+			Message: "Failed to create user",
+		}, err
+	}
+
+	if user.Email != "" {
+		if err := s.sendVerificationEmail(ctx, user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", userID, err)
+		}
+	}
 
 	return &users.CreateUserResponse{
 		UserId:  userID,
@@ -102,35 +168,73 @@ func (s *server) LoginUser(ctx context.Context, req *users.LoginUserRequest) (*u
 	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	var user *User
-	for _, u := range s.users {
-		if u.Username == req.Username {
-			user = u
-			break
-		}
+	if wait := s.loginRetryAfter(ctx, req.Username, req.IpAddress); wait > 0 {
+		return throttledResponse(wait), nil
 	}
 
-	if user == nil {
+	user, err := s.store.GetUserByUsername(ctx, req.Username)
+	if errors.Is(err, ErrUserNotFound) {
+		// Spend the same time as a real check and fail the same way, so
+		// usernames cannot be enumerated
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		s.recordLoginFailure(ctx, req.Username, req.IpAddress)
+		return invalidCredentials(), nil
+	}
+	if err != nil {
 		return &users.LoginUserResponse{
 			Success: false,
-			Message: "User not found",
-		}, nil
+			Message: "Failed to look up user",
+		}, err
 	}
 
 	// Check password
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		s.recordLoginFailure(ctx, req.Username, req.IpAddress)
+		return invalidCredentials(), nil
+	}
+
+	// Checked after the password so it does not reveal which accounts exist
+	if s.loginBlocked(user) {
+		return emailVerificationRequired(user.ID), nil
+	}
+
+	// Accounts with two-factor authentication get a challenge instead of
+	// tokens until VerifyLoginTOTP receives a valid code
+	totp, err := s.twoFactor.GetTOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrTOTPNotFound) {
 		return &users.LoginUserResponse{
 			Success: false,
-			Message: "Invalid password",
+			Message: "Failed to look up user",
+		}, err
+	}
+	if err == nil && totp.Enabled {
+		challengeToken, err := s.startLoginChallenge(ctx, user.ID, req)
+		if err != nil {
+			return &users.LoginUserResponse{
+				Success: false,
+				Message: "Failed to start login",
+			}, err
+		}
+		return &users.LoginUserResponse{
+			UserId:            user.ID,
+			Success:           false,
+			Message:           "Two-factor code required",
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
 		}, nil
 	}
 
-	// Generate JWT token
-	token, err := s.generateJWT(user.ID)
+	if err := s.throttle.ClearLoginAttempts(ctx, accountKey(req.Username)); err != nil {
+		log.Printf("Failed to clear login attempts of %s: %v", req.Username, err)
+	}
+	return s.completeLogin(ctx, user.ID, req)
+}
+
+// completeLogin starts a session for an authenticated user.
+func (s *server) completeLogin(ctx context.Context, userID string, req *users.LoginUserRequest) (*users.LoginUserResponse, error) {
+	// Each login starts a new session with its own refresh token chain
+	token, refreshToken, err := s.startSession(ctx, userID, req)
 	if err != nil {
 		return &users.LoginUserResponse{
 			Success: false,
@@ -138,14 +242,18 @@ func (s *server) LoginUser(ctx context.Context, req *users.LoginUserRequest) (*u
 		}, err
 	}
 
-	user.Online = true
+	if err := s.store.SetOnline(ctx, userID, true); err != nil {
+		log.Printf("Failed to mark user %s online: %v", userID, err)
+	}
 	loginSuccess.Inc()
 
 	return &users.LoginUserResponse{
-		UserId:  user.ID,
-		Token:   token,
-		Success: true,
-		Message: "Login successful",
+		UserId:       userID,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+		Success:      true,
+		Message:      "Login successful",
 	}, nil
 }
 
@@ -154,20 +262,30 @@ func (s *server) GetUser(ctx context.Context, req *users.GetUserRequest) (*users
 	ctx, cancel = context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	user, err := s.store.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
 
-	user, exists := s.users[req.UserId]
-	if !exists {
-		return nil, fmt.Errorf("user not found")
+	if req.RequesterId != user.ID {
+		return toPublicUserProto(user), nil
 	}
+	return toUserProto(user), nil
+}
 
+func toUserProto(user *User) *users.GetUserResponse {
 	return &users.GetUserResponse{
-		UserId:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Online:   user.Online,
-	}, nil
+		UserId:        user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		Online:        user.Online,
+		EmailVerified: user.EmailVerified,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarMediaId: user.AvatarMediaID,
+		AvatarUrl:     user.AvatarURL,
+		TimeZone:      user.TimeZone,
+	}
 }
 
 func generateID() string {
@@ -181,19 +299,19 @@ func generateUserID(username string) string {
 	return hex.EncodeToString(hash[:16]) // Use first 16 bytes
 }
 
-func (s *server) generateJWT(userID string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
-
-	tokenString, err := token.SignedString(s.jwtSecret)
-	return tokenString, err
+func durationEnv(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using %s", name, v, def)
+	}
+	return def
 }
 
 func main() {
 	// Metrics registry and endpoint
-	prometheus.MustRegister(loginSuccess, loginFailure)
+	prometheus.MustRegister(loginSuccess, loginFailure, refreshReuse, loginThrottled, loginLockouts)
 	metricsAddr := os.Getenv("METRICS_ADDR_USERS")
 	if metricsAddr == "" {
 		metricsAddr = ":9091"
@@ -204,27 +322,128 @@ func main() {
 		_ = http.ListenAndServe(metricsAddr, nil)
 	}()
 
-	// JWT secret
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Println("WARNING: JWT_SECRET not set; generating ephemeral secret (not for production)")
-		buf := make([]byte, 32)
-		_, _ = rand.Read(buf)
-		secret = hex.EncodeToString(buf)
+	// Access token signing keys
+	keys, err := newKeyRing()
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
 	}
+	go keys.watch(context.Background(), durationEnv("JWT_KEYS_RELOAD_INTERVAL", time.Minute))
 
 	lis, err := net.Listen("tcp", ":50051")
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
+	// Connect to database. Only AUTH_DEV_MODE may fall back to the in-memory
+	// store: it loses every account on restart and differs between replicas
+	var store UserStore
+	var tokens TokenStore
+	var sessions SessionStore
+	var twoFactor TwoFactorStore
+	var identities IdentityStore
+	var throttle LoginThrottleStore
+	var resets PasswordResetStore
+	var verifications EmailVerificationStore
+	var contacts ContactStore
+	var blocks BlockStore
+	db, err := initDB()
+	if err != nil {
+		if os.Getenv("AUTH_DEV_MODE") != "true" {
+			log.Fatalf("Database connection failed: %v", err)
+		}
+		log.Printf("WARNING: database connection failed, AUTH_DEV_MODE set; running with in-memory user store (not for production): %v", err)
+		memory := newMemoryStore()
+		store, tokens, sessions, twoFactor, identities, throttle, resets = memory, memory, memory, memory, memory, memory, memory
+		verifications, contacts, blocks = memory, memory, memory
+	} else {
+		defer db.Close()
+		postgres := newPostgresStore(db)
+		store, tokens, sessions, twoFactor, identities, throttle, resets = postgres, postgres, postgres, postgres, postgres, postgres, postgres
+		verifications, contacts, blocks = postgres, postgres, postgres
+	}
+
+	// Revocations are pushed to gateways over NATS; they also poll
+	// ListRevokedTokens, so the service still works without it
+	natsURL := os.Getenv("NATS_URL")
+	if natsURL == "" {
+		natsURL = nats.DefaultURL
+	}
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		log.Printf("Failed to connect to NATS, revocations will not be pushed: %v", err)
+	} else {
+		defer nc.Close()
+	}
+
+	// Avatars are checked against media-service when a profile is updated
+	mediaURL := os.Getenv("MEDIA_SERVICE_GRPC_URL")
+	if mediaURL == "" {
+		mediaURL = "localhost:50055"
+	}
+	mediaConn, err := grpc.NewClient(mediaURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to media service: %v", err)
+	}
+	defer mediaConn.Close()
+
+	// Contact lists show whether each contact is online
+	presenceURL := os.Getenv("PRESENCE_SERVICE_URL")
+	if presenceURL == "" {
+		presenceURL = "localhost:50052"
+	}
+	presenceConn, err := grpc.NewClient(presenceURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to presence service: %v", err)
+	}
+	defer presenceConn.Close()
+
+	// Deleting an account erases its messages; exports include them
+	messageStoreURL := os.Getenv("MESSAGE_STORE_URL")
+	if messageStoreURL == "" {
+		messageStoreURL = "localhost:50054"
+	}
+	messageStoreConn, err := grpc.NewClient(messageStoreURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to message store service: %v", err)
+	}
+	defer messageStoreConn.Close()
+
 	s := grpc.NewServer()
 	userServer := &server{
-		users:     make(map[string]*User),
-		jwtSecret: []byte(secret),
+		store:         store,
+		tokens:        tokens,
+		sessions:      sessions,
+		twoFactor:     twoFactor,
+		identities:    identities,
+		throttle:      throttle,
+		resets:        resets,
+		verifications: verifications,
+		contacts:      contacts,
+		blocks:        blocks,
+		notifier:      newNotifier(),
+		totpIssuer:    "KubeChat",
+		natsConn:      nc,
+		media:         media.NewMediaServiceClient(mediaConn),
+		presence:      presence.NewPresenceServiceClient(presenceConn),
+		messageStore:  messagestore.NewMessageStoreServiceClient(messageStoreConn),
+		keys:          keys,
+		accessTTL:     durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		refreshTTL:    durationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+	}
+
+	userServer.accountThrottle, userServer.ipThrottle = loadThrottlePolicies()
+	userServer.passwordPolicy = loadPasswordPolicy()
+	userServer.resetTTL = durationEnv("PASSWORD_RESET_TTL", time.Hour)
+	userServer.resetURL = resetURLFromEnv()
+	userServer.emailPolicy = loadEmailPolicy()
+	userServer.verificationTTL = durationEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	userServer.verifyURL = verifyURLFromEnv()
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		userServer.totpIssuer = issuer
 	}
 
 	users.RegisterUsersServiceServer(s, userServer)
+	http.HandleFunc("/.well-known/jwks.json", userServer.handleJWKS)
 
 	log.Println("Users service listening on :50051")
 	if err := s.Serve(lis); err != nil {
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server backed by a fresh memory store, signing with
+// an ephemeral key. It has no NATS connection or downstream services.
+func newTestServer(t *testing.T) (*server, *memoryStore) {
+	t.Helper()
+	t.Setenv("AUTH_DEV_MODE", "true")
+	t.Setenv("JWT_SIGNING_KEYS_DIR", "")
+	keys, err := newKeyRing()
+	if err != nil {
+		t.Fatalf("newKeyRing: %v", err)
+	}
+
+	memory := newMemoryStore()
+	account, ip := loadThrottlePolicies()
+	return &server{
+		store:           memory,
+		tokens:          memory,
+		sessions:        memory,
+		twoFactor:       memory,
+		identities:      memory,
+		throttle:        memory,
+		resets:          memory,
+		verifications:   memory,
+		contacts:        memory,
+		blocks:          memory,
+		keys:            keys,
+		accessTTL:       15 * time.Minute,
+		refreshTTL:      time.Hour,
+		accountThrottle: account,
+		ipThrottle:      ip,
+	}, memory
+}
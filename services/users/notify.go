@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notification is a message to one user, such as a password reset link.
+type Notification struct {
+	To      string // Email address
+	Subject string
+	Body    string
+}
+
+// Notifier delivers notifications to users. Implementations must be safe for
+// concurrent use.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// newNotifier picks the notifier named by NOTIFIER: "smtp" sends mail through
+// SMTP_ADDR, anything else logs notifications, which is enough for local
+// development.
+func newNotifier() Notifier {
+	if os.Getenv("NOTIFIER") != "smtp" {
+		log.Println("Notifications are logged, not delivered; set NOTIFIER=smtp to send mail")
+		return logNotifier{}
+	}
+
+	notifier := &smtpNotifier{
+		addr: os.Getenv("SMTP_ADDR"),
+		from: os.Getenv("SMTP_FROM"),
+	}
+	if notifier.addr == "" {
+		notifier.addr = "localhost:1025"
+	}
+	if notifier.from == "" {
+		notifier.from = "KubeChat <no-reply@kubechat.local>"
+	}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		host, _, _ := net.SplitHostPort(notifier.addr)
+		notifier.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	log.Printf("Sending notifications through SMTP server %s", notifier.addr)
+	return notifier
+}
+
+// logNotifier writes notifications to the log instead of delivering them.
+type logNotifier struct{}
+
+func (logNotifier) Notify(ctx context.Context, n Notification) error {
+	log.Printf("NOTIFY to=%s subject=%q\n%s", n.To, n.Subject, n.Body)
+	return nil
+}
+
+// smtpNotifier sends notifications as plain text mail. Any SMTP server will
+// do; locally that is the mailpit container from docker-compose.
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth // nil for servers without authentication
+}
+
+func (s *smtpNotifier) Notify(ctx context.Context, n Notification) error {
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+	// Addresses come from user input; parsing also rules out header injection
+	to, err := mail.ParseAddress(n.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	if strings.ContainsAny(n.Subject, "\r\n") {
+		return fmt.Errorf("subject contains a line break")
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(s.addr, s.auth, from.Address, []string{to.Address}, []byte(msg.String()))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	users "kubechat/proto/users"
+)
+
+const maxUsernameLength = 32
+
+// LoginOIDC signs in a user authenticated by an external identity provider.
+// The gateway has already verified the ID token. Two-factor authentication is
+// left to the provider.
+func (s *server) LoginOIDC(ctx context.Context, req *users.LoginOIDCRequest) (*users.LoginUserResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if req.Issuer == "" || req.Subject == "" {
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Issuer and subject required",
+		}, nil
+	}
+
+	userID, err := s.identities.GetIdentityUser(ctx, req.Issuer, req.Subject)
+	if errors.Is(err, ErrIdentityNotFound) {
+		userID, err = s.linkOrProvision(ctx, req)
+	}
+	if errors.Is(err, errUnverifiedEmailAccount) {
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "An account with this email exists but its address is not verified; sign in with its password and verify the email first",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to resolve identity %s at %s: %v", req.Subject, req.Issuer, err)
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Failed to sign in",
+		}, err
+	}
+
+	if s.emailPolicy == emailPolicyLogin {
+		user, err := s.store.GetUserByID(ctx, userID)
+		if err != nil {
+			return &users.LoginUserResponse{
+				Success: false,
+				Message: "Failed to sign in",
+			}, err
+		}
+		if s.loginBlocked(user) {
+			return emailVerificationRequired(userID), nil
+		}
+	}
+
+	return s.completeLogin(ctx, userID, &users.LoginUserRequest{
+		DeviceName: req.DeviceName,
+		UserAgent:  req.UserAgent,
+		IpAddress:  req.IpAddress,
+	})
+}
+
+// errUnverifiedEmailAccount refuses to link an identity to an account whose
+// owner never proved control of the address. Anyone can register with an
+// address they don't own, then wait for its real owner to sign in with SSO.
+var errUnverifiedEmailAccount = errors.New("account with this email is not verified")
+
+// linkOrProvision attaches a new external identity to the user with the same
+// verified email, or creates a user without a local password.
+func (s *server) linkOrProvision(ctx context.Context, req *users.LoginOIDCRequest) (string, error) {
+	now := time.Now().UTC()
+
+	// Only an address the provider vouches for may claim an existing account
+	var email string
+	if req.EmailVerified {
+		email = normalizeEmail(req.Email)
+	}
+	if email != "" {
+		user, err := s.store.GetUserByEmail(ctx, email)
+		if err == nil {
+			if !user.EmailVerified {
+				log.Printf("Refusing to link identity %s at %s to user %s: email not verified", req.Subject, req.Issuer, user.ID)
+				return "", errUnverifiedEmailAccount
+			}
+			log.Printf("Linking identity %s at %s to user %s by email", req.Subject, req.Issuer, user.ID)
+			return user.ID, s.identities.LinkIdentity(ctx, req.Issuer, req.Subject, user.ID, now)
+		}
+		if !errors.Is(err, ErrUserNotFound) {
+			return "", err
+		}
+	}
+
+	base := usernameFromClaims(req)
+	for attempt := 0; attempt < 5; attempt++ {
+		username := base
+		if attempt > 0 {
+			username = truncate(base, maxUsernameLength-5) + "-" + generateID()[:4]
+		}
+
+		// An empty password hash never matches, so LoginUser refuses it
+		user := &User{
+			ID:            generateUserID(username),
+			Username:      username,
+			Email:         email,
+			EmailVerified: email != "",
+		}
+		err := s.store.CreateUser(ctx, user)
+		if errors.Is(err, ErrUsernameTaken) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		log.Printf("Provisioned user %s for identity %s at %s", user.ID, req.Subject, req.Issuer)
+		return user.ID, s.identities.LinkIdentity(ctx, req.Issuer, req.Subject, user.ID, now)
+	}
+	return "", ErrUsernameTaken
+}
+
+// usernameFromClaims picks a username from preferred_username or the email's
+// local part, keeping only characters that are safe in URLs and mentions.
+func usernameFromClaims(req *users.LoginOIDCRequest) string {
+	candidate := req.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(req.Email, "@")
+	}
+
+	username := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return -1
+	}, candidate)
+	if username == "" {
+		username = "user"
+	}
+	return truncate(username, maxUsernameLength)
+}
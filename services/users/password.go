@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+
+	users "kubechat/proto/users"
+)
+
+const (
+	// maxPasswordBytes is as much as bcrypt hashes; it refuses longer input.
+	maxPasswordBytes = 72
+	// maxResetRequests is how many reset messages one account is sent per
+	// hour, so the endpoint cannot be used to flood someone's inbox.
+	maxResetRequests = 3
+)
+
+// passwordPolicy is what new passwords must satisfy. Length matters most, so
+// character classes are only required when PASSWORD_MIN_CLASSES asks for them.
+type passwordPolicy struct {
+	minLength  int // In characters
+	minClasses int // Of lowercase, uppercase, digits and symbols
+}
+
+// loadPasswordPolicy reads PASSWORD_MIN_LENGTH and PASSWORD_MIN_CLASSES.
+func loadPasswordPolicy() passwordPolicy {
+	return passwordPolicy{
+		minLength:  intEnv("PASSWORD_MIN_LENGTH", 8),
+		minClasses: min(intEnv("PASSWORD_MIN_CLASSES", 1), 4),
+	}
+}
+
+// check returns why the password is refused, or "" if it is acceptable.
+func (p passwordPolicy) check(password string) string {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return fmt.Sprintf("Password must be at least %d characters", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Sprintf("Password must be at most %d bytes", maxPasswordBytes)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < p.minClasses {
+		return fmt.Sprintf("Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.minClasses)
+	}
+	return ""
+}
+
+// setPassword stores a new password and voids outstanding reset links.
+func (s *server) setPassword(ctx context.Context, userID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.store.UpdatePassword(ctx, userID, string(hashedPassword)); err != nil {
+		return err
+	}
+	if err := s.resets.DeletePasswordResetTokens(ctx, userID); err != nil {
+		log.Printf("Failed to delete reset tokens of user %s: %v", userID, err)
+	}
+	return nil
+}
+
+// revokeOtherSessions signs the user out everywhere but keepSessionID.
+func (s *server) revokeOtherSessions(ctx context.Context, userID, keepSessionID string) error {
+	now := time.Now().UTC()
+	if keepSessionID == "" {
+		revoked, err := s.tokens.RevokeUserSessions(ctx, userID, now)
+		if err != nil {
+			return err
+		}
+		s.publishRevocations(revoked, RevokedToken{UserID: userID})
+		return nil
+	}
+
+	// Listed first: the sessions' WebSocket connections are dropped by
+	// session, whether or not their access tokens are still live
+	sessions, err := s.sessions.ListSessions(ctx, userID, now)
+	if err != nil {
+		log.Printf("Failed to list sessions of user %s: %v", userID, err)
+	}
+	revoked, err := s.tokens.RevokeOtherSessions(ctx, userID, keepSessionID, now)
+	if err != nil {
+		return err
+	}
+
+	var scopes []RevokedToken
+	for _, session := range sessions {
+		if session.ID != keepSessionID {
+			scopes = append(scopes, RevokedToken{UserID: userID, SessionID: session.ID})
+		}
+	}
+	s.publishRevocations(revoked, scopes...)
+	return nil
+}
+
+// notify delivers in the background so responses do not depend on the mail
+// server, nor reveal through their timing whether a message was sent.
+func (s *server) notify(n Notification) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.notifier.Notify(ctx, n); err != nil {
+			log.Printf("Failed to send %q to %s: %v", n.Subject, n.To, err)
+		}
+	}()
+}
+
+// notifyPasswordChanged warns the owner in case it was not them.
+func (s *server) notifyPasswordChanged(user *User) {
+	if user.Email == "" {
+		return
+	}
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Your KubeChat password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your KubeChat account was just changed and your other devices were signed out.\n"+
+			"If this was not you, reset your password right away.\n", user.Username),
+	})
+}
+
+func (s *server) ChangePassword(ctx context.Context, req *users.ChangePasswordRequest) (*users.ChangePasswordResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	user, err := s.store.GetUserByID(ctx, req.UserId)
+	if errors.Is(err, ErrUserNotFound) {
+		return &users.ChangePasswordResponse{
+			Success: false,
+			Message: "User not found",
+		}, nil
+	}
+	if err != nil {
+		return &users.ChangePasswordResponse{
+			Success: false,
+			Message: "Failed to change password",
+		}, err
+	}
+
+	// Wrong current passwords count as failed logins, so a stolen access
+	// token cannot be used to guess the password
+	if wait := s.loginRetryAfter(ctx, user.Username, ""); wait > 0 {
+		return &users.ChangePasswordResponse{
+			Success: false,
+			Message: "Too many failed attempts; try again later",
+		}, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)) != nil {
+		s.recordLoginFailure(ctx, user.Username, "")
+		return &users.ChangePasswordResponse{
+			Success: false,
+			Message: "Current password is incorrect",
+		}, nil
+	}
+
+	if msg := s.passwordPolicy.check(req.NewPassword); msg != "" {
+		return &users.ChangePasswordResponse{
+			Success: false,
+			Message: msg,
+		}, nil
+	}
+
+	if err := s.setPassword(ctx, user.ID, req.NewPassword); err != nil {
+		log.Printf("Failed to change password of user %s: %v", user.ID, err)
+		return &users.ChangePasswordResponse{
+			Success: false,
+			Message: "Failed to change password",
+		}, err
+	}
+	if err := s.revokeOtherSessions(ctx, user.ID, req.SessionId); err != nil {
+		log.Printf("Failed to revoke other sessions of user %s: %v", user.ID, err)
+	}
+
+	s.publishAudit(auditEvent{
+		Type:      "password_changed",
+		Username:  user.Username,
+		Timestamp: time.Now().UTC(),
+	})
+	s.notifyPasswordChanged(user)
+
+	return &users.ChangePasswordResponse{
+		Success: true,
+		Message: "Password changed; other devices were signed out",
+	}, nil
+}
+
+// RequestPasswordReset mails a reset link to the account's address. It
+// answers the same whether or not the account exists.
+func (s *server) RequestPasswordReset(ctx context.Context, req *users.RequestPasswordResetRequest) (*users.RequestPasswordResetResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if req.Email == "" && req.Username == "" {
+		return &users.RequestPasswordResetResponse{
+			Success: false,
+			Message: "Email or username required",
+		}, nil
+	}
+	resp := &users.RequestPasswordResetResponse{
+		Success: true,
+		Message: "If the account exists, a reset link has been sent to its email address",
+	}
+
+	var user *User
+	var err error
+	if req.Email != "" {
+		user, err = s.store.GetUserByEmail(ctx, req.Email)
+	} else {
+		user, err = s.store.GetUserByUsername(ctx, req.Username)
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		return resp, nil
+	}
+	if err != nil {
+		log.Printf("Failed to look up user for password reset: %v", err)
+		return resp, nil
+	}
+	if user.Email == "" {
+		log.Printf("User %s has no email address to send a reset link to", user.ID)
+		return resp, nil
+	}
+
+	now := time.Now().UTC()
+	requests, err := s.throttle.RecordLoginFailure(ctx, "reset:"+user.ID, now, time.Hour)
+	if err != nil {
+		log.Printf("Failed to count reset requests of user %s: %v", user.ID, err)
+	} else if requests > maxResetRequests {
+		log.Printf("Not sending another reset link to user %s this hour", user.ID)
+		return resp, nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(buf)
+
+	err = s.resets.SavePasswordResetToken(ctx, &PasswordResetToken{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.resetTTL),
+	})
+	if err != nil {
+		log.Printf("Failed to save reset token of user %s: %v", user.ID, err)
+		return resp, nil
+	}
+
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Reset your KubeChat password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your KubeChat account. To choose a new one, open:\n\n%s%s\n\n"+
+			"The link works once and expires in %s. If you did not ask for it, ignore this message.\n",
+			user.Username, s.resetURL, token, s.resetTTL),
+	})
+	return resp, nil
+}
+
+// ResetPassword sets a new password with a token from RequestPasswordReset
+// and signs the account out everywhere.
+func (s *server) ResetPassword(ctx context.Context, req *users.ResetPasswordRequest) (*users.ResetPasswordResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Checked before the token is spent so a refused password can be retried
+	if msg := s.passwordPolicy.check(req.NewPassword); msg != "" {
+		return &users.ResetPasswordResponse{
+			Success: false,
+			Message: msg,
+		}, nil
+	}
+
+	token, err := s.resets.UsePasswordResetToken(ctx, hashToken(req.Token), time.Now().UTC())
+	if errors.Is(err, ErrResetTokenNotFound) {
+		return &users.ResetPasswordResponse{
+			Success: false,
+			Message: "Invalid or expired reset token",
+		}, nil
+	}
+	if err != nil {
+		return &users.ResetPasswordResponse{
+			Success: false,
+			Message: "Failed to reset password",
+		}, err
+	}
+
+	user, err := s.store.GetUserByID(ctx, token.UserID)
+	if err == nil {
+		err = s.setPassword(ctx, user.ID, req.NewPassword)
+	}
+	if err != nil {
+		log.Printf("Failed to reset password of user %s: %v", token.UserID, err)
+		return &users.ResetPasswordResponse{
+			Success: false,
+			Message: "Failed to reset password",
+		}, err
+	}
+
+	if err := s.revokeOtherSessions(ctx, user.ID, ""); err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", user.ID, err)
+	}
+	// Whoever was locked out by a guesser can sign in with the new password
+	if err := s.throttle.ClearLoginAttempts(ctx, accountKey(user.Username)); err != nil {
+		log.Printf("Failed to clear login attempts of user %s: %v", user.ID, err)
+	}
+
+	s.publishAudit(auditEvent{
+		Type:      "password_reset",
+		Username:  user.Username,
+		Timestamp: time.Now().UTC(),
+	})
+	s.notifyPasswordChanged(user)
+
+	return &users.ResetPasswordResponse{
+		Success: true,
+		Message: "Password reset; sign in with the new password",
+	}, nil
+}
+
+// resetURLFromEnv is where reset links point; the token is appended.
+func resetURLFromEnv() string {
+	if url := os.Getenv("PASSWORD_RESET_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080/#reset_token="
+}
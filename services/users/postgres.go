@@ -0,0 +1,1209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// userColumns are read by scanUser, in its order.
+const userColumns = `user_id, username, email, email_verified, password_hash, online,
+	display_name, bio, avatar_media_id, avatar_url, time_zone`
+
+type postgresStore struct {
+	db *sql.DB
+}
+
+func newPostgresStore(db *sql.DB) *postgresStore {
+	return &postgresStore{db: db}
+}
+
+func (p *postgresStore) CreateUser(ctx context.Context, user *User) error {
+	user.Email = normalizeEmail(user.Email)
+	query := `
+		INSERT INTO users (user_id, username, email, email_verified, password_hash, online, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := p.db.ExecContext(ctx, query,
+		user.ID,
+		user.Username,
+		user.Email,
+		user.EmailVerified,
+		user.Password,
+		user.Online,
+		time.Now(),
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			// unique_violation: the constraint name tells us which column collided
+			if pqErr.Constraint == "users_email_lower_key" {
+				return ErrEmailTaken
+			}
+			return ErrUsernameTaken
+		}
+		return err
+	}
+	return nil
+}
+
+func (p *postgresStore) GetUserByID(ctx context.Context, userID string) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE user_id = $1`
+	return p.scanUser(p.db.QueryRowContext(ctx, query, userID))
+}
+
+func (p *postgresStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE username = $1`
+	return p.scanUser(p.db.QueryRowContext(ctx, query, username))
+}
+
+func (p *postgresStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if email == "" {
+		return nil, ErrUserNotFound
+	}
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE lower(email) = $1`
+	return p.scanUser(p.db.QueryRowContext(ctx, query, normalizeEmail(email)))
+}
+
+func (p *postgresStore) SetOnline(ctx context.Context, userID string, online bool) error {
+	result, err := p.db.ExecContext(ctx, `UPDATE users SET online = $1 WHERE user_id = $2`, online, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	result, err := p.db.ExecContext(ctx, `UPDATE users SET password_hash = $1 WHERE user_id = $2`, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE users SET email_verified = TRUE WHERE user_id = $1 AND lower(email) = $2`, userID, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) UpdateProfile(ctx context.Context, userID string, profile Profile) error {
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE users
+		SET display_name = $2, bio = $3, avatar_media_id = $4, avatar_url = $5, time_zone = $6
+		WHERE user_id = $1`,
+		userID, profile.DisplayName, profile.Bio, profile.AvatarMediaID, profile.AvatarURL, profile.TimeZone)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) SearchUsers(ctx context.Context, query string, offset, limit int) ([]*User, error) {
+	query = strings.ToLower(query)
+	escaped := escapeLike(query)
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE lower(username) LIKE $1 OR lower(display_name) LIKE $1
+		ORDER BY
+			CASE
+				WHEN lower(username) = $2 THEN 0
+				WHEN lower(username) LIKE $3 THEN 1
+				WHEN lower(display_name) LIKE $3 OR lower(display_name) LIKE $4 THEN 2
+				ELSE 3
+			END,
+			username
+		LIMIT $5 OFFSET $6`,
+		"%"+escaped+"%", query, escaped+"%", "% "+escaped+"%", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+func (p *postgresStore) GetUsersByIDs(ctx context.Context, userIDs []string) ([]*User, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE user_id = ANY($1)`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+func (p *postgresStore) DeleteUser(ctx context.Context, userID string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var username string
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM users WHERE user_id = $1 RETURNING username`, userID).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		`DELETE FROM refresh_tokens WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM login_challenges WHERE user_id = $1`,
+		`DELETE FROM external_identities WHERE user_id = $1`,
+		`DELETE FROM login_attempts WHERE key IN ('reset:' || $1, 'verify:' || $1)`,
+		`DELETE FROM password_resets WHERE user_id = $1`,
+		`DELETE FROM email_verifications WHERE user_id = $1`,
+		`DELETE FROM contact_requests WHERE from_user_id = $1 OR to_user_id = $1`,
+		`DELETE FROM contacts WHERE user_id = $1 OR contact_id = $1`,
+		`DELETE FROM blocks WHERE user_id = $1 OR blocked_user_id = $1`,
+		`DELETE FROM mutes WHERE user_id = $1 OR muted_user_id = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, userID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM login_attempts WHERE key = $1`, accountKey(username)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// escapeLike makes LIKE treat the value literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func scanUsers(rows *sql.Rows) ([]*User, error) {
+	defer rows.Close()
+
+	var found []*User
+	for rows.Next() {
+		var user User
+		if err := scanUserColumns(rows, &user); err != nil {
+			return nil, err
+		}
+		found = append(found, &user)
+	}
+	return found, rows.Err()
+}
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUserColumns reads userColumns.
+func scanUserColumns(row rowScanner, user *User) error {
+	return row.Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Password, &user.Online,
+		&user.DisplayName, &user.Bio, &user.AvatarMediaID, &user.AvatarURL, &user.TimeZone)
+}
+
+func (p *postgresStore) scanUser(row *sql.Row) (*User, error) {
+	var user User
+	err := scanUserColumns(row, &user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (p *postgresStore) SaveRefreshToken(ctx context.Context, token *RefreshToken) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (token_hash, user_id, session_id, access_token_id, access_expires_at, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		token.TokenHash,
+		token.UserID,
+		token.SessionID,
+		token.AccessTokenID,
+		token.AccessExpiresAt,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	return err
+}
+
+func (p *postgresStore) UseRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*RefreshToken, error) {
+	const columns = `token_hash, user_id, session_id, access_token_id, access_expires_at, expires_at, created_at, used_at, revoked_at`
+
+	// The conditional update makes concurrent exchanges of one token race
+	// safely: exactly one of them wins
+	token, err := p.scanRefreshToken(p.db.QueryRowContext(ctx, `
+		UPDATE refresh_tokens SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL
+		RETURNING `+columns, tokenHash, now))
+	if !errors.Is(err, ErrRefreshTokenNotFound) {
+		return token, err
+	}
+
+	token, err = p.scanRefreshToken(p.db.QueryRowContext(ctx,
+		`SELECT `+columns+` FROM refresh_tokens WHERE token_hash = $1`, tokenHash))
+	if err != nil {
+		return nil, err
+	}
+	return token, ErrRefreshTokenReused
+}
+
+func (p *postgresStore) scanRefreshToken(row *sql.Row) (*RefreshToken, error) {
+	var token RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&token.TokenHash,
+		&token.UserID,
+		&token.SessionID,
+		&token.AccessTokenID,
+		&token.AccessExpiresAt,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&usedAt,
+		&revokedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.UsedAt = usedAt.Time
+	token.RevokedAt = revokedAt.Time
+	return &token, nil
+}
+
+func (p *postgresStore) RevokeSession(ctx context.Context, sessionID string, now time.Time) ([]RevokedToken, error) {
+	return p.revokeWhere(ctx, now, "session_id = $2", sessionID)
+}
+
+func (p *postgresStore) RevokeUserSessions(ctx context.Context, userID string, now time.Time) ([]RevokedToken, error) {
+	return p.revokeWhere(ctx, now, "user_id = $2", userID)
+}
+
+func (p *postgresStore) RevokeOtherSessions(ctx context.Context, userID, keepSessionID string, now time.Time) ([]RevokedToken, error) {
+	return p.revokeWhere(ctx, now, "user_id = $2 AND session_id <> $3", userID, keepSessionID)
+}
+
+// revokeWhere revokes sessions and refresh tokens matching condition and
+// denylists their access tokens. condition refers to args from $2 on, $1
+// being now; it is never user input.
+func (p *postgresStore) revokeWhere(ctx context.Context, now time.Time, condition string, args ...interface{}) ([]RevokedToken, error) {
+	args = append([]interface{}{now}, args...)
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = $1 WHERE `+condition+` AND revoked_at IS NULL`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = $1 WHERE `+condition+` AND revoked_at IS NULL`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO revoked_tokens (token_id, user_id, session_id, expires_at, revoked_at)
+		SELECT access_token_id, user_id, session_id, access_expires_at, $1
+		FROM refresh_tokens
+		WHERE `+condition+` AND access_expires_at > $1
+		ON CONFLICT (token_id) DO NOTHING
+		RETURNING token_id, user_id, session_id, expires_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := scanRevokedTokens(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are useless once the access token has expired anyway
+	if _, err := tx.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= $1`, now); err != nil {
+		return nil, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+func (p *postgresStore) ListRevokedTokens(ctx context.Context, now time.Time) ([]RevokedToken, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT token_id, user_id, session_id, expires_at
+		FROM revoked_tokens
+		WHERE expires_at > $1`, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanRevokedTokens(rows)
+}
+
+func scanRevokedTokens(rows *sql.Rows) ([]RevokedToken, error) {
+	defer rows.Close()
+
+	var revoked []RevokedToken
+	for rows.Next() {
+		var entry RevokedToken
+		if err := rows.Scan(&entry.TokenID, &entry.UserID, &entry.SessionID, &entry.ExpiresAt); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, entry)
+	}
+	return revoked, rows.Err()
+}
+
+func (p *postgresStore) CreateSession(ctx context.Context, session *Session) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO sessions (session_id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID,
+		session.UserID,
+		session.DeviceName,
+		session.UserAgent,
+		session.IPAddress,
+		session.CreatedAt,
+		session.LastUsedAt,
+		session.ExpiresAt,
+	)
+	return err
+}
+
+const sessionColumns = `session_id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at`
+
+func (p *postgresStore) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions WHERE session_id = $1`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := scanSessions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return sessions[0], nil
+}
+
+func (p *postgresStore) TouchSession(ctx context.Context, sessionID, ipAddress, userAgent string, now, expiresAt time.Time) error {
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE sessions
+		SET last_used_at = $2,
+			expires_at = $3,
+			ip_address = COALESCE(NULLIF($4, ''), ip_address),
+			user_agent = COALESCE(NULLIF($5, ''), user_agent)
+		WHERE session_id = $1`,
+		sessionID, now, expiresAt, ipAddress, userAgent)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) ListSessions(ctx context.Context, userID string, now time.Time) ([]*Session, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC`, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows)
+}
+
+func scanSessions(rows *sql.Rows) ([]*Session, error) {
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		var revokedAt sql.NullTime
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.DeviceName,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&revokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		session.RevokedAt = revokedAt.Time
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+func (p *postgresStore) SavePendingTOTP(ctx context.Context, totp *TOTP) error {
+	// An enabled secret is only replaced after DisableTOTP removes it
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO user_totp (user_id, secret, enabled, last_used_step, created_at)
+		VALUES ($1, $2, FALSE, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = EXCLUDED.created_at
+		WHERE user_totp.enabled = FALSE`,
+		totp.UserID, totp.Secret, totp.CreatedAt)
+	return err
+}
+
+func (p *postgresStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	var totp TOTP
+	err := p.db.QueryRowContext(ctx, `
+		SELECT user_id, secret, enabled, last_used_step, created_at
+		FROM user_totp WHERE user_id = $1`, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTOTPNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+func (p *postgresStore) EnableTOTP(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE user_totp SET enabled = TRUE WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTOTPNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *postgresStore) DisableTOTP(ctx context.Context, userID string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *postgresStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	// Conditional, so two logins racing with one code cannot both succeed
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+func (p *postgresStore) UseRecoveryCode(ctx context.Context, userID, codeHash string, now time.Time) error {
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) SaveLoginChallenge(ctx context.Context, challenge *LoginChallenge) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO login_challenges (token_hash, user_id, device_name, user_agent, ip_address, attempts, expires_at)
+		VALUES ($1, $2, $3, $4, $5, 0, $6)`,
+		challenge.TokenHash,
+		challenge.UserID,
+		challenge.DeviceName,
+		challenge.UserAgent,
+		challenge.IPAddress,
+		challenge.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Abandoned challenges are cleaned up as new ones arrive
+	_, err = p.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE expires_at <= $1`, time.Now().UTC())
+	return err
+}
+
+func (p *postgresStore) AttemptLoginChallenge(ctx context.Context, tokenHash string) (*LoginChallenge, error) {
+	var challenge LoginChallenge
+	err := p.db.QueryRowContext(ctx, `
+		UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1
+		RETURNING token_hash, user_id, device_name, user_agent, ip_address, attempts, expires_at`,
+		tokenHash).Scan(
+		&challenge.TokenHash,
+		&challenge.UserID,
+		&challenge.DeviceName,
+		&challenge.UserAgent,
+		&challenge.IPAddress,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (p *postgresStore) DeleteLoginChallenge(ctx context.Context, tokenHash string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+func (p *postgresStore) GetIdentityUser(ctx context.Context, issuer, subject string) (string, error) {
+	var userID string
+	err := p.db.QueryRowContext(ctx, `
+		SELECT user_id FROM external_identities
+		WHERE issuer = $1 AND subject = $2`, issuer, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrIdentityNotFound
+	}
+	return userID, err
+}
+
+func (p *postgresStore) LinkIdentity(ctx context.Context, issuer, subject, userID string, now time.Time) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO external_identities (issuer, subject, user_id, linked_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (issuer, subject) DO NOTHING`, issuer, subject, userID, now)
+	return err
+}
+
+func (p *postgresStore) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	attempts := LoginAttempts{Key: key}
+	var lockedUntil sql.NullTime
+	err := p.db.QueryRowContext(ctx, `
+		SELECT failures, last_failure, locked_until
+		FROM login_attempts WHERE key = $1`, key).Scan(
+		&attempts.Failures,
+		&attempts.LastFailure,
+		&lockedUntil,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &attempts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	attempts.LockedUntil = lockedUntil.Time
+	return &attempts, nil
+}
+
+func (p *postgresStore) RecordLoginFailure(ctx context.Context, key string, now time.Time, resetAfter time.Duration) (int, error) {
+	// One statement, so concurrent failures are all counted
+	var failures int
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts (key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE WHEN login_attempts.last_failure < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure = EXCLUDED.last_failure
+		RETURNING failures`, key, now, now.Add(-resetAfter)).Scan(&failures)
+	return failures, err
+}
+
+func (p *postgresStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := p.db.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`, key, until)
+	return err
+}
+
+func (p *postgresStore) ClearLoginAttempts(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
+
+func (p *postgresStore) SavePasswordResetToken(ctx context.Context, token *PasswordResetToken) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO password_resets (token_hash, user_id, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		token.TokenHash, token.UserID, token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+func (p *postgresStore) UsePasswordResetToken(ctx context.Context, tokenHash string, now time.Time) (*PasswordResetToken, error) {
+	token := PasswordResetToken{TokenHash: tokenHash, UsedAt: now}
+	err := p.db.QueryRowContext(ctx, `
+		UPDATE password_resets SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING user_id, created_at, expires_at`, tokenHash, now,
+	).Scan(&token.UserID, &token.CreatedAt, &token.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrResetTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (p *postgresStore) DeletePasswordResetTokens(ctx context.Context, userID string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = $1`, userID)
+	return err
+}
+
+func (p *postgresStore) SaveEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO email_verifications (token_hash, user_id, email, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		token.TokenHash, token.UserID, token.Email, token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+func (p *postgresStore) UseEmailVerificationToken(ctx context.Context, tokenHash string, now time.Time) (*EmailVerificationToken, error) {
+	token := EmailVerificationToken{TokenHash: tokenHash, UsedAt: now}
+	err := p.db.QueryRowContext(ctx, `
+		UPDATE email_verifications SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING user_id, email, created_at, expires_at`, tokenHash, now,
+	).Scan(&token.UserID, &token.Email, &token.CreatedAt, &token.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVerificationTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (p *postgresStore) CreateContactRequest(ctx context.Context, request *ContactRequest) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO contact_requests (request_id, from_user_id, to_user_id, created_at)
+		VALUES ($1, $2, $3, $4)`,
+		request.ID, request.FromUserID, request.ToUserID, request.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrContactRequestExists
+	}
+	return err
+}
+
+func (p *postgresStore) GetContactRequest(ctx context.Context, requestID string) (*ContactRequest, error) {
+	return scanContactRequest(p.db.QueryRowContext(ctx, `
+		SELECT request_id, from_user_id, to_user_id, created_at
+		FROM contact_requests WHERE request_id = $1`, requestID))
+}
+
+func (p *postgresStore) FindContactRequest(ctx context.Context, fromUserID, toUserID string) (*ContactRequest, error) {
+	return scanContactRequest(p.db.QueryRowContext(ctx, `
+		SELECT request_id, from_user_id, to_user_id, created_at
+		FROM contact_requests WHERE from_user_id = $1 AND to_user_id = $2`, fromUserID, toUserID))
+}
+
+func scanContactRequest(row *sql.Row) (*ContactRequest, error) {
+	var request ContactRequest
+	err := row.Scan(&request.ID, &request.FromUserID, &request.ToUserID, &request.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrContactRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (p *postgresStore) ListContactRequests(ctx context.Context, userID string) ([]*ContactRequest, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT request_id, from_user_id, to_user_id, created_at
+		FROM contact_requests WHERE from_user_id = $1 OR to_user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*ContactRequest
+	for rows.Next() {
+		var request ContactRequest
+		if err := rows.Scan(&request.ID, &request.FromUserID, &request.ToUserID, &request.CreatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &request)
+	}
+	return requests, rows.Err()
+}
+
+func (p *postgresStore) DeleteContactRequest(ctx context.Context, requestID string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM contact_requests WHERE request_id = $1`, requestID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrContactRequestNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) AcceptContactRequest(ctx context.Context, requestID string, now time.Time) (*ContactRequest, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Deleting first means only one of two concurrent accepts succeeds
+	var request ContactRequest
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM contact_requests WHERE request_id = $1
+		RETURNING request_id, from_user_id, to_user_id, created_at`, requestID,
+	).Scan(&request.ID, &request.FromUserID, &request.ToUserID, &request.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrContactRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO contacts (user_id, contact_id, created_at)
+		VALUES ($1, $2, $3), ($2, $1, $3)
+		ON CONFLICT (user_id, contact_id) DO NOTHING`,
+		request.FromUserID, request.ToUserID, now)
+	if err != nil {
+		return nil, err
+	}
+	return &request, tx.Commit()
+}
+
+func (p *postgresStore) ListContacts(ctx context.Context, userID string) ([]Contact, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT contact_id, created_at FROM contacts WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []Contact
+	for rows.Next() {
+		contact := Contact{UserID: userID}
+		if err := rows.Scan(&contact.ContactID, &contact.Since); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+func (p *postgresStore) AreContacts(ctx context.Context, userID, otherUserID string) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM contacts WHERE user_id = $1 AND contact_id = $2)`,
+		userID, otherUserID).Scan(&exists)
+	return exists, err
+}
+
+func (p *postgresStore) RemoveContact(ctx context.Context, userID, contactID string) error {
+	result, err := p.db.ExecContext(ctx, `
+		DELETE FROM contacts
+		WHERE (user_id = $1 AND contact_id = $2) OR (user_id = $2 AND contact_id = $1)`,
+		userID, contactID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrContactNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) AddBlock(ctx context.Context, userID, targetUserID string, now time.Time) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO blocks (user_id, blocked_user_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, blocked_user_id) DO NOTHING`, userID, targetUserID, now)
+	return err
+}
+
+func (p *postgresStore) RemoveBlock(ctx context.Context, userID, targetUserID string) error {
+	result, err := p.db.ExecContext(ctx,
+		`DELETE FROM blocks WHERE user_id = $1 AND blocked_user_id = $2`, userID, targetUserID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBlockNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) ListBlocked(ctx context.Context, userID string) ([]string, error) {
+	return p.queryIDs(ctx, `
+		SELECT blocked_user_id FROM blocks WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+}
+
+func (p *postgresStore) ListBlockedBy(ctx context.Context, userID string) ([]string, error) {
+	return p.queryIDs(ctx, `
+		SELECT user_id FROM blocks WHERE blocked_user_id = $1 ORDER BY created_at DESC`, userID)
+}
+
+func (p *postgresStore) IsBlocked(ctx context.Context, userID, targetUserID string) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM blocks WHERE user_id = $1 AND blocked_user_id = $2)`,
+		userID, targetUserID).Scan(&exists)
+	return exists, err
+}
+
+func (p *postgresStore) AddMute(ctx context.Context, userID, targetUserID string, now time.Time) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO mutes (user_id, muted_user_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, muted_user_id) DO NOTHING`, userID, targetUserID, now)
+	return err
+}
+
+func (p *postgresStore) RemoveMute(ctx context.Context, userID, targetUserID string) error {
+	result, err := p.db.ExecContext(ctx,
+		`DELETE FROM mutes WHERE user_id = $1 AND muted_user_id = $2`, userID, targetUserID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMuteNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) ListMuted(ctx context.Context, userID string) ([]string, error) {
+	return p.queryIDs(ctx, `
+		SELECT muted_user_id FROM mutes WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+}
+
+// queryIDs returns the single column of IDs the query selects.
+func (p *postgresStore) queryIDs(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func initDB() (*sql.DB, error) {
+	// Get database connection string from environment
+	dbHost := os.Getenv("DB_HOST")
+	if dbHost == "" {
+		dbHost = "postgres" // Docker service name
+	}
+
+	dbPort := os.Getenv("DB_PORT")
+	if dbPort == "" {
+		dbPort = "5432"
+	}
+
+	dbUser := os.Getenv("DB_USER")
+	if dbUser == "" {
+		dbUser = "user"
+	}
+
+	dbPassword := os.Getenv("DB_PASSWORD")
+	if dbPassword == "" {
+		dbPassword = "password"
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "kubechat"
+	}
+
+	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		dbUser, dbPassword, dbHost, dbPort, dbName)
+
+	log.Printf("Attempting to connect to database at %s:%s/%s", dbHost, dbPort, dbName)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		log.Printf("Failed to open database connection: %v", err)
+		return nil, err
+	}
+
+	// Test the connection
+	err = db.Ping()
+	if err != nil {
+		log.Printf("Failed to ping database: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrateDB(db); err != nil {
+		log.Printf("Failed to migrate users schema: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	log.Println("Successfully connected to database and created tables")
+	return db, nil
+}
+
+// migrateDB creates the users schema. Every statement is idempotent so it is
+// safe to run on each start.
+func migrateDB(db *sql.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS users (
+			id SERIAL PRIMARY KEY,
+			user_id VARCHAR(255) UNIQUE NOT NULL,
+			username VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			password_hash TEXT NOT NULL,
+			online BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (username)`,
+		// Accounts created before email verification start out unverified
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+		// Profile fields; empty until the user sets them
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(255) NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_media_id VARCHAR(255) NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64) NOT NULL DEFAULT ''`,
+		// Email is optional, so only non-empty addresses must be unique
+		// Addresses are unique regardless of case. Rows from before emails were
+		// normalized are matched through lower(email) too; if two of them
+		// differ only in case, this fails until one is changed by hand.
+		`CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email)) WHERE email <> ''`,
+		`DROP INDEX IF EXISTS users_email_key`,
+		// Refresh tokens are stored hashed; a session is one chain of rotations
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			session_id VARCHAR(255) NOT NULL,
+			access_token_id VARCHAR(255) NOT NULL,
+			access_expires_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS refresh_tokens_session_idx ON refresh_tokens (session_id)`,
+		`CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id)`,
+		// One row per login; revoking it revokes its refresh tokens too
+		`CREATE TABLE IF NOT EXISTS sessions (
+			session_id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			device_name VARCHAR(255) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id)`,
+		// TOTP secrets; a row with enabled = FALSE is an unconfirmed enrollment
+		`CREATE TABLE IF NOT EXISTS user_totp (
+			user_id VARCHAR(255) PRIMARY KEY,
+			secret VARCHAR(64) NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			last_used_step BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			user_id VARCHAR(255) NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP,
+			PRIMARY KEY (user_id, code_hash)
+		)`,
+		// Logins waiting for a second factor, by hashed challenge token
+		`CREATE TABLE IF NOT EXISTS login_challenges (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			device_name VARCHAR(255) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address VARCHAR(64) NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		// Accounts at external OpenID Connect providers linked to users
+		`CREATE TABLE IF NOT EXISTS external_identities (
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id VARCHAR(255) NOT NULL,
+			linked_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (issuer, subject)
+		)`,
+		`CREATE INDEX IF NOT EXISTS external_identities_user_idx ON external_identities (user_id)`,
+		// Failed logins per account ("account:<username>") and per IP address
+		// ("ip:<address>"), and password reset and verification mails sent
+		// ("reset:<user ID>", "verify:<user ID>")
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			key VARCHAR(320) PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure TIMESTAMP NOT NULL,
+			locked_until TIMESTAMP
+		)`,
+		// Password reset tokens, by hash; each works once
+		`CREATE TABLE IF NOT EXISTS password_resets (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets (user_id)`,
+		// Email verification tokens, by hash, for the address they were sent to
+		`CREATE TABLE IF NOT EXISTS email_verifications (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)`,
+		// Pending contact requests; answered ones are deleted
+		`CREATE TABLE IF NOT EXISTS contact_requests (
+			request_id VARCHAR(255) PRIMARY KEY,
+			from_user_id VARCHAR(255) NOT NULL,
+			to_user_id VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE (from_user_id, to_user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS contact_requests_to_idx ON contact_requests (to_user_id)`,
+		// Contacts are mutual and stored once per direction
+		`CREATE TABLE IF NOT EXISTS contacts (
+			user_id VARCHAR(255) NOT NULL,
+			contact_id VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, contact_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS blocks (
+			user_id VARCHAR(255) NOT NULL,
+			blocked_user_id VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, blocked_user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS blocks_blocked_idx ON blocks (blocked_user_id)`,
+		`CREATE TABLE IF NOT EXISTS mutes (
+			user_id VARCHAR(255) NOT NULL,
+			muted_user_id VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, muted_user_id)
+		)`,
+		// Access tokens (by jti) refused until they expire
+		`CREATE TABLE IF NOT EXISTS revoked_tokens (
+			token_id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			session_id VARCHAR(255) NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP NOT NULL
+		)`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
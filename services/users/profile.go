@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zones are validated without relying on the image
+	"unicode"
+	"unicode/utf8"
+
+	media "kubechat/proto/media"
+	users "kubechat/proto/users"
+)
+
+// profileSubject carries users.ProfileUpdatedEvent JSON so connected clients
+// can refresh names and avatars.
+const profileSubject = "users.profile"
+
+const (
+	maxDisplayNameLength = 64  // In characters
+	maxBioLength         = 500 // In characters
+	maxAvatarSize        = 5 << 20
+)
+
+// Profile is how a user presents themselves to others. Every field is
+// optional.
+type Profile struct {
+	DisplayName   string
+	Bio           string
+	AvatarMediaID string
+	AvatarURL     string // Resolved from AvatarMediaID when it is set
+	TimeZone      string // IANA name
+}
+
+// cleanText trims the value and drops control characters, keeping line
+// breaks only when multiline.
+func cleanText(value string, multiline bool) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\n' && multiline {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value))
+}
+
+// resolveAvatar checks that the media is an image of acceptable size and
+// returns its URL.
+func (s *server) resolveAvatar(ctx context.Context, mediaID string) (string, error) {
+	metadata, err := s.media.GetMetadata(ctx, &media.GetMetadataRequest{MediaId: mediaID})
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(metadata.MimeType, "image/") {
+		return "", fmt.Errorf("avatar must be an image, not %s", metadata.MimeType)
+	}
+	if metadata.Size > maxAvatarSize {
+		return "", fmt.Errorf("avatar must be at most %d MB", maxAvatarSize>>20)
+	}
+	return metadata.Url, nil
+}
+
+func (s *server) UpdateProfile(ctx context.Context, req *users.UpdateProfileRequest) (*users.UpdateProfileResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	user, err := s.store.GetUserByID(ctx, req.UserId)
+	if errors.Is(err, ErrUserNotFound) {
+		return &users.UpdateProfileResponse{
+			Success: false,
+			Message: "User not found",
+		}, nil
+	}
+	if err != nil {
+		return &users.UpdateProfileResponse{
+			Success: false,
+			Message: "Failed to update profile",
+		}, err
+	}
+
+	profile := user.Profile
+	if req.DisplayName != nil {
+		profile.DisplayName = cleanText(*req.DisplayName, false)
+		if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+			return &users.UpdateProfileResponse{
+				Success: false,
+				Message: fmt.Sprintf("Display name must be at most %d characters", maxDisplayNameLength),
+			}, nil
+		}
+	}
+	if req.Bio != nil {
+		profile.Bio = cleanText(*req.Bio, true)
+		if utf8.RuneCountInString(profile.Bio) > maxBioLength {
+			return &users.UpdateProfileResponse{
+				Success: false,
+				Message: fmt.Sprintf("Bio must be at most %d characters", maxBioLength),
+			}, nil
+		}
+	}
+	if req.TimeZone != nil {
+		profile.TimeZone = strings.TrimSpace(*req.TimeZone)
+		// LoadLocation also accepts "Local", which means nothing to others
+		if _, err := time.LoadLocation(profile.TimeZone); profile.TimeZone == "Local" || err != nil {
+			return &users.UpdateProfileResponse{
+				Success: false,
+				Message: "Unknown time zone; use an IANA name such as Europe/Berlin",
+			}, nil
+		}
+	}
+	if req.AvatarMediaId != nil && *req.AvatarMediaId != user.AvatarMediaID {
+		profile.AvatarMediaID, profile.AvatarURL = *req.AvatarMediaId, ""
+		if profile.AvatarMediaID != "" {
+			url, err := s.resolveAvatar(ctx, profile.AvatarMediaID)
+			if err != nil {
+				log.Printf("Refused avatar %s for user %s: %v", profile.AvatarMediaID, user.ID, err)
+				return &users.UpdateProfileResponse{
+					Success: false,
+					Message: "Avatar must be an uploaded image of at most 5 MB",
+				}, nil
+			}
+			profile.AvatarURL = url
+		}
+	}
+
+	if err := s.store.UpdateProfile(ctx, user.ID, profile); err != nil {
+		log.Printf("Failed to update profile of user %s: %v", user.ID, err)
+		return &users.UpdateProfileResponse{
+			Success: false,
+			Message: "Failed to update profile",
+		}, err
+	}
+
+	if profile.DisplayName != user.DisplayName || profile.AvatarURL != user.AvatarURL {
+		s.publishProfile(user.ID, user.Username, profile)
+	}
+	user.Profile = profile
+
+	return &users.UpdateProfileResponse{
+		Success: true,
+		Message: "Profile updated",
+		User:    toUserProto(user),
+	}, nil
+}
+
+func (s *server) publishProfile(userID, username string, profile Profile) {
+	if s.natsConn == nil {
+		return
+	}
+	data, err := json.Marshal(&users.ProfileUpdatedEvent{
+		UserId:      userID,
+		Username:    username,
+		DisplayName: profile.DisplayName,
+		AvatarUrl:   profile.AvatarURL,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal profile event: %v", err)
+		return
+	}
+	if err := s.natsConn.Publish(profileSubject, data); err != nil {
+		log.Printf("Failed to publish profile event: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	users "kubechat/proto/users"
+)
+
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 512
+)
+
+// startSession records a new login and issues its first pair of tokens.
+func (s *server) startSession(ctx context.Context, userID string, req *users.LoginUserRequest) (accessToken, refreshToken string, err error) {
+	now := time.Now().UTC()
+	session := &Session{
+		ID:         generateID(),
+		UserID:     userID,
+		DeviceName: truncate(req.DeviceName, maxDeviceNameLength),
+		UserAgent:  truncate(req.UserAgent, maxUserAgentLength),
+		IPAddress:  req.IpAddress,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.refreshTTL),
+	}
+	if err := s.sessions.CreateSession(ctx, session); err != nil {
+		return "", "", err
+	}
+	return s.issueTokens(ctx, userID, session.ID)
+}
+
+func (s *server) ListSessions(ctx context.Context, req *users.ListSessionsRequest) (*users.ListSessionsResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	sessions, err := s.sessions.ListSessions(ctx, req.UserId, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to list sessions of user %s: %v", req.UserId, err)
+		return nil, err
+	}
+
+	resp := &users.ListSessionsResponse{}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &users.Session{
+			SessionId:  session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			Current:    session.ID == req.CurrentSessionId,
+		})
+	}
+	return resp, nil
+}
+
+// RevokeSession signs one of the user's sessions out. Gateways drop its
+// WebSocket connections when the revocation is published.
+func (s *server) RevokeSession(ctx context.Context, req *users.RevokeSessionRequest) (*users.RevokeSessionResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	session, err := s.sessions.GetSession(ctx, req.SessionId)
+	// Another user's session is reported as missing so IDs cannot be probed
+	if errors.Is(err, ErrSessionNotFound) || (err == nil && session.UserID != req.UserId) {
+		return &users.RevokeSessionResponse{
+			Success: false,
+			Message: "Session not found",
+		}, nil
+	}
+	if err != nil {
+		return &users.RevokeSessionResponse{
+			Success: false,
+			Message: "Failed to revoke session",
+		}, err
+	}
+
+	if err := s.revokeSession(ctx, session.UserID, session.ID); err != nil {
+		return &users.RevokeSessionResponse{
+			Success: false,
+			Message: "Failed to revoke session",
+		}, err
+	}
+
+	return &users.RevokeSessionResponse{
+		Success: true,
+		Message: "Session revoked",
+	}, nil
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length]
+}
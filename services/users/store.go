@@ -0,0 +1,1180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUsernameTaken = errors.New("username already exists")
+	ErrEmailTaken    = errors.New("email already exists")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenReused   = errors.New("refresh token already used")
+	ErrSessionNotFound      = errors.New("session not found")
+
+	ErrTOTPNotFound         = errors.New("two-factor authentication not set up")
+	ErrTOTPCodeReused       = errors.New("TOTP code already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrChallengeNotFound    = errors.New("login challenge not found")
+
+	ErrIdentityNotFound = errors.New("external identity not linked")
+
+	ErrResetTokenNotFound        = errors.New("password reset token not found")
+	ErrVerificationTokenNotFound = errors.New("email verification token not found")
+
+	ErrContactRequestNotFound = errors.New("contact request not found")
+	ErrContactRequestExists   = errors.New("contact request already sent")
+	ErrContactNotFound        = errors.New("contact not found")
+
+	ErrBlockNotFound = errors.New("user not blocked")
+	ErrMuteNotFound  = errors.New("user not muted")
+)
+
+// UserStore persists user accounts. Implementations must be safe for
+// concurrent use. Emails are stored and looked up in their normalizeEmail
+// form, so CreateUser normalizes user.Email.
+type UserStore interface {
+	CreateUser(ctx context.Context, user *User) error
+	GetUserByID(ctx context.Context, userID string) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	SetOnline(ctx context.Context, userID string, online bool) error
+	UpdatePassword(ctx context.Context, userID, passwordHash string) error
+	// MarkEmailVerified verifies the user's address if it is still email,
+	// returning ErrUserNotFound otherwise.
+	MarkEmailVerified(ctx context.Context, userID, email string) error
+	UpdateProfile(ctx context.Context, userID string, profile Profile) error
+	// SearchUsers returns users whose username or display name contains
+	// query, ignoring case: exact usernames first, then username prefixes,
+	// then display names starting with it or with a word starting with it,
+	// then the rest, each by username.
+	SearchUsers(ctx context.Context, query string, offset, limit int) ([]*User, error)
+	// GetUsersByIDs returns the users that exist, in no particular order.
+	GetUsersByIDs(ctx context.Context, userIDs []string) ([]*User, error)
+	// DeleteUser removes the account and everything kept for it: sessions,
+	// refresh tokens, second factors, linked identities, pending links and
+	// throttles, and contacts, contact requests, blocks and mutes in either
+	// direction. Revoked access tokens are kept until they expire.
+	DeleteUser(ctx context.Context, userID string) error
+}
+
+// RefreshToken is one link in a session's rotation chain. Only its hash is
+// stored. Each refresh token records the access token issued alongside it so
+// that revoking the session can deny that access token too.
+type RefreshToken struct {
+	TokenHash       string
+	UserID          string
+	SessionID       string
+	AccessTokenID   string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+	CreatedAt       time.Time
+	UsedAt          time.Time // Zero until exchanged
+	RevokedAt       time.Time // Zero unless the session was revoked
+}
+
+// RevokedToken is an access token that must be refused until it expires.
+type RevokedToken struct {
+	TokenID   string
+	UserID    string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// Session is one login on one device. It lives as long as its refresh token
+// chain; LastUsedAt and ExpiresAt move forward on every refresh.
+type Session struct {
+	ID         string
+	UserID     string
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  time.Time // Zero unless revoked
+}
+
+// SessionStore persists sessions. Revoking them goes through TokenStore so
+// their tokens are revoked in the same step. Implementations must be safe for
+// concurrent use.
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, sessionID string) (*Session, error)
+	// TouchSession records a refresh. An empty ipAddress or userAgent keeps
+	// the previous value.
+	TouchSession(ctx context.Context, sessionID, ipAddress, userAgent string, now, expiresAt time.Time) error
+	// ListSessions returns the user's unrevoked, unexpired sessions, most
+	// recently used first.
+	ListSessions(ctx context.Context, userID string, now time.Time) ([]*Session, error)
+}
+
+// TOTP is a user's authenticator secret. It is pending until the first code
+// is confirmed, and only enforced once Enabled.
+type TOTP struct {
+	UserID       string
+	Secret       string // Base32
+	Enabled      bool
+	LastUsedStep int64 // Codes at or before this time step are refused
+	CreatedAt    time.Time
+}
+
+// LoginChallenge is a login that passed the password check and waits for a
+// second factor. Only the token hash is stored.
+type LoginChallenge struct {
+	TokenHash  string
+	UserID     string
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+	Attempts   int
+	ExpiresAt  time.Time
+}
+
+// TwoFactorStore persists TOTP secrets, recovery codes and pending login
+// challenges. Implementations must be safe for concurrent use.
+type TwoFactorStore interface {
+	// SavePendingTOTP replaces any unconfirmed secret of the user.
+	SavePendingTOTP(ctx context.Context, totp *TOTP) error
+	GetTOTP(ctx context.Context, userID string) (*TOTP, error)
+	// EnableTOTP enforces the secret and replaces the recovery codes.
+	EnableTOTP(ctx context.Context, userID string, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID string) error
+	// UseTOTPStep records a code's time step. It returns ErrTOTPCodeReused
+	// if that step or a later one was already used, so a code works once.
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string, now time.Time) error
+
+	SaveLoginChallenge(ctx context.Context, challenge *LoginChallenge) error
+	// AttemptLoginChallenge counts a verification attempt and returns the
+	// challenge with the new count.
+	AttemptLoginChallenge(ctx context.Context, tokenHash string) (*LoginChallenge, error)
+	DeleteLoginChallenge(ctx context.Context, tokenHash string) error
+}
+
+// IdentityStore links accounts at external identity providers, keyed by
+// issuer and subject, to users. Implementations must be safe for concurrent
+// use.
+type IdentityStore interface {
+	GetIdentityUser(ctx context.Context, issuer, subject string) (string, error)
+	LinkIdentity(ctx context.Context, issuer, subject, userID string, now time.Time) error
+}
+
+// LoginAttempts counts recent failed logins for one key: an account or an IP
+// address.
+type LoginAttempts struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time // Zero unless throttled
+}
+
+// LoginThrottleStore tracks failed logins. Implementations must be safe for
+// concurrent use.
+type LoginThrottleStore interface {
+	// GetLoginAttempts returns an empty entry for keys without failures.
+	GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+	// RecordLoginFailure counts a failure, starting over if the previous one
+	// is older than resetAfter, and returns the new count.
+	RecordLoginFailure(ctx context.Context, key string, now time.Time, resetAfter time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ClearLoginAttempts(ctx context.Context, key string) error
+}
+
+// PasswordResetToken lets the holder set a new password once before it
+// expires. Only its hash is stored.
+type PasswordResetToken struct {
+	TokenHash string
+	UserID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time // Zero until redeemed
+}
+
+// PasswordResetStore persists password reset tokens. Implementations must be
+// safe for concurrent use.
+type PasswordResetStore interface {
+	SavePasswordResetToken(ctx context.Context, token *PasswordResetToken) error
+	// UsePasswordResetToken marks the token used and returns it. Used and
+	// expired tokens are reported as ErrResetTokenNotFound.
+	UsePasswordResetToken(ctx context.Context, tokenHash string, now time.Time) (*PasswordResetToken, error)
+	// DeletePasswordResetTokens drops every outstanding token of the user.
+	DeletePasswordResetTokens(ctx context.Context, userID string) error
+}
+
+// EmailVerificationToken proves that whoever holds it received mail at Email.
+// Only its hash is stored.
+type EmailVerificationToken struct {
+	TokenHash string
+	UserID    string
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time // Zero until redeemed
+}
+
+// EmailVerificationStore persists email verification tokens. Implementations
+// must be safe for concurrent use.
+type EmailVerificationStore interface {
+	SaveEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error
+	// UseEmailVerificationToken marks the token used and returns it. Used and
+	// expired tokens are reported as ErrVerificationTokenNotFound.
+	UseEmailVerificationToken(ctx context.Context, tokenHash string, now time.Time) (*EmailVerificationToken, error)
+}
+
+// ContactRequest is a pending invitation from FromUserID to ToUserID.
+// Answered and cancelled requests are deleted.
+type ContactRequest struct {
+	ID         string
+	FromUserID string
+	ToUserID   string
+	CreatedAt  time.Time
+}
+
+// Contact is one side of a mutual contact relationship.
+type Contact struct {
+	UserID    string
+	ContactID string
+	Since     time.Time
+}
+
+// ContactStore persists contacts and the requests that lead to them.
+// Implementations must be safe for concurrent use.
+type ContactStore interface {
+	// CreateContactRequest returns ErrContactRequestExists if the same
+	// request is already pending.
+	CreateContactRequest(ctx context.Context, request *ContactRequest) error
+	GetContactRequest(ctx context.Context, requestID string) (*ContactRequest, error)
+	// FindContactRequest returns the pending request from one user to the
+	// other, or ErrContactRequestNotFound.
+	FindContactRequest(ctx context.Context, fromUserID, toUserID string) (*ContactRequest, error)
+	// ListContactRequests returns the requests the user sent or received,
+	// newest first.
+	ListContactRequests(ctx context.Context, userID string) ([]*ContactRequest, error)
+	DeleteContactRequest(ctx context.Context, requestID string) error
+	// AcceptContactRequest deletes the request and makes both users contacts
+	// of each other.
+	AcceptContactRequest(ctx context.Context, requestID string, now time.Time) (*ContactRequest, error)
+	ListContacts(ctx context.Context, userID string) ([]Contact, error)
+	AreContacts(ctx context.Context, userID, otherUserID string) (bool, error)
+	// RemoveContact ends the relationship for both users, returning
+	// ErrContactNotFound if there was none.
+	RemoveContact(ctx context.Context, userID, contactID string) error
+}
+
+// BlockStore persists who blocked or muted whom. Implementations must be safe
+// for concurrent use.
+type BlockStore interface {
+	// AddBlock and AddMute do nothing if the relation already exists
+	AddBlock(ctx context.Context, userID, targetUserID string, now time.Time) error
+	RemoveBlock(ctx context.Context, userID, targetUserID string) error
+	// ListBlocked returns whom the user blocked and ListBlockedBy who blocked
+	// the user, most recent first.
+	ListBlocked(ctx context.Context, userID string) ([]string, error)
+	ListBlockedBy(ctx context.Context, userID string) ([]string, error)
+	IsBlocked(ctx context.Context, userID, targetUserID string) (bool, error)
+	AddMute(ctx context.Context, userID, targetUserID string, now time.Time) error
+	RemoveMute(ctx context.Context, userID, targetUserID string) error
+	ListMuted(ctx context.Context, userID string) ([]string, error)
+}
+
+// TokenStore persists refresh tokens and the access token denylist.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	SaveRefreshToken(ctx context.Context, token *RefreshToken) error
+	// UseRefreshToken marks the token used and returns it. If it was already
+	// used it returns the token with ErrRefreshTokenReused.
+	UseRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*RefreshToken, error)
+	// RevokeSession marks the session revoked, revokes its refresh tokens and
+	// denylists its unexpired access tokens, which it returns.
+	RevokeSession(ctx context.Context, sessionID string, now time.Time) ([]RevokedToken, error)
+	RevokeUserSessions(ctx context.Context, userID string, now time.Time) ([]RevokedToken, error)
+	// RevokeOtherSessions revokes every session of the user but keepSessionID.
+	RevokeOtherSessions(ctx context.Context, userID, keepSessionID string, now time.Time) ([]RevokedToken, error)
+	ListRevokedTokens(ctx context.Context, now time.Time) ([]RevokedToken, error)
+}
+
+// memoryStore keeps users in process memory. It is used for tests and when
+// no database is available.
+type memoryStore struct {
+	mutex      sync.RWMutex
+	byID       map[string]*User
+	byUsername map[string]*User
+	byEmail    map[string]*User
+
+	refreshTokens map[string]*RefreshToken // by token hash
+	revoked       map[string]RevokedToken  // by token ID
+	sessions      map[string]*Session      // by session ID
+
+	totp          map[string]*TOTP                // by user ID
+	recoveryCodes map[string]map[string]time.Time // by user ID, then hash; zero until used
+	challenges    map[string]*LoginChallenge      // by token hash
+
+	identities    map[[2]string]string      // by issuer and subject
+	loginAttempts map[string]*LoginAttempts // by key
+
+	resetTokens        map[string]*PasswordResetToken     // by token hash
+	verificationTokens map[string]*EmailVerificationToken // by token hash
+
+	contactRequests map[string]*ContactRequest      // by request ID
+	contacts        map[string]map[string]time.Time // by user ID, then contact ID; when added
+
+	blocks map[string]map[string]time.Time // by blocker, then blocked user; when blocked
+	mutes  map[string]map[string]time.Time // by user, then muted user; when muted
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		byID:               make(map[string]*User),
+		byUsername:         make(map[string]*User),
+		byEmail:            make(map[string]*User),
+		refreshTokens:      make(map[string]*RefreshToken),
+		revoked:            make(map[string]RevokedToken),
+		sessions:           make(map[string]*Session),
+		totp:               make(map[string]*TOTP),
+		recoveryCodes:      make(map[string]map[string]time.Time),
+		challenges:         make(map[string]*LoginChallenge),
+		identities:         make(map[[2]string]string),
+		loginAttempts:      make(map[string]*LoginAttempts),
+		resetTokens:        make(map[string]*PasswordResetToken),
+		verificationTokens: make(map[string]*EmailVerificationToken),
+		contactRequests:    make(map[string]*ContactRequest),
+		contacts:           make(map[string]map[string]time.Time),
+		blocks:             make(map[string]map[string]time.Time),
+		mutes:              make(map[string]map[string]time.Time),
+	}
+}
+
+func (m *memoryStore) CreateUser(ctx context.Context, user *User) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.byUsername[user.Username]; exists {
+		return ErrUsernameTaken
+	}
+	user.Email = normalizeEmail(user.Email)
+	if user.Email != "" {
+		if _, exists := m.byEmail[user.Email]; exists {
+			return ErrEmailTaken
+		}
+	}
+
+	stored := *user
+	m.byID[user.ID] = &stored
+	m.byUsername[user.Username] = &stored
+	if user.Email != "" {
+		m.byEmail[user.Email] = &stored
+	}
+	return nil
+}
+
+func (m *memoryStore) GetUserByID(ctx context.Context, userID string) (*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	user, exists := m.byID[userID]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (m *memoryStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	user, exists := m.byUsername[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (m *memoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	email = normalizeEmail(email)
+	user, exists := m.byEmail[email]
+	if !exists || email == "" {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (m *memoryStore) SetOnline(ctx context.Context, userID string, online bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.byID[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+	user.Online = online
+	return nil
+}
+
+func (m *memoryStore) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.byID[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+	user.Password = passwordHash
+	return nil
+}
+
+func (m *memoryStore) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.byID[userID]
+	if !exists || user.Email != normalizeEmail(email) {
+		return ErrUserNotFound
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+func (m *memoryStore) UpdateProfile(ctx context.Context, userID string, profile Profile) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.byID[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+	user.Profile = profile
+	return nil
+}
+
+func (m *memoryStore) SearchUsers(ctx context.Context, query string, offset, limit int) ([]*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	query = strings.ToLower(query)
+	type match struct {
+		user *User
+		rank int
+	}
+	var matches []match
+	for _, user := range m.byID {
+		if rank := searchRank(user, query); rank >= 0 {
+			copied := *user
+			matches = append(matches, match{&copied, rank})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].user.Username < matches[j].user.Username
+	})
+
+	var found []*User
+	for i := offset; i < len(matches) && len(found) < limit; i++ {
+		found = append(found, matches[i].user)
+	}
+	return found, nil
+}
+
+// searchRank orders a match as SearchUsers describes, or returns -1.
+func searchRank(user *User, query string) int {
+	username := strings.ToLower(user.Username)
+	displayName := strings.ToLower(user.DisplayName)
+	switch {
+	case username == query:
+		return 0
+	case strings.HasPrefix(username, query):
+		return 1
+	case strings.HasPrefix(displayName, query), strings.Contains(displayName, " "+query):
+		return 2
+	case strings.Contains(username, query), strings.Contains(displayName, query):
+		return 3
+	}
+	return -1
+}
+
+func (m *memoryStore) GetUsersByIDs(ctx context.Context, userIDs []string) ([]*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var found []*User
+	for _, userID := range userIDs {
+		if user, exists := m.byID[userID]; exists {
+			copied := *user
+			found = append(found, &copied)
+		}
+	}
+	return found, nil
+}
+
+func (m *memoryStore) DeleteUser(ctx context.Context, userID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.byID[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+	delete(m.byID, userID)
+	delete(m.byUsername, user.Username)
+	if user.Email != "" {
+		delete(m.byEmail, user.Email)
+	}
+
+	for hash, token := range m.refreshTokens {
+		if token.UserID == userID {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	for sessionID, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, sessionID)
+		}
+	}
+	delete(m.totp, userID)
+	delete(m.recoveryCodes, userID)
+	for hash, challenge := range m.challenges {
+		if challenge.UserID == userID {
+			delete(m.challenges, hash)
+		}
+	}
+	for key, identityUserID := range m.identities {
+		if identityUserID == userID {
+			delete(m.identities, key)
+		}
+	}
+	delete(m.loginAttempts, accountKey(user.Username))
+	delete(m.loginAttempts, "reset:"+userID)
+	delete(m.loginAttempts, "verify:"+userID)
+	for hash, token := range m.resetTokens {
+		if token.UserID == userID {
+			delete(m.resetTokens, hash)
+		}
+	}
+	for hash, token := range m.verificationTokens {
+		if token.UserID == userID {
+			delete(m.verificationTokens, hash)
+		}
+	}
+
+	for requestID, request := range m.contactRequests {
+		if request.FromUserID == userID || request.ToUserID == userID {
+			delete(m.contactRequests, requestID)
+		}
+	}
+	for _, relations := range []map[string]map[string]time.Time{m.contacts, m.blocks, m.mutes} {
+		delete(relations, userID)
+		for _, targets := range relations {
+			delete(targets, userID)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) SaveRefreshToken(ctx context.Context, token *RefreshToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored := *token
+	m.refreshTokens[token.TokenHash] = &stored
+	return nil
+}
+
+func (m *memoryStore) UseRefreshToken(ctx context.Context, tokenHash string, now time.Time) (*RefreshToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, exists := m.refreshTokens[tokenHash]
+	if !exists {
+		return nil, ErrRefreshTokenNotFound
+	}
+	copied := *token
+	if !token.UsedAt.IsZero() {
+		return &copied, ErrRefreshTokenReused
+	}
+	token.UsedAt = now
+	return &copied, nil
+}
+
+func (m *memoryStore) RevokeSession(ctx context.Context, sessionID string, now time.Time) ([]RevokedToken, error) {
+	return m.revokeWhere(func(sessionUserID, tokenSessionID string) bool { return tokenSessionID == sessionID }, now), nil
+}
+
+func (m *memoryStore) RevokeUserSessions(ctx context.Context, userID string, now time.Time) ([]RevokedToken, error) {
+	return m.revokeWhere(func(sessionUserID, tokenSessionID string) bool { return sessionUserID == userID }, now), nil
+}
+
+func (m *memoryStore) RevokeOtherSessions(ctx context.Context, userID, keepSessionID string, now time.Time) ([]RevokedToken, error) {
+	return m.revokeWhere(func(sessionUserID, tokenSessionID string) bool {
+		return sessionUserID == userID && tokenSessionID != keepSessionID
+	}, now), nil
+}
+
+func (m *memoryStore) revokeWhere(match func(userID, sessionID string) bool, now time.Time) []RevokedToken {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, session := range m.sessions {
+		if match(session.UserID, session.ID) && session.RevokedAt.IsZero() {
+			session.RevokedAt = now
+		}
+	}
+
+	var revoked []RevokedToken
+	for _, token := range m.refreshTokens {
+		if !match(token.UserID, token.SessionID) {
+			continue
+		}
+		if token.RevokedAt.IsZero() {
+			token.RevokedAt = now
+		}
+		if token.AccessExpiresAt.After(now) {
+			entry := RevokedToken{
+				TokenID:   token.AccessTokenID,
+				UserID:    token.UserID,
+				SessionID: token.SessionID,
+				ExpiresAt: token.AccessExpiresAt,
+			}
+			m.revoked[entry.TokenID] = entry
+			revoked = append(revoked, entry)
+		}
+	}
+	return revoked
+}
+
+func (m *memoryStore) ListRevokedTokens(ctx context.Context, now time.Time) ([]RevokedToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var revoked []RevokedToken
+	for tokenID, entry := range m.revoked {
+		if !entry.ExpiresAt.After(now) {
+			delete(m.revoked, tokenID)
+			continue
+		}
+		revoked = append(revoked, entry)
+	}
+	return revoked, nil
+}
+
+func (m *memoryStore) CreateSession(ctx context.Context, session *Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored := *session
+	m.sessions[session.ID] = &stored
+	return nil
+}
+
+func (m *memoryStore) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, exists := m.sessions[sessionID]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (m *memoryStore) TouchSession(ctx context.Context, sessionID, ipAddress, userAgent string, now, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, exists := m.sessions[sessionID]
+	if !exists {
+		return ErrSessionNotFound
+	}
+	session.LastUsedAt = now
+	session.ExpiresAt = expiresAt
+	if ipAddress != "" {
+		session.IPAddress = ipAddress
+	}
+	if userAgent != "" {
+		session.UserAgent = userAgent
+	}
+	return nil
+}
+
+func (m *memoryStore) ListSessions(ctx context.Context, userID string, now time.Time) ([]*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var sessions []*Session
+	for _, session := range m.sessions {
+		if session.UserID != userID || !session.RevokedAt.IsZero() || !session.ExpiresAt.After(now) {
+			continue
+		}
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+func (m *memoryStore) SavePendingTOTP(ctx context.Context, totp *TOTP) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// An enabled secret is only replaced after DisableTOTP removes it
+	if existing, exists := m.totp[totp.UserID]; exists && existing.Enabled {
+		return nil
+	}
+	stored := *totp
+	stored.Enabled = false
+	m.totp[totp.UserID] = &stored
+	return nil
+}
+
+func (m *memoryStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	totp, exists := m.totp[userID]
+	if !exists {
+		return nil, ErrTOTPNotFound
+	}
+	copied := *totp
+	return &copied, nil
+}
+
+func (m *memoryStore) EnableTOTP(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	totp, exists := m.totp[userID]
+	if !exists {
+		return ErrTOTPNotFound
+	}
+	totp.Enabled = true
+
+	codes := make(map[string]time.Time, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = time.Time{}
+	}
+	m.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (m *memoryStore) DisableTOTP(ctx context.Context, userID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.totp, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+func (m *memoryStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	totp, exists := m.totp[userID]
+	if !exists {
+		return ErrTOTPNotFound
+	}
+	if step <= totp.LastUsedStep {
+		return ErrTOTPCodeReused
+	}
+	totp.LastUsedStep = step
+	return nil
+}
+
+func (m *memoryStore) UseRecoveryCode(ctx context.Context, userID, codeHash string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	usedAt, exists := m.recoveryCodes[userID][codeHash]
+	if !exists || !usedAt.IsZero() {
+		return ErrRecoveryCodeNotFound
+	}
+	m.recoveryCodes[userID][codeHash] = now
+	return nil
+}
+
+func (m *memoryStore) SaveLoginChallenge(ctx context.Context, challenge *LoginChallenge) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for hash, pending := range m.challenges {
+		if !pending.ExpiresAt.After(now) {
+			delete(m.challenges, hash)
+		}
+	}
+
+	stored := *challenge
+	m.challenges[challenge.TokenHash] = &stored
+	return nil
+}
+
+func (m *memoryStore) AttemptLoginChallenge(ctx context.Context, tokenHash string) (*LoginChallenge, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	challenge, exists := m.challenges[tokenHash]
+	if !exists {
+		return nil, ErrChallengeNotFound
+	}
+	challenge.Attempts++
+	copied := *challenge
+	return &copied, nil
+}
+
+func (m *memoryStore) DeleteLoginChallenge(ctx context.Context, tokenHash string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.challenges, tokenHash)
+	return nil
+}
+
+func (m *memoryStore) GetIdentityUser(ctx context.Context, issuer, subject string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	userID, exists := m.identities[[2]string{issuer, subject}]
+	if !exists {
+		return "", ErrIdentityNotFound
+	}
+	return userID, nil
+}
+
+func (m *memoryStore) LinkIdentity(ctx context.Context, issuer, subject, userID string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.identities[[2]string{issuer, subject}] = userID
+	return nil
+}
+
+func (m *memoryStore) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	attempts, exists := m.loginAttempts[key]
+	if !exists {
+		return &LoginAttempts{Key: key}, nil
+	}
+	copied := *attempts
+	return &copied, nil
+}
+
+func (m *memoryStore) RecordLoginFailure(ctx context.Context, key string, now time.Time, resetAfter time.Duration) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	attempts, exists := m.loginAttempts[key]
+	if !exists || now.Sub(attempts.LastFailure) > resetAfter {
+		attempts = &LoginAttempts{Key: key}
+		m.loginAttempts[key] = attempts
+	}
+	attempts.Failures++
+	attempts.LastFailure = now
+	return attempts.Failures, nil
+}
+
+func (m *memoryStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if attempts, exists := m.loginAttempts[key]; exists {
+		attempts.LockedUntil = until
+	}
+	return nil
+}
+
+func (m *memoryStore) ClearLoginAttempts(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.loginAttempts, key)
+	return nil
+}
+
+func (m *memoryStore) SavePasswordResetToken(ctx context.Context, token *PasswordResetToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored := *token
+	m.resetTokens[token.TokenHash] = &stored
+	return nil
+}
+
+func (m *memoryStore) UsePasswordResetToken(ctx context.Context, tokenHash string, now time.Time) (*PasswordResetToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, exists := m.resetTokens[tokenHash]
+	if !exists || !token.UsedAt.IsZero() || !token.ExpiresAt.After(now) {
+		return nil, ErrResetTokenNotFound
+	}
+	token.UsedAt = now
+	copied := *token
+	return &copied, nil
+}
+
+func (m *memoryStore) DeletePasswordResetTokens(ctx context.Context, userID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for tokenHash, token := range m.resetTokens {
+		if token.UserID == userID {
+			delete(m.resetTokens, tokenHash)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) SaveEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored := *token
+	m.verificationTokens[token.TokenHash] = &stored
+	return nil
+}
+
+func (m *memoryStore) UseEmailVerificationToken(ctx context.Context, tokenHash string, now time.Time) (*EmailVerificationToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, exists := m.verificationTokens[tokenHash]
+	if !exists || !token.UsedAt.IsZero() || !token.ExpiresAt.After(now) {
+		return nil, ErrVerificationTokenNotFound
+	}
+	token.UsedAt = now
+	copied := *token
+	return &copied, nil
+}
+
+func (m *memoryStore) CreateContactRequest(ctx context.Context, request *ContactRequest) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, pending := range m.contactRequests {
+		if pending.FromUserID == request.FromUserID && pending.ToUserID == request.ToUserID {
+			return ErrContactRequestExists
+		}
+	}
+	stored := *request
+	m.contactRequests[request.ID] = &stored
+	return nil
+}
+
+func (m *memoryStore) GetContactRequest(ctx context.Context, requestID string) (*ContactRequest, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	request, exists := m.contactRequests[requestID]
+	if !exists {
+		return nil, ErrContactRequestNotFound
+	}
+	copied := *request
+	return &copied, nil
+}
+
+func (m *memoryStore) FindContactRequest(ctx context.Context, fromUserID, toUserID string) (*ContactRequest, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, request := range m.contactRequests {
+		if request.FromUserID == fromUserID && request.ToUserID == toUserID {
+			copied := *request
+			return &copied, nil
+		}
+	}
+	return nil, ErrContactRequestNotFound
+}
+
+func (m *memoryStore) ListContactRequests(ctx context.Context, userID string) ([]*ContactRequest, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var requests []*ContactRequest
+	for _, request := range m.contactRequests {
+		if request.FromUserID == userID || request.ToUserID == userID {
+			copied := *request
+			requests = append(requests, &copied)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.After(requests[j].CreatedAt)
+	})
+	return requests, nil
+}
+
+func (m *memoryStore) DeleteContactRequest(ctx context.Context, requestID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.contactRequests[requestID]; !exists {
+		return ErrContactRequestNotFound
+	}
+	delete(m.contactRequests, requestID)
+	return nil
+}
+
+func (m *memoryStore) AcceptContactRequest(ctx context.Context, requestID string, now time.Time) (*ContactRequest, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	request, exists := m.contactRequests[requestID]
+	if !exists {
+		return nil, ErrContactRequestNotFound
+	}
+	delete(m.contactRequests, requestID)
+
+	for _, pair := range [][2]string{{request.FromUserID, request.ToUserID}, {request.ToUserID, request.FromUserID}} {
+		if m.contacts[pair[0]] == nil {
+			m.contacts[pair[0]] = make(map[string]time.Time)
+		}
+		if _, exists := m.contacts[pair[0]][pair[1]]; !exists {
+			m.contacts[pair[0]][pair[1]] = now
+		}
+	}
+	return request, nil
+}
+
+func (m *memoryStore) ListContacts(ctx context.Context, userID string) ([]Contact, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var contacts []Contact
+	for contactID, since := range m.contacts[userID] {
+		contacts = append(contacts, Contact{UserID: userID, ContactID: contactID, Since: since})
+	}
+	return contacts, nil
+}
+
+func (m *memoryStore) AreContacts(ctx context.Context, userID, otherUserID string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, exists := m.contacts[userID][otherUserID]
+	return exists, nil
+}
+
+func (m *memoryStore) RemoveContact(ctx context.Context, userID, contactID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.contacts[userID][contactID]; !exists {
+		return ErrContactNotFound
+	}
+	delete(m.contacts[userID], contactID)
+	delete(m.contacts[contactID], userID)
+	return nil
+}
+
+// addRelation records that userID blocked or muted targetUserID, keeping the
+// original time if it already did. Callers hold the write lock.
+func addRelation(relations map[string]map[string]time.Time, userID, targetUserID string, now time.Time) {
+	if relations[userID] == nil {
+		relations[userID] = make(map[string]time.Time)
+	}
+	if _, exists := relations[userID][targetUserID]; !exists {
+		relations[userID][targetUserID] = now
+	}
+}
+
+// relatedIDs returns the keys of targets, most recent first.
+func relatedIDs(targets map[string]time.Time) []string {
+	var userIDs []string
+	for userID := range targets {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		return targets[userIDs[i]].After(targets[userIDs[j]])
+	})
+	return userIDs
+}
+
+func (m *memoryStore) AddBlock(ctx context.Context, userID, targetUserID string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	addRelation(m.blocks, userID, targetUserID, now)
+	return nil
+}
+
+func (m *memoryStore) RemoveBlock(ctx context.Context, userID, targetUserID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.blocks[userID][targetUserID]; !exists {
+		return ErrBlockNotFound
+	}
+	delete(m.blocks[userID], targetUserID)
+	return nil
+}
+
+func (m *memoryStore) ListBlocked(ctx context.Context, userID string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return relatedIDs(m.blocks[userID]), nil
+}
+
+func (m *memoryStore) ListBlockedBy(ctx context.Context, userID string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	blockers := make(map[string]time.Time)
+	for blockerID, blocked := range m.blocks {
+		if blockedAt, exists := blocked[userID]; exists {
+			blockers[blockerID] = blockedAt
+		}
+	}
+	return relatedIDs(blockers), nil
+}
+
+func (m *memoryStore) IsBlocked(ctx context.Context, userID, targetUserID string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, exists := m.blocks[userID][targetUserID]
+	return exists, nil
+}
+
+func (m *memoryStore) AddMute(ctx context.Context, userID, targetUserID string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	addRelation(m.mutes, userID, targetUserID, now)
+	return nil
+}
+
+func (m *memoryStore) RemoveMute(ctx context.Context, userID, targetUserID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.mutes[userID][targetUserID]; !exists {
+		return ErrMuteNotFound
+	}
+	delete(m.mutes[userID], targetUserID)
+	return nil
+}
+
+func (m *memoryStore) ListMuted(ctx context.Context, userID string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return relatedIDs(m.mutes[userID]), nil
+}
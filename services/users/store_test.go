@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// storeUnderTest is everything users-service persists. The contract tests
+// below run against any implementation; postgresStore can be added to
+// testStores once a test database is available.
+type storeUnderTest interface {
+	UserStore
+	TokenStore
+	SessionStore
+	TwoFactorStore
+	LoginThrottleStore
+	PasswordResetStore
+	ContactStore
+	BlockStore
+}
+
+func testStores() map[string]func() storeUnderTest {
+	return map[string]func() storeUnderTest{
+		"memory": func() storeUnderTest { return newMemoryStore() },
+	}
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, store storeUnderTest)) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) { test(t, newStore()) })
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store storeUnderTest) {
+		ctx := context.Background()
+		alice := &User{ID: "u-alice", Username: "alice", Email: "alice@example.com"}
+		if err := store.CreateUser(ctx, alice); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+
+		if err := store.CreateUser(ctx, &User{ID: "u-2", Username: "alice"}); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("duplicate username: got %v, want ErrUsernameTaken", err)
+		}
+		if err := store.CreateUser(ctx, &User{ID: "u-3", Username: "alice2", Email: "alice@example.com"}); !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("duplicate email: got %v, want ErrEmailTaken", err)
+		}
+		// Emails are not
+		if err := store.CreateUser(ctx, &User{ID: "u-3", Username: "alice3", Email: "Alice@Example.com"}); !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("duplicate email with different case: got %v, want ErrEmailTaken", err)
+		}
+		// Usernames are case-sensitive
+		if err := store.CreateUser(ctx, &User{ID: "u-4", Username: "Alice"}); err != nil {
+			t.Errorf("CreateUser with different case: %v", err)
+		}
+		// Any number of users may have no email
+		if err := store.CreateUser(ctx, &User{ID: "u-5", Username: "bob"}); err != nil {
+			t.Errorf("CreateUser without email: %v", err)
+		}
+		if err := store.CreateUser(ctx, &User{ID: "u-6", Username: "carol"}); err != nil {
+			t.Errorf("second CreateUser without email: %v", err)
+		}
+
+		for name, get := range map[string]func() (*User, error){
+			"by ID":                        func() (*User, error) { return store.GetUserByID(ctx, "u-alice") },
+			"by username":                  func() (*User, error) { return store.GetUserByUsername(ctx, "alice") },
+			"by email":                     func() (*User, error) { return store.GetUserByEmail(ctx, "alice@example.com") },
+			"by email with different case": func() (*User, error) { return store.GetUserByEmail(ctx, "ALICE@example.com") },
+		} {
+			user, err := get()
+			if err != nil || user.ID != "u-alice" {
+				t.Errorf("get %s: got %v, %v", name, user, err)
+			}
+		}
+		if _, err := store.GetUserByID(ctx, "missing"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("missing user: got %v, want ErrUserNotFound", err)
+		}
+
+		if err := store.UpdatePassword(ctx, "u-alice", "new-hash"); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+		if err := store.MarkEmailVerified(ctx, "u-alice", "Alice@example.com"); err != nil {
+			t.Fatalf("MarkEmailVerified: %v", err)
+		}
+		user, _ := store.GetUserByUsername(ctx, "alice")
+		if user.Password != "new-hash" || !user.EmailVerified {
+			t.Errorf("after updates: password %q, verified %v", user.Password, user.EmailVerified)
+		}
+
+		if err := store.DeleteUser(ctx, "u-alice"); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.GetUserByUsername(ctx, "alice"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("deleted user: got %v, want ErrUserNotFound", err)
+		}
+		// The username and email are free again
+		if err := store.CreateUser(ctx, &User{ID: "u-7", Username: "alice", Email: "alice@example.com"}); err != nil {
+			t.Errorf("CreateUser after delete: %v", err)
+		}
+	})
+}
+
+func TestStoreRefreshTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store storeUnderTest) {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		session := &Session{ID: "s-1", UserID: "u-1", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := store.CreateSession(ctx, session); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		for i, hash := range []string{"h-1", "h-2"} {
+			err := store.SaveRefreshToken(ctx, &RefreshToken{
+				TokenHash:       hash,
+				UserID:          "u-1",
+				SessionID:       "s-1",
+				AccessTokenID:   "jti-" + hash,
+				AccessExpiresAt: now.Add(time.Duration(i+1) * time.Minute),
+				ExpiresAt:       now.Add(time.Hour),
+				CreatedAt:       now,
+			})
+			if err != nil {
+				t.Fatalf("SaveRefreshToken: %v", err)
+			}
+		}
+
+		token, err := store.UseRefreshToken(ctx, "h-1", now)
+		if err != nil || token.SessionID != "s-1" {
+			t.Fatalf("first use: got %v, %v", token, err)
+		}
+		// A second use reports reuse and still says which session it was
+		token, err = store.UseRefreshToken(ctx, "h-1", now)
+		if !errors.Is(err, ErrRefreshTokenReused) || token == nil || token.SessionID != "s-1" {
+			t.Errorf("second use: got %v, %v, want the token with ErrRefreshTokenReused", token, err)
+		}
+		if _, err := store.UseRefreshToken(ctx, "unknown", now); !errors.Is(err, ErrRefreshTokenNotFound) {
+			t.Errorf("unknown token: got %v, want ErrRefreshTokenNotFound", err)
+		}
+
+		revoked, err := store.RevokeSession(ctx, "s-1", now)
+		if err != nil || len(revoked) != 2 {
+			t.Fatalf("RevokeSession: got %d access tokens, %v", len(revoked), err)
+		}
+		got, err := store.GetSession(ctx, "s-1")
+		if err != nil || got.RevokedAt.IsZero() {
+			t.Errorf("session after revoke: got %v, %v", got, err)
+		}
+		sessions, _ := store.ListSessions(ctx, "u-1", now)
+		if len(sessions) != 0 {
+			t.Errorf("ListSessions after revoke: got %d sessions", len(sessions))
+		}
+
+		// Denylist entries disappear once the access token would have expired
+		denied, _ := store.ListRevokedTokens(ctx, now.Add(90*time.Second))
+		if len(denied) != 1 || denied[0].TokenID != "jti-h-2" {
+			t.Errorf("ListRevokedTokens: got %v, want only jti-h-2", denied)
+		}
+	})
+}
+
+func TestStoreTwoFactor(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store storeUnderTest) {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		if err := store.SavePendingTOTP(ctx, &TOTP{UserID: "u-1", Secret: "SECRET", CreatedAt: now}); err != nil {
+			t.Fatalf("SavePendingTOTP: %v", err)
+		}
+		if err := store.EnableTOTP(ctx, "u-1", []string{"code-a", "code-b"}); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+
+		if err := store.UseTOTPStep(ctx, "u-1", 100); err != nil {
+			t.Fatalf("UseTOTPStep: %v", err)
+		}
+		for _, step := range []int64{100, 99} {
+			if err := store.UseTOTPStep(ctx, "u-1", step); !errors.Is(err, ErrTOTPCodeReused) {
+				t.Errorf("step %d after 100: got %v, want ErrTOTPCodeReused", step, err)
+			}
+		}
+		if err := store.UseTOTPStep(ctx, "u-1", 101); err != nil {
+			t.Errorf("later step: %v", err)
+		}
+
+		if err := store.UseRecoveryCode(ctx, "u-1", "code-a", now); err != nil {
+			t.Fatalf("UseRecoveryCode: %v", err)
+		}
+		if err := store.UseRecoveryCode(ctx, "u-1", "code-a", now); !errors.Is(err, ErrRecoveryCodeNotFound) {
+			t.Errorf("reused recovery code: got %v, want ErrRecoveryCodeNotFound", err)
+		}
+		if err := store.UseRecoveryCode(ctx, "u-2", "code-b", now); !errors.Is(err, ErrRecoveryCodeNotFound) {
+			t.Errorf("another user's recovery code: got %v, want ErrRecoveryCodeNotFound", err)
+		}
+	})
+}
+
+func TestStoreLoginThrottle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store storeUnderTest) {
+		ctx := context.Background()
+		now := time.Now().UTC()
+
+		for i := 1; i <= 3; i++ {
+			failures, err := store.RecordLoginFailure(ctx, "account:alice", now, time.Hour)
+			if err != nil || failures != i {
+				t.Fatalf("failure %d: got %d, %v", i, failures, err)
+			}
+		}
+		// Failures older than resetAfter are forgotten
+		failures, _ := store.RecordLoginFailure(ctx, "account:alice", now.Add(2*time.Hour), time.Hour)
+		if failures != 1 {
+			t.Errorf("after reset window: got %d failures, want 1", failures)
+		}
+
+		until := now.Add(time.Minute)
+		if err := store.LockLogin(ctx, "account:alice", until); err != nil {
+			t.Fatalf("LockLogin: %v", err)
+		}
+		attempts, _ := store.GetLoginAttempts(ctx, "account:alice")
+		if !attempts.LockedUntil.Equal(until) {
+			t.Errorf("LockedUntil: got %v, want %v", attempts.LockedUntil, until)
+		}
+		if err := store.ClearLoginAttempts(ctx, "account:alice"); err != nil {
+			t.Fatalf("ClearLoginAttempts: %v", err)
+		}
+		attempts, _ = store.GetLoginAttempts(ctx, "account:alice")
+		if attempts.Failures != 0 || !attempts.LockedUntil.IsZero() {
+			t.Errorf("after clear: got %+v", attempts)
+		}
+	})
+}
+
+func TestStorePasswordResetTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store storeUnderTest) {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		for hash, expiresAt := range map[string]time.Time{"fresh": now.Add(time.Hour), "stale": now.Add(-time.Minute)} {
+			if err := store.SavePasswordResetToken(ctx, &PasswordResetToken{TokenHash: hash, UserID: "u-1", CreatedAt: now, ExpiresAt: expiresAt}); err != nil {
+				t.Fatalf("SavePasswordResetToken: %v", err)
+			}
+		}
+
+		if _, err := store.UsePasswordResetToken(ctx, "fresh", now); err != nil {
+			t.Fatalf("UsePasswordResetToken: %v", err)
+		}
+		if _, err := store.UsePasswordResetToken(ctx, "fresh", now); !errors.Is(err, ErrResetTokenNotFound) {
+			t.Errorf("used token: got %v, want ErrResetTokenNotFound", err)
+		}
+		if _, err := store.UsePasswordResetToken(ctx, "stale", now); !errors.Is(err, ErrResetTokenNotFound) {
+			t.Errorf("expired token: got %v, want ErrResetTokenNotFound", err)
+		}
+	})
+}
+
+func TestStoreContactsAndBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store storeUnderTest) {
+		ctx := context.Background()
+		now := time.Now().UTC()
+		request := &ContactRequest{ID: "r-1", FromUserID: "u-1", ToUserID: "u-2", CreatedAt: now}
+		if err := store.CreateContactRequest(ctx, request); err != nil {
+			t.Fatalf("CreateContactRequest: %v", err)
+		}
+		if err := store.CreateContactRequest(ctx, &ContactRequest{ID: "r-2", FromUserID: "u-1", ToUserID: "u-2", CreatedAt: now}); !errors.Is(err, ErrContactRequestExists) {
+			t.Errorf("duplicate request: got %v, want ErrContactRequestExists", err)
+		}
+
+		if _, err := store.AcceptContactRequest(ctx, "r-1", now); err != nil {
+			t.Fatalf("AcceptContactRequest: %v", err)
+		}
+		for _, pair := range [][2]string{{"u-1", "u-2"}, {"u-2", "u-1"}} {
+			if ok, _ := store.AreContacts(ctx, pair[0], pair[1]); !ok {
+				t.Errorf("AreContacts(%s, %s) = false after accepting", pair[0], pair[1])
+			}
+		}
+		if _, err := store.GetContactRequest(ctx, "r-1"); !errors.Is(err, ErrContactRequestNotFound) {
+			t.Errorf("accepted request: got %v, want ErrContactRequestNotFound", err)
+		}
+		if err := store.RemoveContact(ctx, "u-2", "u-1"); err != nil {
+			t.Fatalf("RemoveContact: %v", err)
+		}
+		if ok, _ := store.AreContacts(ctx, "u-1", "u-2"); ok {
+			t.Error("still contacts after RemoveContact")
+		}
+
+		if err := store.AddBlock(ctx, "u-1", "u-2", now); err != nil {
+			t.Fatalf("AddBlock: %v", err)
+		}
+		if err := store.AddBlock(ctx, "u-1", "u-2", now); err != nil {
+			t.Errorf("repeated AddBlock: %v", err)
+		}
+		if ok, _ := store.IsBlocked(ctx, "u-1", "u-2"); !ok {
+			t.Error("IsBlocked = false after AddBlock")
+		}
+		if ok, _ := store.IsBlocked(ctx, "u-2", "u-1"); ok {
+			t.Error("blocks are one-way, but IsBlocked(u-2, u-1) = true")
+		}
+		blockedBy, _ := store.ListBlockedBy(ctx, "u-2")
+		if len(blockedBy) != 1 || blockedBy[0] != "u-1" {
+			t.Errorf("ListBlockedBy: got %v", blockedBy)
+		}
+		if err := store.RemoveBlock(ctx, "u-1", "u-2"); err != nil {
+			t.Fatalf("RemoveBlock: %v", err)
+		}
+		if err := store.RemoveBlock(ctx, "u-1", "u-2"); !errors.Is(err, ErrBlockNotFound) {
+			t.Errorf("removing a missing block: got %v, want ErrBlockNotFound", err)
+		}
+	})
+}
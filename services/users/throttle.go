@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/bcrypt"
+
+	users "kubechat/proto/users"
+)
+
+// auditSubject carries security events such as lockouts as auditEvent JSON.
+const auditSubject = "audit.auth"
+
+// throttlePolicy slows down password guessing against one key. From
+// backoffAfter failures on, each failure blocks further attempts for a delay
+// that doubles up to maxBackoff; at lockoutAfter the key is locked for
+// lockoutDuration. Failures are forgotten resetAfter the last one.
+type throttlePolicy struct {
+	scope           string // account or ip, for metrics and audit events
+	backoffAfter    int
+	lockoutAfter    int
+	maxBackoff      time.Duration
+	lockoutDuration time.Duration
+	resetAfter      time.Duration
+}
+
+// loadThrottlePolicies reads LOGIN_LOCKOUT_THRESHOLD, LOGIN_IP_LOCKOUT_THRESHOLD
+// and LOGIN_LOCKOUT_DURATION. An IP address may be shared by many users behind
+// NAT, so it tolerates more failures than one account.
+func loadThrottlePolicies() (account, ip throttlePolicy) {
+	lockoutDuration := durationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	account = throttlePolicy{
+		scope:           "account",
+		backoffAfter:    3,
+		lockoutAfter:    intEnv("LOGIN_LOCKOUT_THRESHOLD", 10),
+		maxBackoff:      time.Minute,
+		lockoutDuration: lockoutDuration,
+		resetAfter:      time.Hour,
+	}
+	ip = throttlePolicy{
+		scope:           "ip",
+		backoffAfter:    20,
+		lockoutAfter:    intEnv("LOGIN_IP_LOCKOUT_THRESHOLD", 100),
+		maxBackoff:      time.Minute,
+		lockoutDuration: lockoutDuration,
+		resetAfter:      time.Hour,
+	}
+	return account, ip
+}
+
+func intEnv(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using %d", name, v, def)
+	}
+	return def
+}
+
+// delayAfter is how long the key is blocked after its n-th failure.
+func (p throttlePolicy) delayAfter(failures int) time.Duration {
+	switch {
+	case failures >= p.lockoutAfter:
+		return p.lockoutDuration
+	case failures >= p.backoffAfter:
+		// time.Second<<n overflows from n = 34; any cap is reached long before
+		if n := failures - p.backoffAfter; n < 30 {
+			return min(time.Second<<n, p.maxBackoff)
+		}
+		return p.maxBackoff
+	}
+	return 0
+}
+
+var (
+	loginThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "users_login_throttled_total",
+		Help: "Login attempts refused because the account or IP address was throttled",
+	}, []string{"scope"})
+	loginLockouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "users_login_lockouts_total",
+		Help: "Accounts and IP addresses locked after too many failed logins",
+	}, []string{"scope"})
+)
+
+// dummyPasswordHash is compared against when the username does not exist, so
+// the response time does not reveal which usernames are registered.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("kubechat-dummy-password"), bcrypt.DefaultCost)
+
+// Accounts are keyed by username rather than user ID so that unknown
+// usernames are throttled exactly like real ones. Usernames are case-sensitive,
+// so the key is too.
+func accountKey(username string) string { return "account:" + username }
+func ipKey(ip string) string            { return "ip:" + ip }
+
+// auditEvent is published on auditSubject.
+type auditEvent struct {
+	Type        string     `json:"type"` // login_lockout, login_unlock, password_changed, password_reset or account_deleted
+	Scope       string     `json:"scope,omitempty"`
+	Username    string     `json:"username,omitempty"`
+	IPAddress   string     `json:"ip_address,omitempty"`
+	Failures    int        `json:"failures,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+}
+
+func (s *server) publishAudit(event auditEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal audit event: %v", err)
+		return
+	}
+	log.Printf("AUDIT %s", data)
+	if s.natsConn == nil {
+		return
+	}
+	if err := s.natsConn.Publish(auditSubject, data); err != nil {
+		log.Printf("Failed to publish audit event: %v", err)
+	}
+}
+
+// loginRetryAfter reports how long the username or IP address must wait
+// before trying again. Store errors let the attempt through.
+func (s *server) loginRetryAfter(ctx context.Context, username, ip string) time.Duration {
+	keys := map[string]string{accountKey(username): s.accountThrottle.scope}
+	if ip != "" {
+		keys[ipKey(ip)] = s.ipThrottle.scope
+	}
+
+	now := time.Now().UTC()
+	var wait time.Duration
+	for key, scope := range keys {
+		attempts, err := s.throttle.GetLoginAttempts(ctx, key)
+		if err != nil {
+			log.Printf("Failed to check login attempts for %s: %v", key, err)
+			continue
+		}
+		if remaining := attempts.LockedUntil.Sub(now); remaining > 0 {
+			loginThrottled.WithLabelValues(scope).Inc()
+			wait = max(wait, remaining)
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed password or second factor against the
+// account and the IP address, throttling either as its policy says.
+func (s *server) recordLoginFailure(ctx context.Context, username, ip string) {
+	loginFailure.Inc()
+	now := time.Now().UTC()
+
+	s.applyThrottle(ctx, s.accountThrottle, accountKey(username), username, ip, now)
+	if ip != "" {
+		s.applyThrottle(ctx, s.ipThrottle, ipKey(ip), username, ip, now)
+	}
+}
+
+func (s *server) applyThrottle(ctx context.Context, policy throttlePolicy, key, username, ip string, now time.Time) {
+	failures, err := s.throttle.RecordLoginFailure(ctx, key, now, policy.resetAfter)
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", key, err)
+		return
+	}
+
+	delay := policy.delayAfter(failures)
+	if delay == 0 {
+		return
+	}
+	if err := s.throttle.LockLogin(ctx, key, now.Add(delay)); err != nil {
+		log.Printf("Failed to throttle %s: %v", key, err)
+		return
+	}
+
+	if failures >= policy.lockoutAfter {
+		loginLockouts.WithLabelValues(policy.scope).Inc()
+		lockedUntil := now.Add(delay)
+		event := auditEvent{
+			Type:        "login_lockout",
+			Scope:       policy.scope,
+			IPAddress:   ip,
+			Failures:    failures,
+			LockedUntil: &lockedUntil,
+			Timestamp:   now,
+		}
+		if policy.scope == s.accountThrottle.scope {
+			event.Username = username
+		}
+		s.publishAudit(event)
+	}
+}
+
+// throttledResponse refuses a login while the caller is throttled.
+func throttledResponse(wait time.Duration) *users.LoginUserResponse {
+	return &users.LoginUserResponse{
+		Success:    false,
+		Message:    "Too many failed attempts; try again later",
+		RetryAfter: int64((wait + time.Second - 1) / time.Second),
+	}
+}
+
+// invalidCredentials is the one failure message for an unknown username or
+// a wrong password.
+func invalidCredentials() *users.LoginUserResponse {
+	return &users.LoginUserResponse{
+		Success: false,
+		Message: "Invalid username or password",
+	}
+}
+
+func (s *server) UnlockAccount(ctx context.Context, req *users.UnlockAccountRequest) (*users.UnlockAccountResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if req.Username == "" && req.IpAddress == "" {
+		return &users.UnlockAccountResponse{
+			Success: false,
+			Message: "Username or IP address required",
+		}, nil
+	}
+
+	if req.Username != "" {
+		if err := s.throttle.ClearLoginAttempts(ctx, accountKey(req.Username)); err != nil {
+			return &users.UnlockAccountResponse{
+				Success: false,
+				Message: "Failed to unlock",
+			}, err
+		}
+	}
+	if req.IpAddress != "" {
+		if err := s.throttle.ClearLoginAttempts(ctx, ipKey(req.IpAddress)); err != nil {
+			return &users.UnlockAccountResponse{
+				Success: false,
+				Message: "Failed to unlock",
+			}, err
+		}
+	}
+
+	s.publishAudit(auditEvent{
+		Type:      "login_unlock",
+		Username:  req.Username,
+		IPAddress: req.IpAddress,
+		Timestamp: time.Now().UTC(),
+	})
+
+	return &users.UnlockAccountResponse{
+		Success: true,
+		Message: "Unlocked",
+	}, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelayAfter(t *testing.T) {
+	account := throttlePolicy{
+		scope:           "account",
+		backoffAfter:    3,
+		lockoutAfter:    10,
+		maxBackoff:      time.Minute,
+		lockoutDuration: 15 * time.Minute,
+	}
+	ip := throttlePolicy{
+		scope:           "ip",
+		backoffAfter:    20,
+		lockoutAfter:    100,
+		maxBackoff:      time.Minute,
+		lockoutDuration: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		policy   throttlePolicy
+		failures int
+		want     time.Duration
+	}{
+		{account, 1, 0},
+		{account, 2, 0},
+		{account, 3, time.Second},
+		{account, 4, 2 * time.Second},
+		{account, 8, 32 * time.Second},
+		{account, 9, time.Minute},
+		{account, 10, 15 * time.Minute},
+		{account, 50, 15 * time.Minute},
+		{ip, 19, 0},
+		{ip, 20, time.Second},
+		{ip, 26, time.Minute},
+		{ip, 53, time.Minute},
+		{ip, 54, time.Minute},
+		{ip, 99, time.Minute},
+		{ip, 100, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.delayAfter(tt.failures); got != tt.want {
+			t.Errorf("%s policy: delayAfter(%d) = %v, want %v", tt.policy.scope, tt.failures, got, tt.want)
+		}
+	}
+}
+
+// Every count below the lockout must keep the key blocked, and the delay may
+// never shrink as failures grow, whatever the thresholds are set to.
+func TestDelayAfterNeverDecreases(t *testing.T) {
+	for _, lockoutAfter := range []int{10, 40, 100, 1000} {
+		policy := throttlePolicy{
+			backoffAfter:    3,
+			lockoutAfter:    lockoutAfter,
+			maxBackoff:      time.Minute,
+			lockoutDuration: 15 * time.Minute,
+		}
+		var previous time.Duration
+		for failures := policy.backoffAfter; failures <= lockoutAfter; failures++ {
+			delay := policy.delayAfter(failures)
+			if delay <= 0 || delay < previous {
+				t.Fatalf("lockoutAfter %d: delayAfter(%d) = %v after %v", lockoutAfter, failures, delay, previous)
+			}
+			previous = delay
+		}
+	}
+}
+
+func TestApplyThrottle(t *testing.T) {
+	s, memory := newTestServer(t)
+	ctx := context.Background()
+	s.accountThrottle = throttlePolicy{
+		scope:           "account",
+		backoffAfter:    3,
+		lockoutAfter:    5,
+		maxBackoff:      time.Minute,
+		lockoutDuration: 15 * time.Minute,
+		resetAfter:      time.Hour,
+	}
+	now := time.Now().UTC()
+	key := accountKey("alice")
+
+	for failures, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 15 * time.Minute} {
+		s.applyThrottle(ctx, s.accountThrottle, key, "alice", "", now)
+		attempts, _ := memory.GetLoginAttempts(ctx, key)
+		var got time.Duration
+		if !attempts.LockedUntil.IsZero() {
+			got = attempts.LockedUntil.Sub(now)
+		}
+		if got != want {
+			t.Errorf("after %d failures: locked for %v, want %v", failures+1, got, want)
+		}
+	}
+
+	if wait := s.loginRetryAfter(ctx, "alice", ""); wait <= 14*time.Minute {
+		t.Errorf("loginRetryAfter after lockout: got %v", wait)
+	}
+	// Usernames are case-sensitive, so another account is unaffected
+	if wait := s.loginRetryAfter(ctx, "Alice", ""); wait != 0 {
+		t.Errorf("loginRetryAfter for Alice: got %v, want 0", wait)
+	}
+}
+
+// A long run of failures from one address must never unlock it.
+func TestApplyThrottleIPNeverLapses(t *testing.T) {
+	s, memory := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	key := ipKey("192.0.2.1")
+
+	for failures := 1; failures <= s.ipThrottle.lockoutAfter; failures++ {
+		s.applyThrottle(ctx, s.ipThrottle, key, "alice", "192.0.2.1", now)
+		if failures < s.ipThrottle.backoffAfter {
+			continue
+		}
+		attempts, _ := memory.GetLoginAttempts(ctx, key)
+		if !attempts.LockedUntil.After(now) {
+			t.Fatalf("after %d failures the address is not throttled (locked until %v)", failures, attempts.LockedUntil)
+		}
+	}
+}
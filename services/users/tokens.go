@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+
+	users "kubechat/proto/users"
+)
+
+// revokedSubject carries users.RevokedToken events so gateways can refuse
+// revoked tokens and disconnect their sessions immediately.
+const revokedSubject = "auth.revoked"
+
+var refreshReuse = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "users_refresh_token_reuse_total",
+	Help: "Number of refresh tokens presented after they had been rotated",
+})
+
+// issueTokens creates an access token and a refresh token for the session.
+func (s *server) issueTokens(ctx context.Context, userID, sessionID string) (accessToken, refreshToken string, err error) {
+	now := time.Now().UTC()
+	tokenID := generateID()
+	accessExpiresAt := now.Add(s.accessTTL)
+
+	restricted := s.messagingRestricted(ctx, userID)
+	accessToken, err = s.generateJWT(userID, sessionID, tokenID, now, accessExpiresAt, restricted)
+	if err != nil {
+		return "", "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	refreshToken = hex.EncodeToString(buf)
+
+	err = s.tokens.SaveRefreshToken(ctx, &RefreshToken{
+		TokenHash:       hashToken(refreshToken),
+		UserID:          userID,
+		SessionID:       sessionID,
+		AccessTokenID:   tokenID,
+		AccessExpiresAt: accessExpiresAt,
+		ExpiresAt:       now.Add(s.refreshTTL),
+		CreatedAt:       now,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func (s *server) RefreshToken(ctx context.Context, req *users.RefreshTokenRequest) (*users.RefreshTokenResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	token, err := s.tokens.UseRefreshToken(ctx, hashToken(req.RefreshToken), now)
+	switch {
+	case errors.Is(err, ErrRefreshTokenNotFound):
+		return &users.RefreshTokenResponse{
+			Success: false,
+			Message: "Invalid refresh token",
+		}, nil
+	case errors.Is(err, ErrRefreshTokenReused):
+		// A rotated token coming back means it leaked; end the whole session
+		// so neither the thief nor the owner can continue with it
+		refreshReuse.Inc()
+		log.Printf("Refresh token reuse for user %s, revoking session %s", token.UserID, token.SessionID)
+		s.revokeSession(ctx, token.UserID, token.SessionID)
+		return &users.RefreshTokenResponse{
+			Success: false,
+			Message: "Refresh token reuse detected; please log in again",
+		}, nil
+	case err != nil:
+		return &users.RefreshTokenResponse{
+			Success: false,
+			Message: "Failed to refresh token",
+		}, err
+	}
+
+	if !token.RevokedAt.IsZero() || !token.ExpiresAt.After(now) {
+		return &users.RefreshTokenResponse{
+			Success: false,
+			Message: "Invalid refresh token",
+		}, nil
+	}
+
+	accessToken, refreshToken, err := s.issueTokens(ctx, token.UserID, token.SessionID)
+	if err != nil {
+		return &users.RefreshTokenResponse{
+			Success: false,
+			Message: "Failed to generate token",
+		}, err
+	}
+
+	// Sessions from before session tracking have no record to update
+	err = s.sessions.TouchSession(ctx, token.SessionID, req.IpAddress,
+		truncate(req.UserAgent, maxUserAgentLength), now, now.Add(s.refreshTTL))
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		log.Printf("Failed to update session %s: %v", token.SessionID, err)
+	}
+
+	return &users.RefreshTokenResponse{
+		UserId:       token.UserID,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+		Success:      true,
+		Message:      "Token refreshed",
+	}, nil
+}
+
+func (s *server) Logout(ctx context.Context, req *users.LogoutRequest) (*users.LogoutResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if req.SessionId == "" {
+		return &users.LogoutResponse{
+			Success: false,
+			Message: "Session ID required",
+		}, nil
+	}
+
+	if err := s.revokeSession(ctx, req.UserId, req.SessionId); err != nil {
+		return &users.LogoutResponse{
+			Success: false,
+			Message: "Failed to log out",
+		}, err
+	}
+
+	return &users.LogoutResponse{
+		Success: true,
+		Message: "Logged out",
+	}, nil
+}
+
+func (s *server) LogoutAllDevices(ctx context.Context, req *users.LogoutAllDevicesRequest) (*users.LogoutResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	revoked, err := s.tokens.RevokeUserSessions(ctx, req.UserId, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", req.UserId, err)
+		return &users.LogoutResponse{
+			Success: false,
+			Message: "Failed to log out",
+		}, err
+	}
+	s.publishRevocations(revoked, RevokedToken{UserID: req.UserId})
+
+	return &users.LogoutResponse{
+		Success: true,
+		Message: "Logged out on all devices",
+	}, nil
+}
+
+func (s *server) ListRevokedTokens(ctx context.Context, req *users.ListRevokedTokensRequest) (*users.ListRevokedTokensResponse, error) {
+	revoked, err := s.tokens.ListRevokedTokens(ctx, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &users.ListRevokedTokensResponse{}
+	for _, entry := range revoked {
+		resp.Tokens = append(resp.Tokens, toRevokedTokenProto(entry))
+	}
+	return resp, nil
+}
+
+func (s *server) revokeSession(ctx context.Context, userID, sessionID string) error {
+	revoked, err := s.tokens.RevokeSession(ctx, sessionID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID, err)
+		return err
+	}
+	s.publishRevocations(revoked, RevokedToken{UserID: userID, SessionID: sessionID})
+	return nil
+}
+
+// publishRevocations tells gateways about newly denied access tokens. The
+// scope events, which have no token ID, are always sent because live WebSocket
+// connections may have been opened with access tokens that already expired.
+func (s *server) publishRevocations(revoked []RevokedToken, scopes ...RevokedToken) {
+	if s.natsConn == nil {
+		return
+	}
+
+	for _, entry := range append(revoked, scopes...) {
+		data, err := json.Marshal(toRevokedTokenProto(entry))
+		if err != nil {
+			log.Printf("Failed to marshal revocation: %v", err)
+			continue
+		}
+		if err := s.natsConn.Publish(revokedSubject, data); err != nil {
+			log.Printf("Failed to publish revocation: %v", err)
+		}
+	}
+}
+
+func toRevokedTokenProto(entry RevokedToken) *users.RevokedToken {
+	var expiresAt int64
+	if !entry.ExpiresAt.IsZero() {
+		expiresAt = entry.ExpiresAt.Unix()
+	}
+	return &users.RevokedToken{
+		TokenId:   entry.TokenID,
+		UserId:    entry.UserID,
+		SessionId: entry.SessionID,
+		ExpiresAt: expiresAt,
+	}
+}
+
+// generateJWT signs an access token. Restricted tokens carry
+// email_verified=false, on which gateways refuse to send messages.
+func (s *server) generateJWT(userID, sessionID, tokenID string, issuedAt, expiresAt time.Time, restricted bool) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"sid":     sessionID,
+		"jti":     tokenID,
+		"iat":     issuedAt.Unix(),
+		"exp":     expiresAt.Unix(),
+	}
+	if restricted {
+		claims["email_verified"] = false
+	}
+	return s.keys.sign(claims)
+}
+
+// hashToken is how refresh tokens are stored; they are random, so a plain
+// SHA-256 is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
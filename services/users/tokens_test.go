@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	users "kubechat/proto/users"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s, memory := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if err := memory.CreateSession(ctx, &Session{ID: "s-1", UserID: "u-1", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	_, first, err := s.issueTokens(ctx, "u-1", "s-1")
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+
+	resp, err := s.RefreshToken(ctx, &users.RefreshTokenRequest{RefreshToken: first})
+	if err != nil || !resp.Success {
+		t.Fatalf("first refresh: got %v, %v", resp, err)
+	}
+	second := resp.RefreshToken
+	if second == "" || second == first {
+		t.Fatalf("refresh did not rotate the token")
+	}
+
+	// Presenting the rotated token again ends the whole session, including
+	// the token the legitimate client was just given
+	resp, err = s.RefreshToken(ctx, &users.RefreshTokenRequest{RefreshToken: first})
+	if err != nil || resp.Success {
+		t.Fatalf("reused refresh: got %v, %v, want refusal", resp, err)
+	}
+	session, err := memory.GetSession(ctx, "s-1")
+	if err != nil || session.RevokedAt.IsZero() {
+		t.Errorf("session after reuse: got %v, %v, want revoked", session, err)
+	}
+	resp, err = s.RefreshToken(ctx, &users.RefreshTokenRequest{RefreshToken: second})
+	if err != nil || resp.Success {
+		t.Errorf("refresh with the newest token after reuse: got %v, %v, want refusal", resp, err)
+	}
+
+	// Both access tokens of the session are denied
+	denied, _ := memory.ListRevokedTokens(ctx, time.Now().UTC())
+	if len(denied) != 2 {
+		t.Errorf("denylisted access tokens: got %d, want 2", len(denied))
+	}
+	for _, entry := range denied {
+		if entry.SessionID != "s-1" {
+			t.Errorf("denylisted token of session %q", entry.SessionID)
+		}
+	}
+}
+
+func TestRefreshTokenUnknown(t *testing.T) {
+	s, _ := newTestServer(t)
+	resp, err := s.RefreshToken(context.Background(), &users.RefreshTokenRequest{RefreshToken: "not-a-token"})
+	if err != nil || resp.Success {
+		t.Errorf("unknown refresh token: got %v, %v, want refusal", resp, err)
+	}
+}
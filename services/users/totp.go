@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	users "kubechat/proto/users"
+)
+
+const (
+	// TOTP parameters from RFC 6238, which authenticator apps default to
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods either side of now are accepted, for
+	// clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	// A login challenge must be answered within challengeTTL and allows
+	// maxChallengeAttempts codes, so a stolen password cannot be used to
+	// guess a six-digit code
+	challengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the code for a time step (RFC 4226 dynamic truncation).
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// validateTOTP checks code against the steps around now and returns the step
+// it matched, which the caller records to refuse replays.
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current + totpSkew; step >= current-totpSkew; step-- {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func generateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(buf), nil
+}
+
+// generateRecoveryCodes returns codes formatted as XXXX-XXXX-XXXX-XXXX and
+// their hashes for storage.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := base32NoPadding.EncodeToString(buf)
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed in either case, with or without
+// separators.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code.
+func (s *server) verifySecondFactor(ctx context.Context, totp *TOTP, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	now := time.Now().UTC()
+
+	if len(code) == totpDigits {
+		step, ok := validateTOTP(totp.Secret, code, now)
+		if !ok {
+			return false, nil
+		}
+		err := s.twoFactor.UseTOTPStep(ctx, totp.UserID, step)
+		if errors.Is(err, ErrTOTPCodeReused) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err := s.twoFactor.UseRecoveryCode(ctx, totp.UserID, hashToken(normalizeRecoveryCode(code)), now)
+	if errors.Is(err, ErrRecoveryCodeNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// startLoginChallenge records a login that still needs a second factor and
+// returns the token that identifies it.
+func (s *server) startLoginChallenge(ctx context.Context, userID string, req *users.LoginUserRequest) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base32NoPadding.EncodeToString(buf)
+
+	err := s.twoFactor.SaveLoginChallenge(ctx, &LoginChallenge{
+		TokenHash:  hashToken(token),
+		UserID:     userID,
+		DeviceName: truncate(req.DeviceName, maxDeviceNameLength),
+		UserAgent:  truncate(req.UserAgent, maxUserAgentLength),
+		IPAddress:  req.IpAddress,
+		ExpiresAt:  time.Now().UTC().Add(challengeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *server) EnrollTOTP(ctx context.Context, req *users.EnrollTOTPRequest) (*users.EnrollTOTPResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	user, err := s.store.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return &users.EnrollTOTPResponse{
+			Success: false,
+			Message: "User not found",
+		}, nil
+	}
+
+	existing, err := s.twoFactor.GetTOTP(ctx, user.ID)
+	if err == nil && existing.Enabled {
+		return &users.EnrollTOTPResponse{
+			Success: false,
+			Message: "Two-factor authentication is already enabled",
+		}, nil
+	}
+	if err != nil && !errors.Is(err, ErrTOTPNotFound) {
+		return &users.EnrollTOTPResponse{
+			Success: false,
+			Message: "Failed to enroll",
+		}, err
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return &users.EnrollTOTPResponse{
+			Success: false,
+			Message: "Failed to generate secret",
+		}, err
+	}
+	err = s.twoFactor.SavePendingTOTP(ctx, &TOTP{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to save TOTP secret for user %s: %v", user.ID, err)
+		return &users.EnrollTOTPResponse{
+			Success: false,
+			Message: "Failed to enroll",
+		}, err
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", s.totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + s.totpIssuer + ":" + user.Username,
+		RawQuery: query.Encode(),
+	}
+
+	return &users.EnrollTOTPResponse{
+		Success:    true,
+		Message:    "Scan the code, then confirm with a code from your app",
+		Secret:     secret,
+		OtpauthUri: uri.String(),
+	}, nil
+}
+
+func (s *server) ConfirmTOTP(ctx context.Context, req *users.ConfirmTOTPRequest) (*users.ConfirmTOTPResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	totp, err := s.twoFactor.GetTOTP(ctx, req.UserId)
+	if errors.Is(err, ErrTOTPNotFound) {
+		return &users.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Start enrollment first",
+		}, nil
+	}
+	if err != nil {
+		return &users.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Failed to confirm",
+		}, err
+	}
+	if totp.Enabled {
+		return &users.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Two-factor authentication is already enabled",
+		}, nil
+	}
+
+	// Only a TOTP code proves the app was set up; there are no recovery
+	// codes yet
+	step, ok := validateTOTP(totp.Secret, strings.TrimSpace(req.Code), time.Now().UTC())
+	if !ok {
+		return &users.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Invalid code",
+		}, nil
+	}
+	if err := s.twoFactor.UseTOTPStep(ctx, totp.UserID, step); err != nil {
+		return &users.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Invalid code",
+		}, nil
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return &users.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Failed to generate recovery codes",
+		}, err
+	}
+	if err := s.twoFactor.EnableTOTP(ctx, totp.UserID, hashes); err != nil {
+		log.Printf("Failed to enable TOTP for user %s: %v", totp.UserID, err)
+		return &users.ConfirmTOTPResponse{
+			Success: false,
+			Message: "Failed to confirm",
+		}, err
+	}
+
+	return &users.ConfirmTOTPResponse{
+		Success:       true,
+		Message:       "Two-factor authentication enabled",
+		RecoveryCodes: codes,
+	}, nil
+}
+
+func (s *server) DisableTOTP(ctx context.Context, req *users.DisableTOTPRequest) (*users.DisableTOTPResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	user, err := s.store.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return &users.DisableTOTPResponse{
+			Success: false,
+			Message: "User not found",
+		}, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		return &users.DisableTOTPResponse{
+			Success: false,
+			Message: "Invalid password or code",
+		}, nil
+	}
+
+	totp, err := s.twoFactor.GetTOTP(ctx, user.ID)
+	if errors.Is(err, ErrTOTPNotFound) || (err == nil && !totp.Enabled) {
+		return &users.DisableTOTPResponse{
+			Success: false,
+			Message: "Two-factor authentication is not enabled",
+		}, nil
+	}
+	if err != nil {
+		return &users.DisableTOTPResponse{
+			Success: false,
+			Message: "Failed to disable",
+		}, err
+	}
+
+	ok, err := s.verifySecondFactor(ctx, totp, req.Code)
+	if err != nil {
+		return &users.DisableTOTPResponse{
+			Success: false,
+			Message: "Failed to disable",
+		}, err
+	}
+	if !ok {
+		return &users.DisableTOTPResponse{
+			Success: false,
+			Message: "Invalid password or code",
+		}, nil
+	}
+
+	if err := s.twoFactor.DisableTOTP(ctx, user.ID); err != nil {
+		return &users.DisableTOTPResponse{
+			Success: false,
+			Message: "Failed to disable",
+		}, err
+	}
+
+	return &users.DisableTOTPResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	}, nil
+}
+
+// VerifyLoginTOTP completes a login started by LoginUser for an account with
+// two-factor authentication.
+func (s *server) VerifyLoginTOTP(ctx context.Context, req *users.VerifyLoginTOTPRequest) (*users.LoginUserResponse, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tokenHash := hashToken(req.ChallengeToken)
+	challenge, err := s.twoFactor.AttemptLoginChallenge(ctx, tokenHash)
+	if errors.Is(err, ErrChallengeNotFound) {
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Login expired; please log in again",
+		}, nil
+	}
+	if err != nil {
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Failed to verify code",
+		}, err
+	}
+	if challenge.Attempts > maxChallengeAttempts || !challenge.ExpiresAt.After(time.Now().UTC()) {
+		s.twoFactor.DeleteLoginChallenge(ctx, tokenHash)
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Login expired; please log in again",
+		}, nil
+	}
+
+	// Failed codes count against the account like failed passwords, so
+	// starting new challenges does not allow unlimited guesses
+	user, err := s.store.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Failed to verify code",
+		}, err
+	}
+	if wait := s.loginRetryAfter(ctx, user.Username, challenge.IPAddress); wait > 0 {
+		return throttledResponse(wait), nil
+	}
+
+	totp, err := s.twoFactor.GetTOTP(ctx, challenge.UserID)
+	if err != nil {
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Failed to verify code",
+		}, err
+	}
+	ok, err := s.verifySecondFactor(ctx, totp, req.Code)
+	if err != nil {
+		return &users.LoginUserResponse{
+			Success: false,
+			Message: "Failed to verify code",
+		}, err
+	}
+	if !ok {
+		s.recordLoginFailure(ctx, user.Username, challenge.IPAddress)
+		return &users.LoginUserResponse{
+			Success:           false,
+			Message:           "Invalid code",
+			TwoFactorRequired: true,
+			ChallengeToken:    req.ChallengeToken,
+		}, nil
+	}
+
+	if err := s.twoFactor.DeleteLoginChallenge(ctx, tokenHash); err != nil {
+		log.Printf("Failed to delete login challenge: %v", err)
+	}
+	if err := s.throttle.ClearLoginAttempts(ctx, accountKey(user.Username)); err != nil {
+		log.Printf("Failed to clear login attempts of %s: %v", user.Username, err)
+	}
+
+	return s.completeLogin(ctx, challenge.UserID, &users.LoginUserRequest{
+		DeviceName: challenge.DeviceName,
+		UserAgent:  challenge.UserAgent,
+		IpAddress:  challenge.IPAddress,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTOTP(t *testing.T, memory *memoryStore) (*TOTP, []string) {
+	t.Helper()
+	ctx := context.Background()
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatalf("generateTOTPSecret: %v", err)
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("generateRecoveryCodes: %v", err)
+	}
+	totp := &TOTP{UserID: "u-1", Secret: secret, CreatedAt: time.Now().UTC()}
+	if err := memory.SavePendingTOTP(ctx, totp); err != nil {
+		t.Fatalf("SavePendingTOTP: %v", err)
+	}
+	if err := memory.EnableTOTP(ctx, "u-1", hashes); err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+	return totp, codes
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, _ := generateTOTPSecret()
+	key, _ := base32NoPadding.DecodeString(secret)
+	now := time.Now()
+	current := now.Unix() / totpPeriod
+
+	for offset, want := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		step, ok := validateTOTP(secret, totpCode(key, current+offset), now)
+		if ok != want {
+			t.Errorf("code for step offset %d: accepted %v, want %v", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code for step offset %d matched step %d", offset, step-current)
+		}
+	}
+	if _, ok := validateTOTP(secret, "12345", now); ok {
+		t.Error("accepted a five-digit code")
+	}
+}
+
+func TestSecondFactorTOTPNotReplayable(t *testing.T) {
+	s, memory := newTestServer(t)
+	ctx := context.Background()
+	totp, _ := newTestTOTP(t, memory)
+	key, _ := base32NoPadding.DecodeString(totp.Secret)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+
+	if ok, err := s.verifySecondFactor(ctx, totp, code); !ok || err != nil {
+		t.Fatalf("first use of code: got %v, %v", ok, err)
+	}
+	if ok, err := s.verifySecondFactor(ctx, totp, code); ok || err != nil {
+		t.Errorf("replayed code: got %v, %v, want refusal", ok, err)
+	}
+	// An older step inside the skew window is refused too
+	previous := totpCode(key, time.Now().Unix()/totpPeriod-1)
+	if ok, _ := s.verifySecondFactor(ctx, totp, previous); ok {
+		t.Error("accepted a code older than the last one used")
+	}
+}
+
+func TestSecondFactorRecoveryCodeSingleUse(t *testing.T) {
+	s, memory := newTestServer(t)
+	ctx := context.Background()
+	totp, codes := newTestTOTP(t, memory)
+
+	// Codes may be typed in lower case and without separators
+	typed := strings.ToLower(strings.ReplaceAll(codes[0], "-", ""))
+	if ok, err := s.verifySecondFactor(ctx, totp, " "+typed+" "); !ok || err != nil {
+		t.Fatalf("first use of recovery code: got %v, %v", ok, err)
+	}
+	if ok, err := s.verifySecondFactor(ctx, totp, codes[0]); ok || err != nil {
+		t.Errorf("reused recovery code: got %v, %v, want refusal", ok, err)
+	}
+	if ok, err := s.verifySecondFactor(ctx, totp, codes[1]); !ok || err != nil {
+		t.Errorf("another recovery code: got %v, %v", ok, err)
+	}
+	if ok, _ := s.verifySecondFactor(ctx, totp, "AAAA-BBBB-CCCC-DDDD"); ok {
+		t.Error("accepted an unknown recovery code")
+	}
+}